@@ -0,0 +1,276 @@
+// Package apierror has the errors the REST API sends back. Each one has a
+// code clients can check for, and is sent as an RFC 7807 problem+json body.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Code says what went wrong, clients should check it rather than the detail
+type Code string
+
+const (
+	// The request itself is wrong
+	MalformedRequest  Code = "MALFORMED_REQUEST"
+	InvalidParameter  Code = "INVALID_PARAMETER"
+	ValidationFailed  Code = "VALIDATION_FAILED"
+	Unauthenticated   Code = "UNAUTHENTICATED"
+	Forbidden         Code = "FORBIDDEN"
+	RouteNotFound     Code = "ROUTE_NOT_FOUND"
+	MethodNotAllowed  Code = "METHOD_NOT_ALLOWED"
+	EventNotFound     Code = "EVENT_NOT_FOUND"
+	LevelNotFound     Code = "LEVEL_NOT_FOUND"
+	SponsorNotFound   Code = "SPONSOR_NOT_FOUND"
+	MemberNotFound    Code = "MEMBER_NOT_FOUND"
+	InviteNotFound    Code = "INVITE_NOT_FOUND"
+	APIKeyNotFound    Code = "API_KEY_NOT_FOUND"
+	LevelNotInEvent   Code = "LEVEL_NOT_IN_EVENT"
+	LevelExists       Code = "LEVEL_EXISTS"
+	SponsorExists     Code = "SPONSOR_EXISTS"
+	MemberExists      Code = "MEMBER_EXISTS"
+	LevelFull         Code = "LEVEL_FULL"
+	BadgeLimitReached Code = "BADGE_LIMIT_REACHED"
+
+	// Idempotency-Key problems
+	InvalidIdempotencyKey Code = "INVALID_IDEMPOTENCY_KEY"
+	IdempotencyKeyReused  Code = "IDEMPOTENCY_KEY_REUSED"
+	RequestInProgress     Code = "IDEMPOTENCY_REQUEST_IN_PROGRESS"
+	BodyTooLarge          Code = "BODY_TOO_LARGE"
+
+	// If-Match didn't match
+	PreconditionFailed Code = "PRECONDITION_FAILED"
+
+	// Waitlists
+	WaitlistEntryNotFound Code = "WAITLIST_ENTRY_NOT_FOUND"
+	AlreadyWaitlisted     Code = "ALREADY_WAITLISTED"
+	AlreadyOnLevel        Code = "ALREADY_ON_LEVEL"
+	NoWaitlistOffer       Code = "NO_WAITLIST_OFFER"
+
+	// Reservations
+	ReservationNotFound Code = "RESERVATION_NOT_FOUND"
+	ReservationExpired  Code = "RESERVATION_EXPIRED"
+
+	// Sponsor statuses
+	InvalidStatusTransition Code = "INVALID_STATUS_TRANSITION"
+	SponsorHasNoLevel       Code = "SPONSOR_HAS_NO_LEVEL"
+	SponsorCancelled        Code = "SPONSOR_CANCELLED"
+
+	// Invoices
+	InvoiceNotFound          Code = "INVOICE_NOT_FOUND"
+	LevelNotPriced           Code = "LEVEL_NOT_PRICED"
+	InvalidInvoiceTransition Code = "INVALID_INVOICE_TRANSITION"
+	InvoiceTooLarge          Code = "INVOICE_TOO_LARGE"
+
+	// Payments
+	InvoiceNotPayable Code = "INVOICE_NOT_PAYABLE"
+	RefundTooLarge    Code = "REFUND_TOO_LARGE"
+
+	// Benefits and deliverables
+	BenefitNotFound     Code = "BENEFIT_NOT_FOUND"
+	BenefitExists       Code = "BENEFIT_EXISTS"
+	DeliverableNotFound Code = "DELIVERABLE_NOT_FOUND"
+
+	// Logos
+	LogoNotFound  Code = "LOGO_NOT_FOUND"
+	AssetNotFound Code = "ASSET_NOT_FOUND"
+
+	// Badges
+	BadgeNotFound          Code = "BADGE_NOT_FOUND"
+	BadgeExists            Code = "BADGE_EXISTS"
+	InvalidBadgeTransition Code = "INVALID_BADGE_TRANSITION"
+
+	// We're at fault
+	NotImplemented Code = "NOT_IMPLEMENTED"
+	Internal       Code = "INTERNAL_ERROR"
+)
+
+var statuses = map[Code]int{
+	MalformedRequest:  http.StatusBadRequest,
+	InvalidParameter:  http.StatusBadRequest,
+	ValidationFailed:  http.StatusUnprocessableEntity,
+	Unauthenticated:   http.StatusUnauthorized,
+	Forbidden:         http.StatusForbidden,
+	RouteNotFound:     http.StatusNotFound,
+	MethodNotAllowed:  http.StatusMethodNotAllowed,
+	EventNotFound:     http.StatusNotFound,
+	LevelNotFound:     http.StatusNotFound,
+	SponsorNotFound:   http.StatusNotFound,
+	MemberNotFound:    http.StatusNotFound,
+	InviteNotFound:    http.StatusNotFound,
+	APIKeyNotFound:    http.StatusNotFound,
+	LevelNotInEvent:   http.StatusUnprocessableEntity,
+	LevelExists:       http.StatusConflict,
+	SponsorExists:     http.StatusConflict,
+	MemberExists:      http.StatusConflict,
+	LevelFull:         http.StatusConflict,
+	BadgeLimitReached: http.StatusConflict,
+
+	InvalidIdempotencyKey: http.StatusBadRequest,
+	IdempotencyKeyReused:  http.StatusConflict,
+	RequestInProgress:     http.StatusConflict,
+	BodyTooLarge:          http.StatusRequestEntityTooLarge,
+
+	PreconditionFailed: http.StatusPreconditionFailed,
+
+	WaitlistEntryNotFound: http.StatusNotFound,
+	AlreadyWaitlisted:     http.StatusConflict,
+	AlreadyOnLevel:        http.StatusConflict,
+	NoWaitlistOffer:       http.StatusConflict,
+
+	ReservationNotFound: http.StatusNotFound,
+	ReservationExpired:  http.StatusConflict,
+
+	InvalidStatusTransition: http.StatusConflict,
+	SponsorHasNoLevel:       http.StatusConflict,
+	SponsorCancelled:        http.StatusConflict,
+
+	InvoiceNotFound:          http.StatusNotFound,
+	LevelNotPriced:           http.StatusConflict,
+	InvalidInvoiceTransition: http.StatusConflict,
+	InvoiceTooLarge:          http.StatusUnprocessableEntity,
+
+	InvoiceNotPayable: http.StatusConflict,
+	RefundTooLarge:    http.StatusConflict,
+
+	BenefitNotFound:     http.StatusNotFound,
+	BenefitExists:       http.StatusConflict,
+	DeliverableNotFound: http.StatusNotFound,
+
+	LogoNotFound:  http.StatusNotFound,
+	AssetNotFound: http.StatusNotFound,
+
+	BadgeNotFound:          http.StatusNotFound,
+	BadgeExists:            http.StatusConflict,
+	InvalidBadgeTransition: http.StatusConflict,
+
+	NotImplemented: http.StatusNotImplemented,
+	Internal:       http.StatusInternalServerError,
+}
+
+// Codes lists every code, sorted
+func Codes() []Code {
+	codes := make([]Code, 0, len(statuses))
+	for c := range statuses {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// Status is the HTTP status code sent with code
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Type is the problem type URI, like urn:sponsor-service:problem:event-not-found
+func (c Code) Type() string {
+	return "urn:sponsor-service:problem:" + strings.ReplaceAll(strings.ToLower(string(c)), "_", "-")
+}
+
+// Error is something that went wrong handling a request
+type Error struct {
+	Code Code
+	// Sent to the client, so never anything internal
+	Detail string
+	// Set for VALIDATION_FAILED
+	Fields validation.Errors
+	// What caused it, only ever logged
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s | %s", e.Code, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Wrap keeps err as the cause, so it's logged but never sent
+func Wrap(code Code, err error, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Detail: fmt.Sprintf(format, args...), Err: err}
+}
+
+// InternalError hides err from the client behind a generic detail
+func InternalError(err error) *Error {
+	return Wrap(Internal, err, "something went wrong on our side, try again later")
+}
+
+func Validation(fields validation.Errors) *Error {
+	return &Error{Code: ValidationFailed, Detail: "request body is invalid", Fields: fields}
+}
+
+// Problem is an RFC 7807 problem details body, with our code and
+// the request's correlation ID as extensions
+type Problem struct {
+	Type          string            `json:"type"`
+	Title         string            `json:"title"`
+	Status        int               `json:"status"`
+	Detail        string            `json:"detail,omitempty"`
+	Instance      string            `json:"instance,omitempty"`
+	Code          Code              `json:"code"`
+	CorrelationID string            `json:"correlationId,omitempty"`
+	Errors        validation.Errors `json:"errors,omitempty"`
+}
+
+const ContentType = "application/problem+json"
+
+// Write sends err as a problem. Errors that aren't an *Error are sent
+// as INTERNAL_ERROR without their message, and 5xx errors are logged.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = InternalError(err)
+	}
+	status := e.Code.Status()
+	if status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", logging.Fields{
+			"code":  e.Code,
+			"error": err,
+		})
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:          e.Code.Type(),
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        e.Detail,
+		Instance:      r.URL.Path,
+		Code:          e.Code,
+		CorrelationID: logging.CorrelationID(r.Context()),
+		Errors:        e.Fields,
+	})
+}
+
+// NotFoundHandler is for requests no route matches
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(RouteNotFound, "there is nothing at %s", r.URL.Path))
+	})
+}
+
+// MethodNotAllowedHandler is for routes that don't take the request's method
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, New(MethodNotAllowed, "%s can't be used on %s", r.Method, r.URL.Path))
+	})
+}
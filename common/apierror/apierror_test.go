@@ -0,0 +1,86 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCodes(t *testing.T) {
+	for _, c := range Codes() {
+		if c.Status() < 400 {
+			t.Errorf("%s has status %d, want an error status", c, c.Status())
+		}
+		if !strings.HasPrefix(c.Type(), "urn:sponsor-service:problem:") || strings.ContainsAny(c.Type(), "_ ") {
+			t.Errorf("%s has type %q", c, c.Type())
+		}
+	}
+	if got := EventNotFound.Type(); got != "urn:sponsor-service:problem:event-not-found" {
+		t.Errorf("got %q", got)
+	}
+	if got := Code("SOMETHING_NEW").Status(); got != http.StatusInternalServerError {
+		t.Errorf("a code with no status got %d, want 500", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	logging.SetOutput(ioutil.Discard)
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+		detail string
+	}{
+		{"error", New(LevelFull, "level %d is full", 3), 409, LevelFull, "level 3 is full"},
+		{"wrapped cause", Wrap(EventNotFound, cause, "event %d does not exist", 9), 404, EventNotFound, "event 9 does not exist"},
+		{"plain error", cause, 500, Internal, "something went wrong on our side, try again later"},
+		{"validation", Validation(validation.Errors{{Field: "name", Rule: "required", Message: "is required"}}), 422, ValidationFailed, "request body is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/sponsor-service/v1/event/9", nil)
+			req = req.WithContext(logging.WithCorrelationID(req.Context(), "abc"))
+			rec := httptest.NewRecorder()
+			Write(rec, req, tt.err)
+
+			if rec.Code != tt.status || rec.Header().Get("Content-Type") != ContentType {
+				t.Errorf("got %d %s, want %d %s", rec.Code, rec.Header().Get("Content-Type"), tt.status, ContentType)
+			}
+			var p Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.code || p.Status != tt.status || p.Detail != tt.detail || p.Type != tt.code.Type() {
+				t.Errorf("got %+v, want %s with %q", p, tt.code, tt.detail)
+			}
+			if p.Instance != "/sponsor-service/v1/event/9" || p.CorrelationID != "abc" {
+				t.Errorf("got instance %q and correlation ID %q", p.Instance, p.CorrelationID)
+			}
+			if strings.Contains(rec.Body.String(), cause.Error()) {
+				t.Errorf("the cause was sent: %s", rec.Body.String())
+			}
+			if tt.code == ValidationFailed && len(p.Errors) != 1 {
+				t.Errorf("got errors %v, want the name's", p.Errors)
+			}
+		})
+	}
+}
+
+func TestErrorKeepsItsCause(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := Wrap(Internal, cause, "could not save")
+	if !errors.Is(err, cause) {
+		t.Error("the cause is not unwrapped")
+	}
+	if got := err.Error(); got != "INTERNAL_ERROR: could not save | connection refused" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// Header clients send their API key in. "Authorization: ApiKey <key>" works too.
+const APIKeyHeader = "X-API-Key"
+
+// Every key we generate starts with this, so leaked keys are easy to grep for
+const apiKeyPrefix = "sps_"
+
+// How much of a key is kept in the clear to tell keys apart
+const displayPrefixLength = 12
+
+var errInvalidAPIKey = invalidCredentials("invalid or revoked API key")
+
+// GenerateAPIKey makes a new random key. Hand the key to the client and
+// only store the prefix and hash.
+func GenerateAPIKey() (key string, prefix string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(secret)
+	return key, DisplayPrefix(key), HashAPIKey(key), nil
+}
+
+// Keys are long and random, so a plain sha256 is enough,
+// and lets us look a key up by its hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func DisplayPrefix(key string) string {
+	if len(key) <= displayPrefixLength {
+		return key
+	}
+	return key[:displayPrefixLength]
+}
+
+// APIKeys checks API keys against the hashes stored in the database
+type APIKeys struct{}
+
+func (APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		scheme, credentials := splitAuthorization(r)
+		if !strings.EqualFold(scheme, "ApiKey") {
+			return nil, ErrNoCredentials
+		}
+		key = credentials
+	}
+
+	stored, err := db.GetAPIKeyByHash(r.Context(), HashAPIKey(key))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Revoked() {
+		return nil, errInvalidAPIKey
+	}
+
+	p := &Principal{
+		Subject: "apiKey:" + stored.Name,
+		Method:  "apiKey",
+		Admin:   stored.Admin,
+		Role:    stored.Role,
+	}
+	if stored.SponsorID != nil {
+		p.SponsorID = *stored.SponsorID
+	}
+	return p, nil
+}
+
+// EnsureBootstrapKey stores key as an admin key while there are no keys
+// at all, so a fresh install can create its first real keys
+func EnsureBootstrapKey(ctx context.Context, key string) error {
+	if key == "" {
+		return nil
+	}
+	count, err := db.CountAPIKeys(ctx)
+	if err != nil || count > 0 {
+		return err
+	}
+	if _, err := db.CreateAPIKey(ctx, "bootstrap", DisplayPrefix(key), HashAPIKey(key), true, RoleOrganizer, nil); err != nil {
+		return err
+	}
+	logging.Info("created the bootstrap admin API key, revoke it once you have made your own keys")
+	return nil
+}
+
+// Returns the scheme and credentials of the Authorization header
+func splitAuthorization(r *http.Request) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
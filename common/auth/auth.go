@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"net/http"
+)
+
+// Principal is whoever made the request
+type Principal struct {
+	// API key name or JWT subject
+	Subject string `json:"subject"`
+	// "apiKey", "jwt", "invite" or "webhook"
+	Method string `json:"method"`
+	// Can manage API keys
+	Admin bool `json:"admin"`
+	// One of the Role constants, decides what the caller can do with events and sponsors
+	Role string `json:"role"`
+	// Sponsor a sponsor admin manages
+	SponsorID int `json:"sponsorId,omitempty"`
+}
+
+// Authenticator checks one kind of credentials. Authenticate returns
+// ErrNoCredentials when the request doesn't carry that kind at all,
+// so the middleware can try the next Authenticator.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+var ErrNoCredentials = errors.New("an API key or bearer token is required")
+
+// Authenticators wrap this when credentials are there but wrong,
+// anything else is treated as the authenticator failing
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+func invalidCredentials(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, reason)
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns who made the request, nil when auth is turned off
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// Middleware rejects requests that none of the authenticators accept
+// with a 401, and puts the Principal on the request's context otherwise
+func Middleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, a := range authenticators {
+				p, err := a.Authenticate(r)
+				if err == ErrNoCredentials {
+					continue
+				}
+				if errors.Is(err, ErrInvalidCredentials) {
+					logging.FromContext(r.Context()).Warn("authentication failed", logging.Fields{"error": err})
+					unauthorized(w, r, err)
+					return
+				}
+				if err != nil {
+					apierror.Write(w, r, apierror.Wrap(apierror.Internal, err, "could not check credentials"))
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+				return
+			}
+			unauthorized(w, r, ErrNoCredentials)
+		})
+	}
+}
+
+// RequireAdmin only lets admin API keys and tokens with the admin scope through
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := FromContext(r.Context())
+		if p == nil || !p.Admin {
+			apierror.Write(w, r, apierror.New(apierror.Forbidden, "this endpoint needs an admin API key or token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="sponsor-service"`)
+	apierror.Write(w, r, apierror.New(apierror.Unauthenticated, "%s", err))
+}
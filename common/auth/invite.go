@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Query parameter invite links carry their token in
+const InviteTokenParam = "token"
+
+// What's signed into an invite token. The invite's row is still checked on
+// every request, so revoking it works before the token expires.
+type inviteClaims struct {
+	InviteID  int   `json:"i"`
+	SponsorID int   `json:"s"`
+	ExpiresAt int64 `json:"e"`
+}
+
+// Invites signs and checks the tokens in sponsor invite links
+type Invites struct {
+	secret []byte
+}
+
+func NewInvites(secret string) *Invites {
+	return &Invites{secret: []byte(secret)}
+}
+
+// NewInviteSecret makes a random secret for when none is configured
+func NewInviteSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign makes the token for an invite, <claims>.<signature> both base64url encoded
+func (i *Invites) Sign(invite *db.Invite) (string, error) {
+	payload, err := json.Marshal(inviteClaims{
+		InviteID:  invite.ID,
+		SponsorID: invite.SponsorID,
+		ExpiresAt: invite.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(i.mac(encoded)), nil
+}
+
+func (i *Invites) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, i.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+func (i *Invites) verify(token string, now time.Time) (*inviteClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, invalidCredentials("malformed invite token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, i.mac(parts[0])) {
+		return nil, invalidCredentials("invite token has a bad signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, invalidCredentials("malformed invite token")
+	}
+	var claims inviteClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, invalidCredentials("malformed invite token")
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, invalidCredentials("invite link has expired")
+	}
+	return &claims, nil
+}
+
+// InviteTokenToHeader moves the token of an invite link out of the query
+// string into "Authorization: Invite <token>", so it's never traced or logged
+// with the URL. It has to run before the tracing middleware.
+func InviteTokenToHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if _, ok := query[InviteTokenParam]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := query.Get(InviteTokenParam)
+		query.Del(InviteTokenParam)
+
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		if r.Header.Get("Authorization") == "" && token != "" {
+			r.Header.Set("Authorization", "Invite "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authenticate accepts "Authorization: Invite <token>", InviteTokenToHeader
+// puts an invite link's token there. The Principal it returns can only
+// manage the members of the invite's sponsor.
+func (i *Invites) Authenticate(r *http.Request) (*Principal, error) {
+	var token string
+	if scheme, raw := splitAuthorization(r); strings.EqualFold(scheme, "Invite") {
+		token = raw
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	now := time.Now()
+	claims, err := i.verify(token, now)
+	if err != nil {
+		return nil, err
+	}
+	invite, err := db.GetInvite(r.Context(), claims.InviteID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, invalidCredentials("invite no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invite.SponsorID != claims.SponsorID {
+		return nil, invalidCredentials("invite token doesn't match the invite")
+	}
+	if !invite.Usable(now) {
+		return nil, invalidCredentials("invite link has been revoked")
+	}
+
+	return &Principal{
+		Subject:   fmt.Sprintf("invite:%d", invite.ID),
+		Method:    "invite",
+		Role:      RoleSponsorContact,
+		SponsorID: invite.SponsorID,
+	}, nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scope a token needs to use the admin endpoints
+const AdminScope = "sponsor-service:admin"
+
+// Claims that carry the caller's role, and the sponsor a sponsor admin manages
+const (
+	roleClaim      = "role"
+	sponsorIDClaim = "sponsorId"
+)
+
+// Only asymmetric algorithms, the service never holds a signing key
+var jwtAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// JWT checks bearer tokens against the public keys in a JWKS file
+type JWT struct {
+	// Public keys by key ID
+	keys map[string]interface{}
+	// Checked when not empty
+	Issuer   string
+	Audience string
+}
+
+// NewJWT loads the keys from a JSON Web Key Set file
+// (the same format as an identity provider's /.well-known/jwks.json)
+func NewJWT(jwksFile string, issuer string, audience string) (*JWT, error) {
+	data, err := ioutil.ReadFile(jwksFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read JWKS file | %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JWKS file %s | %w", jwksFile, err)
+	}
+	return &JWT{keys: keys, Issuer: issuer, Audience: audience}, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, raw := splitAuthorization(r)
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: jwtAlgorithms}
+	if _, err := parser.ParseWithClaims(raw, claims, j.key); err != nil {
+		return nil, invalidCredentials("invalid bearer token | " + err.Error())
+	}
+
+	// The parser only checks exp when it's there, we want it to always be there
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, invalidCredentials("bearer token has no expiry")
+	}
+	if j.Issuer != "" && !claims.VerifyIssuer(j.Issuer, true) {
+		return nil, invalidCredentials("bearer token was issued by someone else")
+	}
+	if j.Audience != "" && !claims.VerifyAudience(j.Audience, true) {
+		return nil, invalidCredentials("bearer token is meant for another audience")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, invalidCredentials("bearer token has no subject")
+	}
+	scope, _ := claims["scope"].(string)
+	role, _ := claims[roleClaim].(string)
+	if role != "" && !ValidRole(role) {
+		return nil, invalidCredentials(fmt.Sprintf("bearer token has an unknown role %q", role))
+	}
+	// JSON numbers come out of MapClaims as float64
+	sponsorID, _ := claims[sponsorIDClaim].(float64)
+
+	return &Principal{
+		Subject:   subject,
+		Method:    "jwt",
+		Admin:     hasScope(scope, AdminScope),
+		Role:      role,
+		SponsorID: int(sponsorID),
+	}, nil
+}
+
+// Picks the key the token was signed with by its kid header. The jwt
+// package refuses a key of the wrong type for the token's algorithm.
+func (j *JWT) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// scope is a space separated list, like OAuth 2 sends it
+func hasScope(scope string, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Reads the RSA and EC public keys out of a JWKS document.
+// Keys meant for encryption are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q) | %w", i, k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
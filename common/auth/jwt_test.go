@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://id.example.com/"
+	testAudience = "sponsor-service"
+)
+
+// Makes an RSA and an EC key, and a JWKS file with their public halves
+func jwksFixture(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey, string) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec-1", "use": "sig", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, ecKey, path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	rsaKey, ecKey, jwks := jwksFixture(t)
+	j, err := NewJWT(jwks, testIssuer, testAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid claims, each case changes what it tests
+	claims := func(change func(c jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":          "user-1",
+			"iss":          testIssuer,
+			"aud":          testAudience,
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"role":         RoleSponsorAdmin,
+			sponsorIDClaim: 3,
+			"scope":        "openid " + AdminScope,
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil)), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(nil)), true},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) {
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+		})), false},
+		{"no exp", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) { delete(c, "exp") })), false},
+		{"wrong iss", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) {
+			c["iss"] = "https://evil.example.com/"
+		})), false},
+		{"wrong aud", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) { c["aud"] = "billing" })), false},
+		{"no sub", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) { delete(c, "sub") })), false},
+		{"unknown role", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c jwt.MapClaims) { c["role"] = "root" })), false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, claims(nil)), false},
+		{"key of another kid", sign(t, jwt.SigningMethodRS256, "ec-1", rsaKey, claims(nil)), false},
+		{"HS256", sign(t, jwt.SigningMethodHS256, "rsa-1", []byte("a shared secret"), claims(nil)), false},
+		{"none", sign(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType, claims(nil)), false},
+		{"garbage", "not.a.token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			p, err := j.Authenticate(r)
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("got %+v, want the token turned down", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got %v, want the token accepted", err)
+			}
+			if p.Subject != "user-1" || p.Method != "jwt" || p.Role != RoleSponsorAdmin || p.SponsorID != 3 || !p.Admin {
+				t.Errorf("got %+v, want user-1, a sponsor admin of sponsor 3 with the admin scope", p)
+			}
+		})
+	}
+}
+
+func TestJWTLeavesOtherSchemesAlone(t *testing.T) {
+	_, _, jwks := jwksFixture(t)
+	j, err := NewJWT(jwks, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if _, err := j.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("got %v, want ErrNoCredentials so another authenticator can try", err)
+	}
+}
+
+func TestNewJWTRejectsBadKeySets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, set := range map[string]string{
+		"not json":    `{"keys":`,
+		"no keys":     `{"keys":[]}`,
+		"only enc":    `{"keys":[{"kty":"RSA","kid":"a","use":"enc","n":"AQAB","e":"AQAB"}]}`,
+		"unknown kty": `{"keys":[{"kty":"oct","kid":"a"}]}`,
+		"off curve":   `{"keys":[{"kty":"EC","kid":"a","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+	} {
+		path := filepath.Join(dir, "jwks.json")
+		if err := ioutil.WriteFile(path, []byte(set), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewJWT(path, "", ""); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+	if _, err := NewJWT(filepath.Join(dir, "missing.json"), "", ""); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
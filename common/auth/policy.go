@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+)
+
+// Roles a key or token can have
+const (
+	// Manages events, levels, sponsors and their members
+	RoleOrganizer = "organizer"
+	// Manages the members of one sponsor, nothing else
+	RoleSponsorAdmin = "sponsorAdmin"
+	// Reads events and reports, and bills sponsors
+	RoleFinance = "finance"
+	// Whoever holds a sponsor's invite link. Only ever given to invites,
+	// never to keys or tokens.
+	RoleSponsorContact = "sponsorContact"
+)
+
+// Roles keys and tokens can be given
+var roles = []string{RoleOrganizer, RoleSponsorAdmin, RoleFinance}
+
+func ValidRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Action is something a handler is about to do
+type Action string
+
+const (
+	ReadEvents     Action = "read events"
+	ManageEvents   Action = "manage events and levels"
+	ManageSponsors Action = "manage sponsors"
+	ManageMembers  Action = "manage sponsor members"
+	ReadReports    Action = "read reports"
+	ManageInvoices Action = "manage invoices"
+)
+
+// What each role may do. Sponsor admins and contacts are further limited to their own sponsor.
+var permissions = map[string]map[Action]bool{
+	RoleOrganizer: {
+		ReadEvents:     true,
+		ManageEvents:   true,
+		ManageSponsors: true,
+		ManageMembers:  true,
+		ReadReports:    true,
+		ManageInvoices: true,
+	},
+	RoleSponsorAdmin: {
+		ManageMembers: true,
+	},
+	RoleFinance: {
+		ReadEvents:     true,
+		ReadReports:    true,
+		ManageInvoices: true,
+	},
+	RoleSponsorContact: {
+		ManageMembers: true,
+	},
+}
+
+// ForbiddenError says why a caller may not do something
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
+
+// Can returns nil when p may do action, or a *ForbiddenError saying why not.
+// sponsorID is the sponsor the action is about, 0 when it isn't about one.
+// A nil Principal means auth is turned off, so everything is allowed.
+func Can(p *Principal, action Action, sponsorID int) error {
+	if p == nil {
+		return nil
+	}
+	if !permissions[p.Role][action] {
+		if p.Role == "" {
+			return &ForbiddenError{Reason: fmt.Sprintf("%s has no role, so it can't %s", p.Subject, action)}
+		}
+		return &ForbiddenError{Reason: fmt.Sprintf("the %s role can't %s", p.Role, action)}
+	}
+	if p.Role == RoleSponsorAdmin && (p.SponsorID == 0 || p.SponsorID != sponsorID) {
+		return &ForbiddenError{Reason: fmt.Sprintf("sponsor admins can only %s of their own sponsor (%d)", action, p.SponsorID)}
+	}
+	if p.Role == RoleSponsorContact && (p.SponsorID == 0 || p.SponsorID != sponsorID) {
+		return &ForbiddenError{Reason: fmt.Sprintf("invite links can only %s of their own sponsor (%d)", action, p.SponsorID)}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	organizer := &Principal{Subject: "org", Role: RoleOrganizer}
+	finance := &Principal{Subject: "fin", Role: RoleFinance}
+	sponsorAdmin := &Principal{Subject: "acme", Role: RoleSponsorAdmin, SponsorID: 3}
+	sponsorAdminWithoutSponsor := &Principal{Subject: "lost", Role: RoleSponsorAdmin}
+	contact := &Principal{Subject: "invite", Role: RoleSponsorContact, SponsorID: 3}
+	noRole := &Principal{Subject: "nobody"}
+
+	tests := []struct {
+		name      string
+		p         *Principal
+		action    Action
+		sponsorID int
+		allowed   bool
+	}{
+		{"organizer reads events", organizer, ReadEvents, 0, true},
+		{"organizer manages events", organizer, ManageEvents, 0, true},
+		{"organizer manages sponsors", organizer, ManageSponsors, 3, true},
+		{"organizer manages any sponsor's members", organizer, ManageMembers, 7, true},
+		{"organizer reads reports", organizer, ReadReports, 0, true},
+		{"organizer manages invoices", organizer, ManageInvoices, 3, true},
+
+		{"finance reads events", finance, ReadEvents, 0, true},
+		{"finance reads reports", finance, ReadReports, 0, true},
+		{"finance manages invoices", finance, ManageInvoices, 3, true},
+		{"finance can't manage events", finance, ManageEvents, 0, false},
+		{"finance can't manage sponsors", finance, ManageSponsors, 3, false},
+		{"finance can't manage members", finance, ManageMembers, 3, false},
+
+		{"sponsor admin manages its members", sponsorAdmin, ManageMembers, 3, true},
+		{"sponsor admin can't manage another sponsor's members", sponsorAdmin, ManageMembers, 4, false},
+		{"sponsor admin can't manage members of no sponsor", sponsorAdmin, ManageMembers, 0, false},
+		{"sponsor admin without a sponsor can't manage members", sponsorAdminWithoutSponsor, ManageMembers, 0, false},
+		{"sponsor admin can't read events", sponsorAdmin, ReadEvents, 0, false},
+		{"sponsor admin can't manage its sponsor", sponsorAdmin, ManageSponsors, 3, false},
+		{"sponsor admin can't manage invoices", sponsorAdmin, ManageInvoices, 3, false},
+		{"sponsor admin can't read reports", sponsorAdmin, ReadReports, 0, false},
+
+		{"invite manages its sponsor's members", contact, ManageMembers, 3, true},
+		{"invite can't manage another sponsor's members", contact, ManageMembers, 4, false},
+		{"invite can't read events", contact, ReadEvents, 0, false},
+
+		{"no role can't read events", noRole, ReadEvents, 0, false},
+		{"no role can't manage members", noRole, ManageMembers, 3, false},
+
+		{"auth turned off allows everything", nil, ManageEvents, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Can(tt.p, tt.action, tt.sponsorID)
+			if tt.allowed && err != nil {
+				t.Errorf("got %v, want it allowed", err)
+			}
+			if !tt.allowed {
+				if _, ok := err.(*ForbiddenError); !ok {
+					t.Errorf("got %v, want a *ForbiddenError", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Package blob keeps files the service is given, like sponsor logos, out of
+// the database. Store is what the rest of the service uses, FileStore keeps
+// them on local disk; another Store can put them in object storage.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned for a key with nothing stored under it
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for a key that isn't made of ValidKey's parts
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps blobs by key. Putting a key that's already stored replaces it,
+// and deleting one that isn't is fine.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	// The caller closes what it returns
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey is true for keys like "logo-9f86d081.png": lowercase letters,
+// digits, dots, dashes and underscores, separated by slashes, with no
+// empty, "." or ".." parts. Keys are made by the service, never by clients,
+// but this keeps one from ever reaching outside a store.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 255 {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+		for _, c := range part {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package blob
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"logo-9f86d081.png", true},
+		{"logos/1/logo_thumb.png", true},
+		{"", false},
+		{strings.Repeat("a", 256), false},
+		{"Logo.png", false},
+		{"../logo.png", false},
+		{"logos/./logo.png", false},
+		{"logos//logo.png", false},
+		{"/logo.png", false},
+		{`logos\logo.png`, false},
+		{"logo png", false},
+	}
+	for _, tt := range tests {
+		if got := ValidKey(tt.key); got != tt.valid {
+			t.Errorf("ValidKey(%q) got %v, want %v", tt.key, got, tt.valid)
+		}
+	}
+}
+
+func get(t *testing.T, s Store, key string) string {
+	t.Helper()
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewFileStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(ctx, "logos/1/logo.png", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "logos/1/logo.png", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, s, "logos/1/logo.png"); got != "second" {
+		t.Errorf("got %q, want the second put to replace the first", got)
+	}
+	// Only the blob is left behind, not the file it was written to first
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "blobs", "logos", "1"))
+	if len(files) != 1 {
+		t.Errorf("got %d files, want just the blob", len(files))
+	}
+
+	if err := s.Delete(ctx, "logos/1/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "logos/1/logo.png"); err != ErrNotFound {
+		t.Errorf("getting a deleted blob got %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "logos/1/logo.png"); err != nil {
+		t.Errorf("deleting it again got %v, want nothing", err)
+	}
+}
+
+func TestFileStoreStaysInItsDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewFileStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "../outside.png", strings.NewReader("x")); err != ErrInvalidKey {
+		t.Errorf("put got %v, want ErrInvalidKey", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside.png")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the store: %v", err)
+	}
+	if _, err := s.Get(ctx, "../outside.png"); err != ErrInvalidKey {
+		t.Errorf("get got %v, want ErrInvalidKey", err)
+	}
+	if err := s.Delete(ctx, "../outside.png"); err != ErrInvalidKey {
+		t.Errorf("delete got %v, want ErrInvalidKey", err)
+	}
+}
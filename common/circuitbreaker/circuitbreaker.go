@@ -1,6 +1,7 @@
 package circuitbreaker
 
 import (
+	"github.com/r3dcrosse/sponsor-service/common/metrics"
 	"github.com/rubyist/circuitbreaker"
 )
 
@@ -12,10 +13,17 @@ func InitCircuitBreaker() {
 
 	events := CB.Subscribe()
 	go func() {
-		for {
-			_ = <-events
-			// Can check for breaker events here
-			//fmt.Printf("Breaker Event | %s", e)
+		for e := range events {
+			switch e {
+			case circuit.BreakerTripped:
+				metrics.BreakerEvent(metrics.BreakerTripped)
+			case circuit.BreakerReset:
+				metrics.BreakerEvent(metrics.BreakerReset)
+			case circuit.BreakerFail:
+				metrics.BreakerEvent(metrics.BreakerFail)
+			case circuit.BreakerReady:
+				metrics.BreakerEvent(metrics.BreakerReady)
+			}
 		}
 	}()
 }
@@ -0,0 +1,450 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////
+//
+// Service configuration
+//
+// Settings are merged in this order, later ones win:
+//   1. Defaults()
+//   2. A YAML or TOML config file (-config or SPONSOR_CONFIG)
+//   3. Environment variables
+//   4. Command line flags
+//
+//////////////////////////////////////////////////////////////
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Messaging MessagingConfig `yaml:"messaging" toml:"messaging"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	Sponsors  SponsorsConfig  `yaml:"sponsors" toml:"sponsors"`
+	Invoices  InvoicesConfig  `yaml:"invoices" toml:"invoices"`
+	Assets    AssetsConfig    `yaml:"assets" toml:"assets"`
+}
+
+type HTTPConfig struct {
+	Port int `yaml:"port" toml:"port"`
+	// Where clients reach the service, used to build links we hand out
+	PublicURL string `yaml:"publicURL" toml:"publicURL"`
+	// How long a POST's response is kept for retries with the same Idempotency-Key
+	IdempotencyTTL Duration `yaml:"idempotencyTTL" toml:"idempotencyTTL"`
+}
+
+type SponsorsConfig struct {
+	// How long a sponsor on a waitlist has to take the spot it's offered
+	WaitlistOfferHold Duration `yaml:"waitlistOfferHold" toml:"waitlistOfferHold"`
+	// How long a level's spot is held for a prospect, when the reservation doesn't say
+	ReservationHold Duration `yaml:"reservationHold" toml:"reservationHold"`
+}
+
+type InvoicesConfig struct {
+	// ISO 4217 code level costs are billed in
+	Currency string `yaml:"currency" toml:"currency"`
+	// How long after it's issued an invoice is due, when it doesn't say
+	PaymentTerms Duration `yaml:"paymentTerms" toml:"paymentTerms"`
+	// Who the invoices are from, printed at the top of the PDF
+	Issuer string `yaml:"issuer" toml:"issuer"`
+	// Signs the payment provider's webhook callbacks, the webhook is
+	// turned off when empty
+	WebhookSecret string `yaml:"webhookSecret" toml:"webhookSecret"`
+	// How far a callback's signed timestamp can be from our clock
+	WebhookTolerance Duration `yaml:"webhookTolerance" toml:"webhookTolerance"`
+}
+
+type AssetsConfig struct {
+	// Directory uploaded sponsor logos and their thumbnails are kept in
+	Dir string `yaml:"dir" toml:"dir"`
+	// Biggest logo file that can be uploaded, in bytes
+	MaxLogoSize int `yaml:"maxLogoSize" toml:"maxLogoSize"`
+}
+
+type LogConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+}
+
+type TracingConfig struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// host:port of an OTLP gRPC collector
+	OTLPEndpoint string `yaml:"otlpEndpoint" toml:"otlpEndpoint"`
+	// Send spans to the collector without TLS
+	OTLPInsecure bool `yaml:"otlpInsecure" toml:"otlpInsecure"`
+	// Share of new traces to keep, from 0 to 1. Traces started
+	// by another service follow that service's decision.
+	SampleRatio float64 `yaml:"sampleRatio" toml:"sampleRatio"`
+}
+
+type AuthConfig struct {
+	// Turning this off lets anyone who can reach the service use the API
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// JSON Web Key Set to verify JWTs with, JWTs are rejected when empty
+	JWKSFile string `yaml:"jwksFile" toml:"jwksFile"`
+	// When set, a JWT's iss and aud claims must match
+	Issuer   string `yaml:"issuer" toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+	// Admin API key to create while there are no keys at all,
+	// so the first real keys can be made
+	BootstrapKey string `yaml:"bootstrapKey" toml:"bootstrapKey"`
+	// Signs sponsor invite links. A random one is made at startup when
+	// empty, so links stop working when the service restarts.
+	InviteSecret string `yaml:"inviteSecret" toml:"inviteSecret"`
+}
+
+type DatabaseConfig struct {
+	Driver     string `yaml:"driver" toml:"driver"`
+	Host       string `yaml:"host" toml:"host"`
+	Port       string `yaml:"port" toml:"port"`
+	User       string `yaml:"user" toml:"user"`
+	Password   string `yaml:"password" toml:"password"`
+	Name       string `yaml:"name" toml:"name"`
+	SSLMode    string `yaml:"sslMode" toml:"sslMode"`
+	SqlitePath string `yaml:"sqlitePath" toml:"sqlitePath"`
+
+	// Connection pool, 0 keeps the database/sql defaults
+	MaxOpenConns    int      `yaml:"maxOpenConns" toml:"maxOpenConns"`
+	MaxIdleConns    int      `yaml:"maxIdleConns" toml:"maxIdleConns"`
+	ConnMaxLifetime Duration `yaml:"connMaxLifetime" toml:"connMaxLifetime"`
+}
+
+type MessagingConfig struct {
+	// rabbitmq or memory
+	Broker string `yaml:"broker" toml:"broker"`
+	// Full AMQP URL, e.g. amqps://user:pass@mq:5671/vhost.
+	// When empty, the URL is built from Host, Username and Password.
+	URL      string    `yaml:"url" toml:"url"`
+	Host     string    `yaml:"host" toml:"host"`
+	Username string    `yaml:"username" toml:"username"`
+	Password string    `yaml:"password" toml:"password"`
+	TLS      TLSConfig `yaml:"tls" toml:"tls"`
+	Queues   Queues    `yaml:"queues" toml:"queues"`
+}
+
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled" toml:"enabled"`
+	CAFile             string `yaml:"caFile" toml:"caFile"`
+	CertFile           string `yaml:"certFile" toml:"certFile"`
+	KeyFile            string `yaml:"keyFile" toml:"keyFile"`
+	ServerName         string `yaml:"serverName" toml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" toml:"insecureSkipVerify"`
+}
+
+type Queues struct {
+	ConsumerName    string `yaml:"consumerName" toml:"consumerName"`
+	EventCreated    string `yaml:"eventCreated" toml:"eventCreated"`
+	EventModified   string `yaml:"eventModified" toml:"eventModified"`
+	MemberCreated   string `yaml:"memberCreated" toml:"memberCreated"`
+	MemberUpdated   string `yaml:"memberUpdated" toml:"memberUpdated"`
+	WaitlistOffered string `yaml:"waitlistOffered" toml:"waitlistOffered"`
+	StatusChanged   string `yaml:"statusChanged" toml:"statusChanged"`
+	PaymentReceived string `yaml:"paymentReceived" toml:"paymentReceived"`
+}
+
+// Duration reads "30s" or "5m" style values from config files
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(text))
+}
+
+func Defaults() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Port:           8000,
+			PublicURL:      "http://localhost:8000",
+			IdempotencyTTL: Duration{24 * time.Hour},
+		},
+		Database: DatabaseConfig{
+			Driver:     "postgres",
+			Host:       "localhost",
+			Port:       "5432",
+			User:       "user",
+			Name:       "postgres",
+			SSLMode:    "disable",
+			SqlitePath: "sponsor-service.db",
+		},
+		Messaging: MessagingConfig{
+			Broker:   "rabbitmq",
+			Host:     "localhost:5672",
+			Username: "guest",
+			Password: "guest",
+			Queues: Queues{
+				ConsumerName:    "sponsor-service",
+				EventCreated:    "event.create",
+				EventModified:   "event.modify",
+				MemberCreated:   "sponsor.member.created",
+				MemberUpdated:   "sponsor.member.updated",
+				WaitlistOffered: "sponsor.waitlist.offered",
+				StatusChanged:   "sponsor.status.changed",
+				PaymentReceived: "sponsor.payment.received",
+			},
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4317",
+			SampleRatio:  1,
+		},
+		Auth: AuthConfig{
+			Enabled: true,
+		},
+		Sponsors: SponsorsConfig{
+			WaitlistOfferHold: Duration{72 * time.Hour},
+			ReservationHold:   Duration{7 * 24 * time.Hour},
+		},
+		Invoices: InvoicesConfig{
+			Currency:         "USD",
+			PaymentTerms:     Duration{30 * 24 * time.Hour},
+			Issuer:           "Sponsor Service",
+			WebhookTolerance: Duration{5 * time.Minute},
+		},
+		Assets: AssetsConfig{
+			Dir:         "assets",
+			MaxLogoSize: 5 << 20,
+		},
+	}
+}
+
+// AMQPURL is the URL to dial RabbitMQ with, including credentials
+func (m MessagingConfig) AMQPURL() string {
+	if m.URL != "" {
+		return m.URL
+	}
+
+	scheme := "amqp"
+	if m.TLS.Enabled {
+		scheme = "amqps"
+	}
+	u := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(m.Username, m.Password),
+		Host:   m.Host,
+		Path:   "/",
+	}
+	return u.String()
+}
+
+// UsesTLS is true when TLS was turned on, or the URL asks for amqps
+func (m MessagingConfig) UsesTLS() bool {
+	return m.TLS.Enabled || strings.HasPrefix(m.AMQPURL(), "amqps://")
+}
+
+// TLSClientConfig builds the tls.Config to dial RabbitMQ with.
+// Returns nil when TLS is not being used.
+func (m MessagingConfig) TLSClientConfig() (*tls.Config, error) {
+	if !m.UsesTLS() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         m.TLS.ServerName,
+		InsecureSkipVerify: m.TLS.InsecureSkipVerify,
+	}
+
+	if m.TLS.CAFile != "" {
+		ca, err := ioutil.ReadFile(m.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read AMQP CA file | %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in AMQP CA file %s", m.TLS.CAFile)
+		}
+	}
+
+	if m.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(m.TLS.CertFile, m.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load AMQP client certificate | %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Validate returns every problem with the config at once,
+// so they can all be fixed in one go
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		add("http.port must be between 1 and 65535, got %d", c.HTTP.Port)
+	}
+	if u, err := url.Parse(c.HTTP.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("http.publicURL must be an absolute URL, got %q", c.HTTP.PublicURL)
+	}
+	if c.Sponsors.WaitlistOfferHold.Duration <= 0 {
+		add("sponsors.waitlistOfferHold must be more than 0, got %s", c.Sponsors.WaitlistOfferHold.Duration)
+	}
+	if c.Sponsors.ReservationHold.Duration <= 0 {
+		add("sponsors.reservationHold must be more than 0, got %s", c.Sponsors.ReservationHold.Duration)
+	}
+	if len(c.Invoices.Currency) != 3 || strings.ToUpper(c.Invoices.Currency) != c.Invoices.Currency {
+		add("invoices.currency must be a 3 letter code like USD, got %q", c.Invoices.Currency)
+	}
+	if c.Invoices.PaymentTerms.Duration <= 0 {
+		add("invoices.paymentTerms must be more than 0, got %s", c.Invoices.PaymentTerms.Duration)
+	}
+	if c.Invoices.WebhookTolerance.Duration <= 0 {
+		add("invoices.webhookTolerance must be more than 0, got %s", c.Invoices.WebhookTolerance.Duration)
+	}
+	if c.Assets.Dir == "" {
+		add("assets.dir is required")
+	}
+	if c.Assets.MaxLogoSize <= 0 {
+		add("assets.maxLogoSize must be more than 0, got %d", c.Assets.MaxLogoSize)
+	}
+	if c.HTTP.IdempotencyTTL.Duration <= 0 {
+		add("http.idempotencyTTL must be more than 0, got %s", c.HTTP.IdempotencyTTL.Duration)
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	t := c.Tracing
+	switch t.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if t.OTLPEndpoint == "" {
+			add("tracing.otlpEndpoint is required for otlp")
+		}
+	default:
+		add("tracing.exporter must be none, stdout or otlp, got %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		add("tracing.sampleRatio must be between 0 and 1, got %g", t.SampleRatio)
+	}
+
+	if c.Auth.BootstrapKey != "" && len(c.Auth.BootstrapKey) < 32 {
+		add("auth.bootstrapKey must be at least 32 characters long")
+	}
+	if c.Auth.InviteSecret != "" && len(c.Auth.InviteSecret) < 32 {
+		add("auth.inviteSecret must be at least 32 characters long")
+	}
+
+	d := c.Database
+	switch d.Driver {
+	case "postgres":
+		if d.Host == "" {
+			add("database.host is required for postgres")
+		}
+		if d.Name == "" {
+			add("database.name is required for postgres")
+		}
+	case "sqlite":
+		if d.SqlitePath == "" {
+			add("database.sqlitePath is required for sqlite")
+		}
+	default:
+		add("database.driver must be postgres or sqlite, got %q", d.Driver)
+	}
+	if d.MaxOpenConns < 0 {
+		add("database.maxOpenConns can't be negative")
+	}
+	if d.MaxIdleConns < 0 {
+		add("database.maxIdleConns can't be negative")
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		add("database.maxIdleConns (%d) can't be more than database.maxOpenConns (%d)", d.MaxIdleConns, d.MaxOpenConns)
+	}
+	if d.ConnMaxLifetime.Duration < 0 {
+		add("database.connMaxLifetime can't be negative")
+	}
+
+	m := c.Messaging
+	switch m.Broker {
+	case "rabbitmq":
+		u, err := url.Parse(m.AMQPURL())
+		if err != nil {
+			add("messaging.url is not a valid URL")
+		} else if u.Scheme != "amqp" && u.Scheme != "amqps" {
+			add("messaging.url must start with amqp:// or amqps://")
+		} else if u.Host == "" {
+			add("messaging.host or messaging.url is required for rabbitmq")
+		}
+		if (m.TLS.CertFile == "") != (m.TLS.KeyFile == "") {
+			add("messaging.tls.certFile and messaging.tls.keyFile must be set together")
+		}
+	case "memory":
+	default:
+		add("messaging.broker must be rabbitmq or memory, got %q", m.Broker)
+	}
+	if m.Queues.ConsumerName == "" {
+		add("messaging.queues.consumerName can't be empty")
+	}
+	if m.Queues.EventCreated == "" || m.Queues.EventModified == "" || m.Queues.MemberCreated == "" || m.Queues.MemberUpdated == "" || m.Queues.WaitlistOffered == "" || m.Queues.StatusChanged == "" || m.Queues.PaymentReceived == "" {
+		add("messaging.queues names can't be empty")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config that is safe to log,
+// with passwords (including any in the AMQP URL) replaced
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	if c.Messaging.Password != "" {
+		c.Messaging.Password = redacted
+	}
+	c.Messaging.URL = logging.RedactURL(c.Messaging.URL)
+	if c.Auth.BootstrapKey != "" {
+		c.Auth.BootstrapKey = redacted
+	}
+	if c.Auth.InviteSecret != "" {
+		c.Auth.InviteSecret = redacted
+	}
+	if c.Invoices.WebhookSecret != "" {
+		c.Invoices.WebhookSecret = redacted
+	}
+	return c
+}
+
+func (c Config) String() string {
+	// Converting to a type without a String method stops %+v calling us again
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c.Redacted()))
+}
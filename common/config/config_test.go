@@ -0,0 +1,186 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Sets environment variables for the rest of the test
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		old, had := os.LookupEnv(name)
+		os.Setenv(name, value)
+		name := name
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "http:\n  port: 8001\nlog:\n  level: warn\n")
+	tomlFile := writeFile(t, "config.toml", "[http]\nport = 8001\n[log]\nlevel = \"warn\"\n")
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		port  int
+		level string
+	}{
+		{"defaults", nil, nil, 8000, "info"},
+		{"yaml file", nil, []string{"-config", yamlFile}, 8001, "warn"},
+		{"toml file", nil, []string{"-config", tomlFile}, 8001, "warn"},
+		{"file from the environment", map[string]string{configFileEnv: yamlFile}, nil, 8001, "warn"},
+		{"environment over file", map[string]string{"HTTP_PORT": "8002"}, []string{"-config", yamlFile}, 8002, "warn"},
+		{"flag over environment", map[string]string{"HTTP_PORT": "8002", "LOG_LEVEL": "error"}, []string{"-config", yamlFile, "-http_port", "8003"}, 8003, "error"},
+		{"flag over file", nil, []string{"-config", yamlFile, "-log_level", "debug"}, 8001, "debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			c, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.HTTP.Port != tt.port || c.Log.Level != tt.level {
+				t.Errorf("got port %d and log level %s, want %d and %s", c.HTTP.Port, c.Log.Level, tt.port, tt.level)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"not a number in the environment", map[string]string{"HTTP_PORT": "eighty"}, nil, "HTTP_PORT"},
+		{"not a number as a flag", nil, []string{"-http_port", "eighty"}, "-http_port"},
+		{"unknown flag", nil, []string{"-nope"}, "nope"},
+		{"unknown setting in the file", nil, []string{"-config", writeFile(t, "bad.yaml", "http:\n  prot: 1\n")}, "prot"},
+		{"unknown file type", nil, []string{"-config", writeFile(t, "config.json", "{}")}, ".toml"},
+		{"missing file", nil, []string{"-config", "/does/not/exist.yaml"}, "could not read"},
+		{"invalid value", nil, []string{"-http_port", "0"}, "http.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			_, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"port too high", func(c *Config) { c.HTTP.Port = 70000 }, "http.port"},
+		{"relative public URL", func(c *Config) { c.HTTP.PublicURL = "/sponsors" }, "http.publicURL"},
+		{"no waitlist hold", func(c *Config) { c.Sponsors.WaitlistOfferHold.Duration = 0 }, "sponsors.waitlistOfferHold"},
+		{"lowercase currency", func(c *Config) { c.Invoices.Currency = "usd" }, "invoices.currency"},
+		{"no assets dir", func(c *Config) { c.Assets.Dir = "" }, "assets.dir"},
+		{"unknown log level", func(c *Config) { c.Log.Level = "loud" }, "log.level"},
+		{"otlp without endpoint", func(c *Config) { c.Tracing.Exporter, c.Tracing.OTLPEndpoint = "otlp", "" }, "tracing.otlpEndpoint"},
+		{"sample ratio over 1", func(c *Config) { c.Tracing.SampleRatio = 2 }, "tracing.sampleRatio"},
+		{"short bootstrap key", func(c *Config) { c.Auth.BootstrapKey = "short" }, "auth.bootstrapKey"},
+		{"short invite secret", func(c *Config) { c.Auth.InviteSecret = "short" }, "auth.inviteSecret"},
+		{"unknown driver", func(c *Config) { c.Database.Driver = "mysql" }, "database.driver"},
+		{"sqlite without path", func(c *Config) { c.Database.Driver, c.Database.SqlitePath = "sqlite", "" }, "database.sqlitePath"},
+		{"more idle than open", func(c *Config) { c.Database.MaxOpenConns, c.Database.MaxIdleConns = 2, 5 }, "database.maxIdleConns"},
+		{"http AMQP URL", func(c *Config) { c.Messaging.URL = "http://rabbit:5672/" }, "messaging.url"},
+		{"cert without key", func(c *Config) { c.Messaging.TLS.CertFile = "client.pem" }, "messaging.tls.certFile"},
+		{"unknown broker", func(c *Config) { c.Messaging.Broker = "kafka" }, "messaging.broker"},
+		{"empty queue name", func(c *Config) { c.Messaging.Queues.MemberCreated = "" }, "messaging.queues"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Defaults()
+			tt.change(c)
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+
+	if err := Defaults().Validate(); err != nil {
+		t.Errorf("the defaults got %v, want them valid", err)
+	}
+	c := Defaults()
+	c.HTTP.Port = 0
+	c.Log.Level = "loud"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "http.port") || !strings.Contains(err.Error(), "log.level") {
+		t.Errorf("got %v, want both problems listed at once", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	secrets := map[string]string{
+		"database password": "pg-secret-1",
+		"AMQP password":     "amqp-secret-2",
+		"AMQP URL password": "amqp-secret-3",
+		"bootstrap key":     strings.Repeat("b", 40),
+		"invite secret":     strings.Repeat("i", 40),
+		"webhook secret":    "whsec_secret-4",
+	}
+	c := Defaults()
+	c.Database.Password = secrets["database password"]
+	c.Messaging.Password = secrets["AMQP password"]
+	c.Messaging.URL = "amqp://sponsors:" + secrets["AMQP URL password"] + "@rabbit:5672/"
+	c.Auth.BootstrapKey = secrets["bootstrap key"]
+	c.Auth.InviteSecret = secrets["invite secret"]
+	c.Invoices.WebhookSecret = secrets["webhook secret"]
+
+	r := c.Redacted()
+	logged := c.String()
+	for name, secret := range secrets {
+		if strings.Contains(logged, secret) {
+			t.Errorf("the %s shows up in %s", name, logged)
+		}
+	}
+	if r.Database.Password != redacted || r.Messaging.Password != redacted || r.Auth.BootstrapKey != redacted ||
+		r.Auth.InviteSecret != redacted || r.Invoices.WebhookSecret != redacted {
+		t.Errorf("got %+v, want every secret replaced with %s", r, redacted)
+	}
+	if !strings.Contains(r.Messaging.URL, "sponsors:") || !strings.Contains(r.Messaging.URL, "rabbit:5672") {
+		t.Errorf("AMQP URL got %s, want only the password hidden", r.Messaging.URL)
+	}
+	if c.Database.Password != secrets["database password"] {
+		t.Error("Redacted changed the config it was called on")
+	}
+
+	// Empty secrets stay empty, so it's clear they aren't set
+	if r := Defaults().Redacted(); r.Auth.BootstrapKey != "" || r.Invoices.WebhookSecret != "" {
+		t.Errorf("got %+v, want unset secrets left empty", r)
+	}
+}
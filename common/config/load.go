@@ -0,0 +1,217 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A setting that can come from an environment variable or a flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// Secrets have no flag on purpose, anything passed as a flag
+// shows up in process listings. -pg_password is only kept so
+// existing deployments don't break.
+var settings = []setting{
+	{"http_port", "HTTP_PORT", "Port to serve the REST API on", setInt(func(c *Config) *int { return &c.HTTP.Port })},
+	{"public_url", "HTTP_PUBLIC_URL", "URL clients reach the service on, used in invite links", setString(func(c *Config) *string { return &c.HTTP.PublicURL })},
+	{"idempotency_ttl", "HTTP_IDEMPOTENCY_TTL", "How long responses are kept for retries with the same Idempotency-Key, e.g. 24h", setDuration(func(c *Config) *time.Duration { return &c.HTTP.IdempotencyTTL.Duration })},
+	{"log_level", "LOG_LEVEL", "Lowest level to log: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level })},
+
+	{"tracing", "TRACING_EXPORTER", "Where to send traces: none, stdout or otlp", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"otlp_endpoint", "TRACING_OTLP_ENDPOINT", "host:port of the OTLP gRPC collector", setString(func(c *Config) *string { return &c.Tracing.OTLPEndpoint })},
+	{"otlp_insecure", "TRACING_OTLP_INSECURE", "Send traces to the collector without TLS", setBool(func(c *Config) *bool { return &c.Tracing.OTLPInsecure })},
+	{"trace_sample_ratio", "TRACING_SAMPLE_RATIO", "Share of new traces to keep, from 0 to 1", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"auth", "AUTH_ENABLED", "Require an API key or JWT on the REST API", setBool(func(c *Config) *bool { return &c.Auth.Enabled })},
+	{"jwks_file", "AUTH_JWKS_FILE", "JSON Web Key Set file to verify JWTs with", setString(func(c *Config) *string { return &c.Auth.JWKSFile })},
+	{"jwt_issuer", "AUTH_JWT_ISSUER", "Issuer JWTs must have, any when empty", setString(func(c *Config) *string { return &c.Auth.Issuer })},
+	{"jwt_audience", "AUTH_JWT_AUDIENCE", "Audience JWTs must have, any when empty", setString(func(c *Config) *string { return &c.Auth.Audience })},
+	{"", "AUTH_BOOTSTRAP_KEY", "", setString(func(c *Config) *string { return &c.Auth.BootstrapKey })},
+	{"", "AUTH_INVITE_SECRET", "", setString(func(c *Config) *string { return &c.Auth.InviteSecret })},
+
+	{"db_driver", "DB_DRIVER", "Database to use: postgres or sqlite", setString(func(c *Config) *string { return &c.Database.Driver })},
+	{"pg_ip", "PG_IP", "IP Address where postgres is running", setString(func(c *Config) *string { return &c.Database.Host })},
+	{"pg_port", "PG_PORT", "Port where postgres is running", setString(func(c *Config) *string { return &c.Database.Port })},
+	{"pg_user", "PG_USER", "User to use to login to postgres", setString(func(c *Config) *string { return &c.Database.User })},
+	{"pg_password", "PG_PASS", "Deprecated, use the PG_PASS environment variable instead", setString(func(c *Config) *string { return &c.Database.Password })},
+	{"pg_dbname", "PG_DB_NAME", "The db name to connect to", setString(func(c *Config) *string { return &c.Database.Name })},
+	{"pg_ssl", "PG_SSL", "Run with ssl mode?", setString(func(c *Config) *string { return &c.Database.SSLMode })},
+	{"sqlite_path", "SQLITE_PATH", "File to store the sqlite database in, or :memory: to keep it in memory", setString(func(c *Config) *string { return &c.Database.SqlitePath })},
+	{"db_max_open_conns", "DB_MAX_OPEN_CONNS", "Max open connections to the database, 0 for no limit", setInt(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"db_max_idle_conns", "DB_MAX_IDLE_CONNS", "Max idle connections to keep open to the database", setInt(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"db_conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "How long a database connection can be reused, e.g. 30m", setDuration(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime.Duration })},
+
+	{"messaging", "MESSAGING_BROKER", "Message broker to use: rabbitmq, or memory to keep messages inside this process", setString(func(c *Config) *string { return &c.Messaging.Broker })},
+	{"rabbit", "RABBITMQ_IP", "IP Address and port where rabbitMQ is running", setString(func(c *Config) *string { return &c.Messaging.Host })},
+	{"", "AMQP_URL", "", setString(func(c *Config) *string { return &c.Messaging.URL })},
+	{"amqp_user", "AMQP_USER", "User to use to login to rabbitMQ", setString(func(c *Config) *string { return &c.Messaging.Username })},
+	{"", "AMQP_PASSWORD", "", setString(func(c *Config) *string { return &c.Messaging.Password })},
+	{"amqp_tls", "AMQP_TLS", "Connect to rabbitMQ over TLS", setBool(func(c *Config) *bool { return &c.Messaging.TLS.Enabled })},
+	{"amqp_tls_ca", "AMQP_TLS_CA_FILE", "CA certificate file to verify rabbitMQ with", setString(func(c *Config) *string { return &c.Messaging.TLS.CAFile })},
+	{"amqp_tls_cert", "AMQP_TLS_CERT_FILE", "Client certificate file for rabbitMQ", setString(func(c *Config) *string { return &c.Messaging.TLS.CertFile })},
+	{"amqp_tls_key", "AMQP_TLS_KEY_FILE", "Client key file for rabbitMQ", setString(func(c *Config) *string { return &c.Messaging.TLS.KeyFile })},
+	{"amqp_tls_server_name", "AMQP_TLS_SERVER_NAME", "Server name to expect on rabbitMQ's certificate", setString(func(c *Config) *string { return &c.Messaging.TLS.ServerName })},
+	{"amqp_tls_insecure", "AMQP_TLS_INSECURE", "Skip verifying rabbitMQ's certificate (never do this in production)", setBool(func(c *Config) *bool { return &c.Messaging.TLS.InsecureSkipVerify })},
+
+	{"consumer_name", "QUEUE_CONSUMER_NAME", "Consumer name to subscribe to queues with", setString(func(c *Config) *string { return &c.Messaging.Queues.ConsumerName })},
+	{"queue_event_created", "QUEUE_EVENT_CREATED", "Queue the event service publishes new events on", setString(func(c *Config) *string { return &c.Messaging.Queues.EventCreated })},
+	{"queue_event_modified", "QUEUE_EVENT_MODIFIED", "Queue the event service publishes modified events on", setString(func(c *Config) *string { return &c.Messaging.Queues.EventModified })},
+	{"queue_member_created", "QUEUE_MEMBER_CREATED", "Queue to publish new sponsor members on", setString(func(c *Config) *string { return &c.Messaging.Queues.MemberCreated })},
+	{"queue_member_updated", "QUEUE_MEMBER_UPDATED", "Queue to publish changes to sponsor members on", setString(func(c *Config) *string { return &c.Messaging.Queues.MemberUpdated })},
+	{"queue_waitlist_offered", "QUEUE_WAITLIST_OFFERED", "Queue to publish waitlist offers on", setString(func(c *Config) *string { return &c.Messaging.Queues.WaitlistOffered })},
+	{"queue_status_changed", "QUEUE_STATUS_CHANGED", "Queue to publish sponsor status changes on", setString(func(c *Config) *string { return &c.Messaging.Queues.StatusChanged })},
+	{"queue_payment_received", "QUEUE_PAYMENT_RECEIVED", "Queue to publish payments from sponsors on", setString(func(c *Config) *string { return &c.Messaging.Queues.PaymentReceived })},
+
+	{"waitlist_offer_hold", "WAITLIST_OFFER_HOLD", "How long a waitlisted sponsor has to take an offered spot, e.g. 72h", setDuration(func(c *Config) *time.Duration { return &c.Sponsors.WaitlistOfferHold.Duration })},
+	{"reservation_hold", "RESERVATION_HOLD", "How long a reservation holds a level's spot when it doesn't say, e.g. 168h", setDuration(func(c *Config) *time.Duration { return &c.Sponsors.ReservationHold.Duration })},
+
+	{"invoice_currency", "INVOICE_CURRENCY", "Currency level costs are invoiced in, e.g. USD", setString(func(c *Config) *string { return &c.Invoices.Currency })},
+	{"invoice_payment_terms", "INVOICE_PAYMENT_TERMS", "How long after it's issued an invoice is due when it doesn't say, e.g. 720h", setDuration(func(c *Config) *time.Duration { return &c.Invoices.PaymentTerms.Duration })},
+	{"invoice_issuer", "INVOICE_ISSUER", "Who invoices are from, printed on them", setString(func(c *Config) *string { return &c.Invoices.Issuer })},
+	{"", "INVOICE_WEBHOOK_SECRET", "", setString(func(c *Config) *string { return &c.Invoices.WebhookSecret })},
+	{"invoice_webhook_tolerance", "INVOICE_WEBHOOK_TOLERANCE", "How old a signed payment webhook can be, e.g. 5m", setDuration(func(c *Config) *time.Duration { return &c.Invoices.WebhookTolerance.Duration })},
+
+	{"assets_dir", "ASSETS_DIR", "Directory uploaded sponsor logos are kept in", setString(func(c *Config) *string { return &c.Assets.Dir })},
+	{"assets_max_logo_size", "ASSETS_MAX_LOGO_SIZE", "Biggest logo file that can be uploaded, in bytes", setInt(func(c *Config) *int { return &c.Assets.MaxLogoSize })},
+}
+
+// Environment variable that points at a config file, same as -config
+const configFileEnv = "SPONSOR_CONFIG"
+
+// Load builds the config from defaults, the config file, the environment
+// and the command line arguments (without the program name), then validates it
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("sponsor-service", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(configFileEnv), "YAML or TOML file to read settings from")
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.String(s.flag, "", s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Defaults()
+
+	if *configFile != "" {
+		if err := loadFile(c, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s | %w", s.env, err)
+			}
+		}
+	}
+
+	// Only flags that were actually passed override the environment
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(c, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("invalid value for -%s | %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadFile(c *Config, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file | %w", err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(contents, c)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(contents), c)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown settings %v", meta.Undecoded())
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse config file %s | %w", path, err)
+	}
+	return nil
+}
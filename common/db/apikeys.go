@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// APIKey lets a client call the REST API. Only a hash of the key is
+// stored, the key itself is shown once when it's created.
+type APIKey struct {
+	Model
+	Name string `gorm:"not null"`
+	// First characters of the key, so people can tell their keys apart
+	Prefix string `gorm:"not null"`
+	// sha256 of the key, hex encoded
+	Hash string `gorm:"uniqueIndex;not null"`
+	// Can manage API keys
+	Admin bool `gorm:"not null;default:false"`
+	// Keys made before roles existed could do everything, so they become organizers
+	Role string `gorm:"not null;default:organizer"`
+	// Sponsor a sponsorAdmin key manages
+	SponsorID *int `gorm:"index"`
+	RevokedAt *time.Time
+}
+
+func (k APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+func CreateAPIKey(ctx context.Context, name string, prefix string, hash string, admin bool, role string, sponsorId *int) (*APIKey, error) {
+	conn := Database.WithContext(ctx)
+	key := APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Admin:     admin,
+		Role:      role,
+		SponsorID: sponsorId,
+	}
+	err := conn.Create(&key).Error
+
+	return &key, translateError(err)
+}
+
+func GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	conn := Database.WithContext(ctx)
+	var key APIKey
+	err := conn.Where(&APIKey{Hash: hash}).First(&key).Error
+	return &key, err
+}
+
+func GetAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	conn := Database.WithContext(ctx)
+	var keys []APIKey
+	err := conn.Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func CountAPIKeys(ctx context.Context) (int64, error) {
+	conn := Database.WithContext(ctx)
+	var count int64
+	err := conn.Model(&APIKey{}).Count(&count).Error
+	return count, err
+}
+
+// RevokeAPIKey stops a key from working. Revoking a key twice keeps
+// the time it was first revoked.
+func RevokeAPIKey(ctx context.Context, id int) (*APIKey, error) {
+	conn := Database.WithContext(ctx)
+	var key APIKey
+	if err := conn.First(&key, id).Error; err != nil {
+		return &key, err
+	}
+	if key.Revoked() {
+		return &key, nil
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	return &key, translateError(conn.Save(&key).Error)
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// AuditEntry records who did what to a sponsor. Entries are never updated
+// or deleted, and outlive the sponsor they're about.
+type AuditEntry struct {
+	ID        int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	// API key, token subject or invite that did it
+	Actor     string `gorm:"not null"`
+	Action    string `gorm:"not null"`
+	SponsorID *int   `gorm:"index"`
+	// JSON with whatever else is worth knowing about the action
+	Details       string
+	CorrelationID string
+}
+
+func CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	conn := Database.WithContext(ctx)
+	return conn.Create(entry).Error
+}
+
+// GetAuditEntriesOfSponsor returns the newest entries first
+func GetAuditEntriesOfSponsor(ctx context.Context, sponsorId int, limit int) ([]AuditEntry, error) {
+	conn := Database.WithContext(ctx)
+	var entries []AuditEntry
+	err := conn.Where("sponsor_id = ?", sponsorId).Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Statuses of a Badge
+const (
+	BadgeIssued  = "issued"
+	BadgePrinted = "printed"
+	// It can't be used to get in anymore, like when its member left the team
+	BadgeRevoked = "revoked"
+)
+
+// BadgeStatuses lists every status, in order
+var BadgeStatuses = []string{BadgeIssued, BadgePrinted, BadgeRevoked}
+
+// Where a badge can go from each status. Revoked is the end, a member
+// whose badge was revoked can be issued a new one.
+var badgeTransitions = map[string][]string{
+	BadgeIssued:  {BadgePrinted, BadgeRevoked},
+	BadgePrinted: {BadgeRevoked},
+}
+
+// ErrBadgeTransition is returned for a status a badge can't go to from the one it has
+var ErrBadgeTransition = errors.New("the badge can't go to that status from its current one")
+
+// Badge is what a sponsor's member wears to get into the event. A member
+// has at most one badge that isn't revoked. What's printed on it is copied
+// when it's issued, and kept up to date with the member until it's printed.
+type Badge struct {
+	Model
+	Versioned
+	EventID   int `gorm:"not null;index"`
+	SponsorID int `gorm:"not null;index"`
+	MemberID  int `gorm:"not null;uniqueIndex:idx_badges_member,where:status <> 'revoked' AND deleted_at IS NULL"`
+	// Printed as a QR code, and read back at the door
+	Code        string `gorm:"not null;uniqueIndex"`
+	Name        string `gorm:"not null"`
+	Email       string `gorm:"not null"`
+	SponsorName string `gorm:"not null"`
+	// The sponsor's level, like "Gold"
+	LevelLabel string `gorm:"not null"`
+	Status     string `gorm:"not null;index"`
+	PrintedAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+// Letters and digits that can't be mistaken for each other when read off a badge
+const badgeCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// A random code like K7QM-2XHD-9PWA, 60 bits so they can't be guessed
+func newBadgeCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(badgeCodeAlphabet[int(c)%len(badgeCodeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// IssueBadge gives a member a badge, labelled with their sponsor's level.
+// A sponsor only gets allowance badges that aren't revoked, ErrAllowanceUsed
+// otherwise, and a member that already has one gets ErrDuplicate.
+func IssueBadge(ctx context.Context, member *Member, sponsor *Sponsor, levelLabel string, allowance int) (*Badge, error) {
+	code, err := newBadgeCode()
+	if err != nil {
+		return nil, err
+	}
+	badge := Badge{
+		EventID:     member.EventID,
+		SponsorID:   member.SponsorID,
+		MemberID:    member.ID,
+		Code:        code,
+		Name:        member.Name,
+		Email:       member.Email,
+		SponsorName: sponsor.Name,
+		LevelLabel:  levelLabel,
+		Status:      BadgeIssued,
+	}
+	err = Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockSponsor(tx, sponsor.ID); err != nil {
+			return err
+		}
+		var count int64
+		err := tx.Model(&Badge{}).Where("sponsor_id = ? AND status <> ?", sponsor.ID, BadgeRevoked).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(allowance) {
+			return ErrAllowanceUsed
+		}
+		return tx.Create(&badge).Error
+	})
+	return &badge, translateError(err)
+}
+
+func GetBadge(ctx context.Context, id int) (*Badge, error) {
+	conn := Database.WithContext(ctx)
+	var badge Badge
+	err := conn.First(&badge, id).Error
+	return &badge, err
+}
+
+// GetBadges returns an event's badges, sponsor by sponsor. sponsorId, status
+// and code narrow them down when they're not 0 or "", and revoked badges
+// are left out unless status asks for them.
+func GetBadges(ctx context.Context, eventId int, sponsorId int, status string, code string) ([]Badge, error) {
+	conn := Database.WithContext(ctx)
+	query := conn.Where("event_id = ?", eventId)
+	if sponsorId != 0 {
+		query = query.Where("sponsor_id = ?", sponsorId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", BadgeRevoked)
+	}
+	if code != "" {
+		query = query.Where("code = ?", strings.ToUpper(code))
+	}
+	var badges []Badge
+	err := query.Order("sponsor_id, name, id").Find(&badges).Error
+	return badges, err
+}
+
+// CanChangeBadgeStatus tells whether a badge can go from one status to another
+func CanChangeBadgeStatus(from string, to string) bool {
+	for _, s := range badgeTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextBadgeStatuses lists where a badge can go from status
+func NextBadgeStatuses(status string) []string {
+	return badgeTransitions[status]
+}
+
+// ChangeBadgeStatus marks a badge printed or revoked, ErrBadgeTransition
+// when it can't go there from its status. When version isn't 0, it's only
+// changed while it's still at that version, ErrVersionMismatch otherwise.
+func ChangeBadgeStatus(ctx context.Context, id int, status string, version int) (*Badge, error) {
+	conn := Database.WithContext(ctx)
+	var current Badge
+	if err := conn.First(&current, id).Error; err != nil {
+		return &current, err
+	}
+	if !CanChangeBadgeStatus(current.Status, status) {
+		return &current, ErrBadgeTransition
+	}
+
+	changes := map[string]interface{}{
+		"status":  status,
+		"version": gorm.Expr("version + 1"),
+	}
+	switch status {
+	case BadgePrinted:
+		changes["printed_at"] = time.Now()
+	case BadgeRevoked:
+		changes["revoked_at"] = time.Now()
+	}
+	query := conn.Model(&Badge{Model: Model{ID: id}}).Where("status = ?", current.Status)
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(changes)
+	if result.Error != nil {
+		return &current, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return &current, ErrVersionMismatch
+	}
+	var saved Badge
+	err := conn.First(&saved, id).Error
+	return &saved, err
+}
+
+// Revokes a member's badge, when they're taken off their team
+func revokeBadges(tx *gorm.DB, memberId int) error {
+	return tx.Model(&Badge{}).Where("member_id = ? AND status <> ?", memberId, BadgeRevoked).Updates(map[string]interface{}{
+		"status":     BadgeRevoked,
+		"revoked_at": time.Now(),
+		"version":    gorm.Expr("version + 1"),
+	}).Error
+}
+
+// Keeps a member's badge that wasn't printed yet in step with their name
+// and email. A printed one has to be revoked and issued again.
+func renameBadges(tx *gorm.DB, member *Member) error {
+	return tx.Model(&Badge{}).Where("member_id = ? AND status = ?", member.ID, BadgeIssued).Updates(map[string]interface{}{
+		"name":    member.Name,
+		"email":   member.Email,
+		"version": gorm.Expr("version + 1"),
+	}).Error
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Kinds of Benefit
+var BenefitKinds = []string{"booth", "logo", "talk", "social", "other"}
+
+// Statuses of a Deliverable
+const (
+	DeliverablePending    = "pending"
+	DeliverableInProgress = "inProgress"
+	DeliverableDelivered  = "delivered"
+	// We agreed with the sponsor not to deliver it
+	DeliverableWaived = "waived"
+)
+
+// DeliverableStatuses lists every status of a deliverable
+var DeliverableStatuses = []string{DeliverablePending, DeliverableInProgress, DeliverableDelivered, DeliverableWaived}
+
+// Benefit is something every sponsor on a level gets, beyond its free
+// badges: a booth, logo placement, a talk slot or social posts
+type Benefit struct {
+	Model
+	EventID int    `gorm:"not null;index"`
+	LevelID int    `gorm:"not null;uniqueIndex:idx_benefits_level_name,where:deleted_at IS NULL"`
+	Name    string `gorm:"not null;uniqueIndex:idx_benefits_level_name,where:deleted_at IS NULL"`
+	// One of BenefitKinds
+	Kind string `gorm:"not null"`
+	// How many of it, like 3 social posts
+	Quantity int `gorm:"not null"`
+	// Like "3x3m, front row" or "homepage footer"
+	Details string
+	// When it has to be delivered by, copied to each sponsor's deliverable
+	DueDate *time.Time
+}
+
+// Deliverable is a sponsor's copy of one of its level's benefits, to track
+// delivering it. What it promises is copied from the benefit, so changing the
+// level's benefits doesn't change what was already promised.
+type Deliverable struct {
+	Model
+	Versioned
+	EventID   int `gorm:"not null;index"`
+	SponsorID int `gorm:"not null;uniqueIndex:idx_deliverables_sponsor_benefit,where:deleted_at IS NULL"`
+	BenefitID int `gorm:"not null;uniqueIndex:idx_deliverables_sponsor_benefit,where:deleted_at IS NULL"`
+	Name      string
+	Kind      string
+	Quantity  int
+	Details   string
+	Status    string `gorm:"not null"`
+	// Who on our side is delivering it
+	Owner       string
+	DueDate     *time.Time
+	Notes       string
+	DeliveredAt *time.Time
+}
+
+// Done is true once there's nothing left to deliver
+func (d Deliverable) Done() bool {
+	return d.Status == DeliverableDelivered || d.Status == DeliverableWaived
+}
+
+// Overdue is true when its due date has passed and it isn't done.
+// It's due by the end of its due date.
+func (d Deliverable) Overdue(now time.Time) bool {
+	return !d.Done() && d.DueDate != nil && d.DueDate.Before(startOfDay(now))
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func newDeliverable(sponsor *Sponsor, benefit *Benefit) Deliverable {
+	return Deliverable{
+		EventID:   sponsor.EventID,
+		SponsorID: sponsor.ID,
+		BenefitID: benefit.ID,
+		Name:      benefit.Name,
+		Kind:      benefit.Kind,
+		Quantity:  benefit.Quantity,
+		Details:   benefit.Details,
+		Status:    DeliverablePending,
+		DueDate:   benefit.DueDate,
+	}
+}
+
+// Gives a sponsor a deliverable for each of its level's benefits it doesn't
+// have yet, and drops the ones for benefits of another level that weren't
+// started. Called wherever a sponsor gets a level or moves to another one.
+func syncDeliverables(tx *gorm.DB, sponsorId int) error {
+	var sponsor Sponsor
+	if err := tx.First(&sponsor, sponsorId).Error; err != nil {
+		return err
+	}
+	var benefits []Benefit
+	if sponsor.LevelID != nil {
+		if err := tx.Where("level_id = ?", *sponsor.LevelID).Order("id").Find(&benefits).Error; err != nil {
+			return err
+		}
+	}
+	var existing []Deliverable
+	if err := tx.Where("sponsor_id = ?", sponsor.ID).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	onLevel := map[int]bool{}
+	for _, b := range benefits {
+		onLevel[b.ID] = true
+	}
+	has := map[int]bool{}
+	var stale []int
+	for _, d := range existing {
+		has[d.BenefitID] = true
+		if !onLevel[d.BenefitID] && d.Status == DeliverablePending {
+			stale = append(stale, d.ID)
+		}
+	}
+	if len(stale) > 0 {
+		if err := tx.Delete(&Deliverable{}, stale).Error; err != nil {
+			return err
+		}
+	}
+	for i := range benefits {
+		if has[benefits[i].ID] {
+			continue
+		}
+		d := newDeliverable(&sponsor, &benefits[i])
+		if err := tx.Create(&d).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBenefits returns a level's benefits, oldest first
+func GetBenefits(ctx context.Context, levelId int) ([]Benefit, error) {
+	conn := Database.WithContext(ctx)
+	var benefits []Benefit
+	err := conn.Where("level_id = ?", levelId).Order("id").Find(&benefits).Error
+	return benefits, err
+}
+
+func GetBenefit(ctx context.Context, id int) (*Benefit, error) {
+	conn := Database.WithContext(ctx)
+	var benefit Benefit
+	err := conn.First(&benefit, id).Error
+	return &benefit, err
+}
+
+// CreateBenefit adds a benefit to a level, and a deliverable for it to every
+// sponsor on the level that isn't cancelled. It returns how many sponsors
+// got one, and ErrDuplicate when the level already has a benefit by that name.
+func CreateBenefit(ctx context.Context, benefit Benefit) (*Benefit, int, error) {
+	var given int
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&benefit).Error; err != nil {
+			return err
+		}
+		var sponsors []Sponsor
+		err := tx.Where("level_id = ? AND status <> ?", benefit.LevelID, SponsorCancelled).Order("id").Find(&sponsors).Error
+		if err != nil {
+			return err
+		}
+		for i := range sponsors {
+			d := newDeliverable(&sponsors[i], &benefit)
+			if err := tx.Create(&d).Error; err != nil {
+				return err
+			}
+		}
+		given = len(sponsors)
+		return nil
+	})
+	return &benefit, given, translateError(err)
+}
+
+// DeleteBenefit takes a benefit off its level. Deliverables for it that
+// weren't started go with it, the rest were already promised and stay.
+func DeleteBenefit(ctx context.Context, id int) (*Benefit, error) {
+	var benefit Benefit
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&benefit, id).Error; err != nil {
+			return err
+		}
+		err := tx.Where("benefit_id = ? AND status = ?", id, DeliverablePending).Delete(&Deliverable{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&benefit).Error
+	})
+	return &benefit, err
+}
+
+// GetDeliverables returns a sponsor's deliverables, soonest due first
+func GetDeliverables(ctx context.Context, sponsorId int) ([]Deliverable, error) {
+	conn := Database.WithContext(ctx)
+	var deliverables []Deliverable
+	err := conn.Where("sponsor_id = ?", sponsorId).Order(dueFirst).Find(&deliverables).Error
+	return deliverables, err
+}
+
+// Soonest due first, ones without a due date last
+const dueFirst = "CASE WHEN due_date IS NULL THEN 1 ELSE 0 END, due_date, id"
+
+// GetEventDeliverables returns the deliverables of an event's sponsors that
+// aren't cancelled, soonest due first. status and owner narrow them down
+// when they're not "", and overdue keeps only the ones past their due date
+// at now.
+func GetEventDeliverables(ctx context.Context, eventId int, status string, owner string, overdue bool, now time.Time) ([]Deliverable, error) {
+	conn := Database.WithContext(ctx)
+	query := conn.Where("event_id = ?", eventId).
+		Where("sponsor_id IN (?)", conn.Model(&Sponsor{}).Select("id").Where("event_id = ? AND status <> ?", eventId, SponsorCancelled))
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if owner != "" {
+		query = query.Where("owner = ?", owner)
+	}
+	if overdue {
+		query = query.Where("status IN ? AND due_date < ?", []string{DeliverablePending, DeliverableInProgress}, startOfDay(now))
+	}
+	var deliverables []Deliverable
+	err := query.Order(dueFirst).Find(&deliverables).Error
+	return deliverables, err
+}
+
+func GetDeliverable(ctx context.Context, id int) (*Deliverable, error) {
+	conn := Database.WithContext(ctx)
+	var deliverable Deliverable
+	err := conn.First(&deliverable, id).Error
+	return &deliverable, err
+}
+
+// UpdateDeliverable saves a deliverable's status, owner, due date and notes.
+// DeliveredAt is set when it becomes delivered, and cleared when it stops
+// being. When version isn't 0, it's only changed while it's still at that
+// version, ErrVersionMismatch otherwise.
+func UpdateDeliverable(ctx context.Context, d Deliverable, version int) (*Deliverable, error) {
+	conn := Database.WithContext(ctx)
+	var current Deliverable
+	if err := conn.First(&current, d.ID).Error; err != nil {
+		return &current, err
+	}
+	deliveredAt := current.DeliveredAt
+	if d.Status != DeliverableDelivered {
+		deliveredAt = nil
+	} else if current.Status != DeliverableDelivered {
+		now := time.Now()
+		deliveredAt = &now
+	}
+
+	query := conn.Model(&Deliverable{Model: Model{ID: d.ID}})
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":       d.Status,
+		"owner":        d.Owner,
+		"due_date":     d.DueDate,
+		"notes":        d.Notes,
+		"delivered_at": deliveredAt,
+		"version":      gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return &current, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &current, ErrVersionMismatch
+	}
+	var saved Deliverable
+	err := conn.First(&saved, d.ID).Error
+	return &saved, err
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// Points Database at a new, empty in-memory SQLite database
+func useTestDB(t *testing.T) context.Context {
+	t.Helper()
+	InitDB(Creds{Driver: DriverSqlite, SqlitePath: SqliteInMemory})
+	conn := Database
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return context.Background()
+}
@@ -1,159 +1,558 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/jackc/pgconn"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
+	"strings"
+	"time"
 )
 
+// Model is our version of gorm.Model. gorm.Model uses a uint primary key,
+// the rest of the service passes IDs around as plain ints.
+type Model struct {
+	ID        int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// Versioned counts the changes to a row, the REST API sends it as an ETag
+// so clients can't overwrite each other's changes
+type Versioned struct {
+	Version int `gorm:"not null;default:1"`
+}
+
+func (v *Versioned) BeforeCreate(tx *gorm.DB) error {
+	if v.Version == 0 {
+		v.Version = 1
+	}
+	return nil
+}
+
+// ErrVersionMismatch is returned when a row changed since the version the caller last saw
+var ErrVersionMismatch = errors.New("the record was changed by someone else")
+
+// Unique indexes only cover rows that have not been soft deleted,
+// so a deleted sponsor/level/member name can be used again.
 type Level struct {
-	gorm.Model
-	ID                    int `gorm:"primary_key"`
-	EventID               int
-	Name                  string
+	Model
+	Versioned
+	EventID               int    `gorm:"not null;uniqueIndex:idx_levels_event_name,where:deleted_at IS NULL"`
+	Name                  string `gorm:"uniqueIndex:idx_levels_event_name,where:deleted_at IS NULL"`
 	Cost                  string
 	MaxNumberOfSponsors   int
 	MaxNumberOfFreeBadges int
 }
 
 type Member struct {
-	gorm.Model
-	ID        int `gorm:"primary_key"`
+	Model
+	Versioned
 	Name      string
-	Email     string
-	SponsorID int
-	EventID   int
+	Email     string `gorm:"uniqueIndex:idx_members_sponsor_email,where:deleted_at IS NULL"`
+	SponsorID int    `gorm:"not null;uniqueIndex:idx_members_sponsor_email,where:deleted_at IS NULL"`
+	EventID   int    `gorm:"not null"`
 }
 
 type Sponsor struct {
-	gorm.Model
-	ID        int `gorm:"primary_key"`
-	EventID   int
-	Name      string
-	LevelID   int
+	Model
+	Versioned
+	EventID   int    `gorm:"not null;uniqueIndex:idx_sponsors_event_name,where:deleted_at IS NULL"`
+	Name      string `gorm:"uniqueIndex:idx_sponsors_event_name,where:deleted_at IS NULL"`
+	LevelID   *int   // nil when the sponsor has no sponsorship level yet
 	LevelName string
-	Level     Level
-	Members   []Member
+	Level     Level    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Members   []Member `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// One of SponsorStatuses. Sponsors from before there were statuses are taken to be
+	// contracted, or prospects when they have no level.
+	Status          string `gorm:"not null;default:contracted;index"`
+	StatusChangedAt *time.Time
+	Logo            Logo `gorm:"embedded;embeddedPrefix:logo_"`
 }
 
 type Event struct {
-	gorm.Model
-	ID             int `gorm:"primary_key"`
+	Model
+	Versioned
 	EventServiceID int
 	Name           string
-	Levels         []Level
-	Sponsors       []Sponsor
+	Levels         []Level   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Sponsors       []Sponsor `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Only here so members get a foreign key to their event,
+	// GetEvent and GetAllEvents do not load it
+	Members []Member `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// ErrDuplicate is returned when a create or update would break one of the
+// unique constraints (sponsor name per event, level name per event or
+// member email per sponsor)
+var ErrDuplicate = errors.New("a record with the same name already exists")
+
+// Translates driver specific errors into errors the router can check for
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func CreateMember(ctx context.Context, name string, email string, sponsorId int, eventId int) (*Member, error) {
+	conn := Database.WithContext(ctx)
+	member := Member{
+		Name:      name,
+		Email:     email,
+		SponsorID: sponsorId,
+		EventID:   eventId,
+	}
+	err := conn.Create(&member).Error
+
+	return &member, translateError(err)
 }
 
-func initialMigration(db *gorm.DB) {
-	db.AutoMigrate(&Level{})
-	db.AutoMigrate(&Member{})
-	db.AutoMigrate(&Sponsor{})
-	db.AutoMigrate(&Event{})
+// UpdateMember changes a member's name and email, and returns the member as
+// it was before too. When version isn't 0, the member is only changed while
+// it's still at that version, ErrVersionMismatch otherwise. A badge that
+// wasn't printed yet gets the new name and email too.
+func UpdateMember(ctx context.Context, id int, name string, email string, version int) (*Member, *Member, error) {
+	var previous, member Member
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&previous, id).Error; err != nil {
+			return err
+		}
+
+		// A copy, so previous keeps the old values
+		query := tx.Model(&Member{Model: Model{ID: id}})
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{
+			"name":    name,
+			"email":   email,
+			"version": gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
+		if err := tx.First(&member, id).Error; err != nil {
+			return err
+		}
+		return renameBadges(tx, &member)
+	})
+	return &member, &previous, translateError(err)
 }
 
-func CreateMember(name string, email string, sponsorId int) *Member {
+// ErrAllowanceUsed is returned when a sponsor already has all the members
+// their level's free badges allow
+var ErrAllowanceUsed = errors.New("the sponsor has used all of its free badges")
+
+// CreateMemberWithinAllowance only adds the member while the sponsor has
+// fewer than allowance members. The sponsor's row is locked while counting
+// so two requests can't both take the last badge.
+func CreateMemberWithinAllowance(ctx context.Context, name string, email string, sponsorId int, eventId int, allowance int) (*Member, error) {
 	member := Member{
 		Name:      name,
 		Email:     email,
 		SponsorID: sponsorId,
+		EventID:   eventId,
 	}
-	Database.Create(&member)
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockSponsor(tx, sponsorId); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&Member{}).Where(&Member{SponsorID: sponsorId}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(allowance) {
+			return ErrAllowanceUsed
+		}
+		return tx.Create(&member).Error
+	})
 
-	return &member
+	return &member, translateError(err)
 }
 
-func GetLevel(id int) (*Level, error) {
+func GetMember(ctx context.Context, id int) (*Member, error) {
+	conn := Database.WithContext(ctx)
+	var member Member
+	err := conn.First(&member, id).Error
+	return &member, err
+}
+
+func GetMembersOfSponsor(ctx context.Context, sponsorId int) ([]Member, error) {
+	conn := Database.WithContext(ctx)
+	var members []Member
+	err := conn.Where(&Member{SponsorID: sponsorId}).Order("id").Find(&members).Error
+	return members, err
+}
+
+// DeleteMember soft deletes a member, their email can then be used again.
+// Their badge is revoked.
+func DeleteMember(ctx context.Context, id int) error {
+	return Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Member{}, id).Error; err != nil {
+			return err
+		}
+		return revokeBadges(tx, id)
+	})
+}
+
+func GetLevel(ctx context.Context, id int) (*Level, error) {
+	conn := Database.WithContext(ctx)
 	var level Level
 	var error error
-	err := Database.First(&level, id)
+	err := conn.First(&level, id)
 	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
 		error = gorm.ErrRecordNotFound
 	}
 	return &level, error
 }
 
-func CreateLevel(name string, cost string, maxNumSponsors int, maxNumBadges int, eventId int) *Level {
+func CreateLevel(ctx context.Context, name string, cost string, maxNumSponsors int, maxNumBadges int, eventId int) (*Level, error) {
+	var level *Level
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		level, err = createLevel(tx, LevelChange{
+			Name:                  name,
+			Cost:                  cost,
+			MaxNumberOfSponsors:   maxNumSponsors,
+			MaxNumberOfFreeBadges: maxNumBadges,
+		}, eventId)
+		return err
+	})
+	if level == nil {
+		level = &Level{}
+	}
+	return level, translateError(err)
+}
+
+// UpdateLevel changes one of an event's levels. A level of another event
+// gets gorm.ErrRecordNotFound, levels never move between events. When
+// version isn't 0, the level is only changed while it's still at that
+// version, ErrVersionMismatch otherwise.
+func UpdateLevel(ctx context.Context, id int, name string, cost string, maxNumSponsors int, maxNumBadges int, eventId int, version int) (*Level, error) {
+	var level *Level
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		level, err = updateLevel(tx, LevelChange{
+			ID:                    id,
+			Version:               version,
+			Name:                  name,
+			Cost:                  cost,
+			MaxNumberOfSponsors:   maxNumSponsors,
+			MaxNumberOfFreeBadges: maxNumBadges,
+		}, eventId)
+		return err
+	})
+	if level == nil {
+		level = &Level{}
+	}
+	return level, translateError(err)
+}
+
+// LevelChange is a level to add to an event, or one to change when ID isn't
+// 0. Version is checked like UpdateLevel's.
+type LevelChange struct {
+	ID                    int
+	Version               int
+	Name                  string
+	Cost                  string
+	MaxNumberOfSponsors   int
+	MaxNumberOfFreeBadges int
+}
+
+// LevelChangeError says which of the levels sent to UpdateEventWithLevels
+// couldn't be saved
+type LevelChangeError struct {
+	Index int
+	Err   error
+}
+
+func (e *LevelChangeError) Error() string {
+	return fmt.Sprintf("level %d: %s", e.Index, e.Err)
+}
+
+func (e *LevelChangeError) Unwrap() error {
+	return e.Err
+}
+
+func createLevel(tx *gorm.DB, change LevelChange, eventId int) (*Level, error) {
 	level := Level{
-		Name:                  name,
+		Name:                  change.Name,
 		EventID:               eventId,
-		MaxNumberOfSponsors:   maxNumSponsors,
-		MaxNumberOfFreeBadges: maxNumBadges,
-		Cost:                  cost,
+		MaxNumberOfSponsors:   change.MaxNumberOfSponsors,
+		MaxNumberOfFreeBadges: change.MaxNumberOfFreeBadges,
+		Cost:                  change.Cost,
 	}
-	Database.Create(&level)
+	if err := tx.Create(&level).Error; err != nil {
+		return nil, err
+	}
+	return &level, touchEvent(tx, eventId)
+}
 
-	event := Event{}
-	// Get Levels array from Event and update it
-	Database.First(&event, eventId)
-	event.Levels = append(event.Levels, level)
-	Database.Save(&event)
+func updateLevel(tx *gorm.DB, change LevelChange, eventId int) (*Level, error) {
+	var level Level
+	if err := tx.Where("event_id = ?", eventId).First(&level, change.ID).Error; err != nil {
+		return nil, err
+	}
+	query := tx.Model(&level)
+	if change.Version != 0 {
+		query = query.Where("version = ?", change.Version)
+	}
+	result := query.Updates(map[string]interface{}{
+		"name":                      change.Name,
+		"cost":                      change.Cost,
+		"max_number_of_sponsors":    change.MaxNumberOfSponsors,
+		"max_number_of_free_badges": change.MaxNumberOfFreeBadges,
+		"version":                   gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrVersionMismatch
+	}
+	if err := tx.First(&level, change.ID).Error; err != nil {
+		return nil, err
+	}
+	return &level, touchEvent(tx, eventId)
+}
 
-	return &level
+// A change to an event's levels is a change to the event
+func touchEvent(tx *gorm.DB, eventId int) error {
+	return tx.Model(&Event{}).Where("id = ?", eventId).
+		UpdateColumn("version", gorm.Expr("version + 1")).Error
 }
 
-func UpdateLevel(id int, name string, cost string, maxNumSponsors int, maxNumBadges int, eventId int) (*Level, error) {
-	var level Level
-	err := Database.First(&level, id)
-	level.Name = name
-	level.Cost = cost
-	level.MaxNumberOfSponsors = maxNumSponsors
-	level.MaxNumberOfFreeBadges = maxNumBadges
-	level.EventID = eventId
-	Database.Save(&level)
+// ErrLevelFull is returned when a level already has as many sponsors as it allows
+var ErrLevelFull = errors.New("the level has no sponsor spots left")
 
-	return &level, err.Error
+// Makes the rows tx reads locked until the transaction ends. SQLite has
+// no row locks, it only ever has one writer anyway.
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	if tx.Dialector.Name() == "postgres" {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	return tx
 }
 
-func CreateSponsorWithLevel(name string, levelId int, eventId int) *Sponsor {
+// Gets a level, locked so two requests can't both take its last spot
+func lockLevel(tx *gorm.DB, levelId int) (*Level, error) {
 	level := Level{}
-	Database.First(&level, levelId)
+	err := forUpdate(tx).First(&level, levelId).Error
+	return &level, err
+}
 
-	sponsor := Sponsor{
-		Name:      name,
-		EventID:   eventId,
-		LevelID:   levelId,
-		LevelName: level.Name,
-		Level:     level,
+// Gets a sponsor, locked so two requests can't both take its last badge
+func lockSponsor(tx *gorm.DB, sponsorId int) (*Sponsor, error) {
+	sponsor := Sponsor{}
+	err := forUpdate(tx).First(&sponsor, sponsorId).Error
+	return &sponsor, err
+}
+
+// How many of a level's spots are gone, to sponsors on it that hold one,
+// and to waitlist offers and reservations that haven't expired yet
+func levelTaken(tx *gorm.DB, levelId int, now time.Time) (int64, error) {
+	var sponsors, offers, reservations int64
+	err := tx.Model(&Sponsor{}).Where("level_id = ? AND status IN ?", levelId, spotStatuses).Count(&sponsors).Error
+	if err != nil {
+		return 0, err
 	}
-	Database.Create(&sponsor)
+	err = tx.Model(&WaitlistEntry{}).
+		Where("level_id = ? AND status = ? AND offer_expires_at > ?", levelId, WaitlistOffered, now).
+		Count(&offers).Error
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Model(&Reservation{}).Where("level_id = ? AND expires_at > ?", levelId, now).Count(&reservations).Error
+	return sponsors + offers + reservations, err
+}
+
+// Returns ErrLevelFull when the level has no spots left.
+// Levels with a MaxNumberOfSponsors of 0 take any number of sponsors.
+func checkLevelHasRoom(tx *gorm.DB, level *Level) error {
+	if level.MaxNumberOfSponsors == 0 {
+		return nil
+	}
+	taken, err := levelTaken(tx, level.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if taken >= int64(level.MaxNumberOfSponsors) {
+		return ErrLevelFull
+	}
+	return nil
+}
+
+// CreateSponsorWithLevel only adds the sponsor while the level has spots
+// left. It starts out reserved, holding one of them.
+func CreateSponsorWithLevel(ctx context.Context, name string, levelId int, eventId int) (*Sponsor, error) {
+	var sponsor Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		locked, err := lockLevel(tx, levelId)
+		if err != nil {
+			return err
+		}
+		level := *locked
+		if err := checkLevelHasRoom(tx, &level); err != nil {
+			return err
+		}
 
-	event := Event{}
-	Database.First(&event, eventId)
-	event.Sponsors = append(event.Sponsors, sponsor)
-	Database.Save(&event)
+		sponsor = Sponsor{
+			Name:      name,
+			EventID:   eventId,
+			LevelID:   &levelId,
+			LevelName: level.Name,
+			Level:     level,
+			Status:    SponsorReserved,
+		}
+		if err := tx.Create(&sponsor).Error; err != nil {
+			return err
+		}
+		return syncDeliverables(tx, sponsor.ID)
+	})
 
-	return &sponsor
+	return &sponsor, translateError(err)
 }
 
-func CreateSponsor(name string, eventId int) *Sponsor {
+// CreateSponsor adds a sponsor without a level, as a prospect
+func CreateSponsor(ctx context.Context, name string, eventId int) (*Sponsor, error) {
+	conn := Database.WithContext(ctx)
 	sponsor := Sponsor{
 		Name:    name,
 		EventID: eventId,
+		Status:  SponsorProspect,
 	}
-	Database.Create(&sponsor)
+	err := conn.Create(&sponsor).Error
+
+	return &sponsor, translateError(err)
+}
+
+// ChangeSponsor renames a sponsor and moves it to another level, or off its
+// level when levelId is nil. Moving to a level needs a spot on it, unless the
+// sponsor is cancelled, and a prospect is reserved so it holds the spot.
+// Taking a reserved sponsor off its level makes it a prospect again, one
+// that's contracted or further along gets ErrNoLevel, it has to be cancelled
+// instead. When version isn't 0, the sponsor is only changed while it's still at that
+// version, ErrVersionMismatch otherwise. Returns the sponsor as it was before too.
+func ChangeSponsor(ctx context.Context, id int, name string, levelId *int, changedBy string, version int) (*Sponsor, *Sponsor, error) {
+	var sponsor, previous Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&previous, id).Error; err != nil {
+			return err
+		}
 
-	event := Event{}
-	// Get Sponsors array from event and update it
-	Database.First(&event, eventId)
-	event.Sponsors = append(event.Sponsors, sponsor)
-	Database.Save(&event)
+		changes := map[string]interface{}{
+			"name":       name,
+			"level_id":   levelId,
+			"level_name": "",
+			"version":    gorm.Expr("version + 1"),
+		}
+		if levelId != nil {
+			level, err := lockLevel(tx, *levelId)
+			if err != nil {
+				return err
+			}
+			if previous.Status != SponsorCancelled && (previous.LevelID == nil || *previous.LevelID != *levelId) {
+				if err := checkLevelHasRoom(tx, level); err != nil {
+					return err
+				}
+			}
+			if previous.Status == SponsorProspect {
+				changes["status"] = SponsorReserved
+				changes["status_changed_at"] = time.Now()
+			}
+			changes["level_name"] = level.Name
+		} else if previous.LevelID != nil {
+			switch {
+			case previous.Status == SponsorReserved:
+				changes["status"] = SponsorProspect
+				changes["status_changed_at"] = time.Now()
+			case HoldsSpot(previous.Status):
+				return ErrNoLevel
+			}
+		}
 
-	return &sponsor
+		query := tx.Model(&Sponsor{Model: Model{ID: id}})
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(changes)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
+		if to, ok := changes["status"].(string); ok {
+			if _, err := recordStatusChange(tx, id, previous.Status, to, LevelChangeReason(levelId), changedBy); err != nil {
+				return err
+			}
+		}
+		// It doesn't need to wait for the level it's on now
+		if levelId != nil {
+			if err := withdrawFromWaitlists(tx, id, levelId); err != nil {
+				return err
+			}
+		}
+		if err := syncDeliverables(tx, id); err != nil {
+			return err
+		}
+		return tx.First(&sponsor, id).Error
+	})
+	return &sponsor, &previous, translateError(err)
 }
 
-func GetSponsor(id int) (*Sponsor, error) {
+// DeleteSponsor soft deletes a sponsor, which frees its spot on its level
+// and takes it off every waitlist
+func DeleteSponsor(ctx context.Context, id int, version int) (*Sponsor, error) {
+	var sponsor Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&sponsor, id).Error; err != nil {
+			return err
+		}
+		query := tx
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Delete(&Sponsor{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
+		return withdrawFromWaitlists(tx, id, nil)
+	})
+	return &sponsor, err
+}
+
+func GetSponsor(ctx context.Context, id int) (*Sponsor, error) {
+	conn := Database.WithContext(ctx)
 	var sponsor Sponsor
 	var error error
-	err := Database.First(&sponsor, id)
+	err := conn.First(&sponsor, id)
 	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
 		error = gorm.ErrRecordNotFound
 	}
 	return &sponsor, error
 }
 
-func GetEvent(id int, eventServiceId int) (*Event, error) {
+func GetEvent(ctx context.Context, id int, eventServiceId int) (*Event, error) {
+	conn := Database.WithContext(ctx)
 	var levels []Level
 	var sponsors []Sponsor
 	var event Event
@@ -162,58 +561,90 @@ func GetEvent(id int, eventServiceId int) (*Event, error) {
 	idToUse := id
 	if idToUse == -1 {
 		idToUse = eventServiceId
-		err := Database.Where(&Event{EventServiceID: idToUse}).First(&event)
+		err := conn.Where(&Event{EventServiceID: idToUse}).First(&event)
 		if errors.Is(err.Error, gorm.ErrRecordNotFound) {
 			error = gorm.ErrRecordNotFound
 		}
 	} else {
 		idToUse = id
-		err := Database.First(&event, idToUse)
+		err := conn.First(&event, idToUse)
 		if errors.Is(err.Error, gorm.ErrRecordNotFound) {
 			error = gorm.ErrRecordNotFound
 		}
 	}
 
-	Database.Where(&Level{EventID: id}).Find(&levels)
-	Database.Where(&Sponsor{EventID: id}).Find(&sponsors)
+	// By the event's own ID, id is -1 when it's looked up by the event service's
+	if error == nil {
+		conn.Where(&Level{EventID: event.ID}).Find(&levels)
+		conn.Where(&Sponsor{EventID: event.ID}).Find(&sponsors)
+	}
 	event.Sponsors = sponsors
 	event.Levels = levels
 
 	return &event, error
 }
 
-func UpdateEvent(eventId int, eventName string) (*Event, error) {
-	var event Event
-	var levels []Level
-	var error error
-	err := Database.First(&event, eventId)
-	if errors.Is(err.Error, gorm.ErrRecordNotFound) {
-		error = gorm.ErrRecordNotFound
-	}
+// UpdateEvent renames an event. When version isn't 0, the event is only
+// changed while it's still at that version, ErrVersionMismatch otherwise.
+func UpdateEvent(ctx context.Context, eventId int, eventName string, version int) (*Event, error) {
+	return UpdateEventWithLevels(ctx, eventId, eventName, nil, version)
+}
 
-	Database.Where(&Level{EventID: eventId}).Find(&levels)
-	if levels != nil {
-		event.Levels = levels
-	}
+// UpdateEventWithLevels renames an event and adds or changes its levels, in
+// one transaction so a level that can't be saved leaves it all as it was.
+// Those errors are a *LevelChangeError saying which level it was.
+func UpdateEventWithLevels(ctx context.Context, eventId int, eventName string, levels []LevelChange, version int) (*Event, error) {
+	var event Event
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&event, eventId).Error; err != nil {
+			return err
+		}
+		query := tx.Model(&event)
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{
+			"name":    eventName,
+			"version": gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
 
-	if err != nil {
-		event.Name = eventName
-		Database.Save(&event)
-	}
+		for i, change := range levels {
+			var err error
+			if change.ID == 0 {
+				_, err = createLevel(tx, change, eventId)
+			} else {
+				_, err = updateLevel(tx, change, eventId)
+			}
+			if err != nil {
+				return &LevelChangeError{Index: i, Err: translateError(err)}
+			}
+		}
 
-	return &event, error
+		if err := tx.First(&event, eventId).Error; err != nil {
+			return err
+		}
+		return tx.Where(&Level{EventID: eventId}).Order("id").Find(&event.Levels).Error
+	})
+	return &event, err
 }
 
-func GetAllEvents() *[]Event {
+func GetAllEvents(ctx context.Context) *[]Event {
+	conn := Database.WithContext(ctx)
 	var events []Event
-	Database.Find(&events)
+	conn.Find(&events)
 
 	for i, _ := range events {
 		var levels []Level
 		var sponsors []Sponsor
 
-		Database.Where(&Level{EventID: events[i].ID}).Find(&levels)
-		Database.Where(&Sponsor{EventID: events[i].ID}).Find(&sponsors)
+		conn.Where(&Level{EventID: events[i].ID}).Find(&levels)
+		conn.Where(&Sponsor{EventID: events[i].ID}).Find(&sponsors)
 
 		events[i].Sponsors = sponsors
 		events[i].Levels = levels
@@ -222,11 +653,12 @@ func GetAllEvents() *[]Event {
 	return &events
 }
 
-func CreateEvent(name string, eventId int) *Event {
+func CreateEvent(ctx context.Context, name string, eventId int) *Event {
+	conn := Database.WithContext(ctx)
 	var event Event
 	event.Name = name
 	event.EventServiceID = eventId
-	Database.Create(&event)
+	conn.Create(&event)
 
 	return &event
 }
@@ -234,24 +666,97 @@ func CreateEvent(name string, eventId int) *Event {
 // Initialize variables
 var Database *gorm.DB
 
+// Supported values for Creds.Driver
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+)
+
+// Path to pass as Creds.SqlitePath to keep the whole database in memory
+const SqliteInMemory = ":memory:"
+
 type Creds struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	Dbname   string
-	Sslmode  string
+	Driver     string
+	Host       string
+	Port       string
+	User       string
+	Password   string
+	Dbname     string
+	Sslmode    string
+	SqlitePath string
+
+	// Connection pool settings, 0 keeps the database/sql defaults
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Ping checks the database can still be reached, and returns
+// the connection pool stats for /readyz
+func Ping(ctx context.Context) (sql.DBStats, error) {
+	if Database == nil {
+		return sql.DBStats{}, errors.New("database has not been initialized yet")
+	}
+	sqlDB, err := Database.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return sqlDB.Stats(), sqlDB.PingContext(ctx)
+}
+
+func openDialector(o Creds) (gorm.Dialector, error) {
+	switch o.Driver {
+	case "", DriverPostgres:
+		return postgres.New(postgres.Config{
+			DSN:                  fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", o.Host, o.Port, o.User, o.Password, o.Dbname, o.Sslmode),
+			PreferSimpleProtocol: true,
+		}), nil
+	case DriverSqlite:
+		// SQLite leaves foreign keys off unless they're turned on per connection
+		dsn := fmt.Sprintf("file:%s?_foreign_keys=1&_busy_timeout=5000", o.SqlitePath)
+		if o.SqlitePath == SqliteInMemory {
+			dsn = "file::memory:?_foreign_keys=1"
+		}
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown db driver %q, use %q or %q", o.Driver, DriverPostgres, DriverSqlite)
+	}
 }
 
 func InitDB(o Creds) {
-	var err error
-	Database, err = gorm.Open(postgres.New(postgres.Config{
-		DSN:                  fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", o.Host, o.Port, o.User, o.Password, o.Dbname, o.Sslmode),
-		PreferSimpleProtocol: true,
-	}), &gorm.Config{})
+	dialector, err := openDialector(o)
 	if err != nil {
 		panic(err)
 	}
 
-	initialMigration(Database)
+	Database, err = gorm.Open(dialector, &gorm.Config{
+		Logger: queryLogger{level: gormlogger.Warn},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	sqlDB, err := Database.DB()
+	if err != nil {
+		panic(err)
+	}
+	if o.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(o.MaxIdleConns)
+	}
+	if o.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(o.ConnMaxLifetime)
+	}
+	if o.Driver == DriverSqlite {
+		// SQLite only allows one writer at a time, and every connection to
+		// an in-memory database gets its own empty database.
+		// One connection avoids both problems.
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	if err = migrate(Database); err != nil {
+		panic(err)
+	}
 }
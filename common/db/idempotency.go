@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is the stored response to a request sent with an
+// Idempotency-Key header. StatusCode is 0 while the first request is still
+// being handled.
+type IdempotencyRecord struct {
+	ID        int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	// Whoever sent the request, so two callers can use the same key
+	Scope          string `gorm:"not null;uniqueIndex:idx_idempotency_scope_key"`
+	IdempotencyKey string `gorm:"not null;uniqueIndex:idx_idempotency_scope_key"`
+	// Hash of the method, path and body, a retry has to send the same request
+	RequestHash string `gorm:"not null"`
+	StatusCode  int
+	// JSON of the response headers worth sending again, like Content-Type and ETag
+	Headers   string
+	Body      []byte
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// CreateIdempotencyRecord claims a key, it returns ErrDuplicate when the
+// key is already taken
+func CreateIdempotencyRecord(ctx context.Context, record *IdempotencyRecord) error {
+	conn := Database.WithContext(ctx)
+	return translateError(conn.Create(record).Error)
+}
+
+func GetIdempotencyRecord(ctx context.Context, scope string, key string) (*IdempotencyRecord, error) {
+	conn := Database.WithContext(ctx)
+	var record IdempotencyRecord
+	err := conn.Where("scope = ? AND idempotency_key = ?", scope, key).First(&record).Error
+	return &record, err
+}
+
+// CompleteIdempotencyRecord stores the response so retries get it back
+func CompleteIdempotencyRecord(ctx context.Context, id int, statusCode int, headers string, body []byte) error {
+	conn := Database.WithContext(ctx)
+	return conn.Model(&IdempotencyRecord{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status_code": statusCode,
+		"headers":     headers,
+		"body":        body,
+	}).Error
+}
+
+func DeleteIdempotencyRecord(ctx context.Context, id int) error {
+	conn := Database.WithContext(ctx)
+	return conn.Delete(&IdempotencyRecord{}, id).Error
+}
+
+// DeleteExpiredIdempotencyRecords returns how many records it removed
+func DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int64, error) {
+	conn := Database.WithContext(ctx)
+	result := conn.Where("expires_at <= ?", now).Delete(&IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Invite is a self-service link a sponsor's contact uses to manage their own
+// team. The link itself is signed and never stored, this row is what lets
+// an organizer revoke it early.
+type Invite struct {
+	Model
+	SponsorID int       `gorm:"not null;index"`
+	Sponsor   *Sponsor  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	// Who made the link
+	CreatedBy string
+}
+
+// Usable is false once the invite has expired or been revoked
+func (i Invite) Usable(now time.Time) bool {
+	return i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+func CreateInvite(ctx context.Context, sponsorId int, expiresAt time.Time, createdBy string) (*Invite, error) {
+	conn := Database.WithContext(ctx)
+	invite := Invite{
+		SponsorID: sponsorId,
+		ExpiresAt: expiresAt,
+		CreatedBy: createdBy,
+	}
+	err := conn.Create(&invite).Error
+	return &invite, err
+}
+
+func GetInvite(ctx context.Context, id int) (*Invite, error) {
+	conn := Database.WithContext(ctx)
+	var invite Invite
+	err := conn.First(&invite, id).Error
+	return &invite, err
+}
+
+// RevokeInvite stops an invite link from working before it expires
+func RevokeInvite(ctx context.Context, id int) (*Invite, error) {
+	conn := Database.WithContext(ctx)
+	var invite Invite
+	if err := conn.First(&invite, id).Error; err != nil {
+		return &invite, err
+	}
+	if invite.RevokedAt != nil {
+		return &invite, nil
+	}
+
+	now := time.Now()
+	invite.RevokedAt = &now
+	return &invite, conn.Save(&invite).Error
+}
@@ -0,0 +1,413 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of an Invoice. Drafts have no number yet, it's given when they're
+// issued so voided drafts don't leave gaps.
+const (
+	InvoiceDraft  = "draft"
+	InvoiceIssued = "issued"
+	InvoicePaid   = "paid"
+	InvoiceVoid   = "void"
+)
+
+// InvoiceStatuses lists every status, in order
+var InvoiceStatuses = []string{InvoiceDraft, InvoiceIssued, InvoicePaid, InvoiceVoid}
+
+// Where an invoice can go from each status. Paid and void are the end.
+var invoiceTransitions = map[string][]string{
+	InvoiceDraft:  {InvoiceIssued, InvoiceVoid},
+	InvoiceIssued: {InvoicePaid, InvoiceVoid},
+}
+
+// Kinds of InvoiceLine
+const (
+	// The level's cost, every invoice starts with one
+	LineLevel = "level"
+	LineAddOn = "addOn"
+)
+
+// Invoice bills a sponsor for its level and any add-ons. Amounts are in the
+// currency's minor unit, cents for USD. Who it's for is copied from the
+// sponsor when it's created, so renaming the sponsor doesn't change it.
+type Invoice struct {
+	Model
+	Versioned
+	EventID   int      `gorm:"not null;uniqueIndex:idx_invoices_event_number"`
+	SponsorID int      `gorm:"not null;index"`
+	Sponsor   *Sponsor `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Counts up from 1 per event, nil while it's a draft
+	Number    *int   `gorm:"uniqueIndex:idx_invoices_event_number"`
+	Status    string `gorm:"not null;index"`
+	BillTo    string `gorm:"not null"`
+	LevelName string `gorm:"not null"`
+	Currency  string `gorm:"not null"`
+	// In hundredths of a percent, 825 is 8.25%
+	TaxRate  int `gorm:"not null"`
+	Subtotal int64
+	Tax      int64
+	Total    int64
+	Notes    string
+	// Set when it's issued if it wasn't before
+	DueDate  *time.Time
+	IssuedAt *time.Time
+	PaidAt   *time.Time
+	VoidedAt *time.Time
+	Lines    []InvoiceLine `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// InvoiceLine is one thing an invoice bills for
+type InvoiceLine struct {
+	ID          int    `gorm:"primaryKey"`
+	InvoiceID   int    `gorm:"not null;index"`
+	Kind        string `gorm:"not null"`
+	Description string `gorm:"not null"`
+	Quantity    int    `gorm:"not null"`
+	UnitAmount  int64  `gorm:"not null"`
+}
+
+// Amount is what the line adds to the subtotal
+func (l InvoiceLine) Amount() int64 {
+	return int64(l.Quantity) * l.UnitAmount
+}
+
+// Reference is how the invoice is known to the sponsor, like INV-3-0012.
+// Drafts don't have one.
+func (i Invoice) Reference() string {
+	if i.Number == nil {
+		return ""
+	}
+	return fmt.Sprintf("INV-%d-%04d", i.EventID, *i.Number)
+}
+
+var (
+	// ErrLevelNotPriced is returned when a level's cost isn't an amount we can bill
+	ErrLevelNotPriced = errors.New("the level's cost is not an amount")
+	// ErrInvoiceTransition is returned when an invoice can't go to a status from the one it has
+	ErrInvoiceTransition = errors.New("the invoice can't go to that status from its current one")
+	// ErrInvoiceTooLarge is returned when an invoice's amounts add up to more than an int64 holds
+	ErrInvoiceTooLarge = errors.New("the invoice's total is too big")
+)
+
+// MaxAmount is the most one amount on an invoice can be, in the minor unit.
+// Lines and totals are still checked for overflow when they're added up.
+const MaxAmount int64 = 1e15
+
+// Digits in the minor unit of currencies that don't have 2 like USD, from ISO 4217
+var minorUnitDigits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// MinorUnitDigits is how many digits after the decimal point the currency's
+// minor unit stands for, 2 for USD's cents and 0 for JPY, which has none
+func MinorUnitDigits(currency string) int {
+	if digits, ok := minorUnitDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// Currency symbols a cost can start with, and the currency each stands for
+var currencySymbols = map[string]string{
+	"$": "USD",
+	"€": "EUR",
+	"£": "GBP",
+	"¥": "JPY",
+}
+
+// ParseCost reads a level's cost, like 14500, $250K or €1.5M, into the minor
+// unit of currency. A cost with the symbol of another currency, or that's
+// too big to bill, gets ErrLevelNotPriced.
+func ParseCost(cost string, currency string) (int64, error) {
+	s := strings.TrimSpace(cost)
+	for symbol, c := range currencySymbols {
+		if strings.HasPrefix(s, symbol) {
+			if c != currency {
+				return 0, ErrLevelNotPriced
+			}
+			s = strings.TrimSpace(strings.TrimPrefix(s, symbol))
+			break
+		}
+	}
+	s = strings.ReplaceAll(s, ",", "")
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K") || strings.HasSuffix(s, "k"):
+		multiplier = 1000
+	case strings.HasSuffix(s, "M") || strings.HasSuffix(s, "m"):
+		multiplier = 1000000
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil || amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, ErrLevelNotPriced
+	}
+	// Rounded to the minor unit, so 0.1 + 0.2 style errors never show up
+	minor := math.Round(amount * multiplier * math.Pow10(MinorUnitDigits(currency)))
+	if minor > float64(MaxAmount) {
+		return 0, ErrLevelNotPriced
+	}
+	return int64(minor), nil
+}
+
+// Works out the invoice's subtotal, tax and total from its lines.
+// Tax is rounded half up to the minor unit. Amounts that don't fit in an
+// int64 get ErrInvoiceTooLarge rather than wrapping around.
+func (i *Invoice) total() error {
+	var subtotal int64
+	for _, l := range i.Lines {
+		amount, ok := multiplyAmounts(int64(l.Quantity), l.UnitAmount)
+		if !ok {
+			return ErrInvoiceTooLarge
+		}
+		if subtotal, ok = addAmounts(subtotal, amount); !ok {
+			return ErrInvoiceTooLarge
+		}
+	}
+	taxed, ok := multiplyAmounts(subtotal, int64(i.TaxRate))
+	if !ok {
+		return ErrInvoiceTooLarge
+	}
+	tax := taxed/10000 + (taxed%10000+5000)/10000
+	total, ok := addAmounts(subtotal, tax)
+	if !ok {
+		return ErrInvoiceTooLarge
+	}
+	i.Subtotal, i.Tax, i.Total = subtotal, tax, total
+	return nil
+}
+
+// ok is false when a + b overflows
+func addAmounts(a int64, b int64) (int64, bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// ok is false when a * b overflows
+func multiplyAmounts(a int64, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+// CreateInvoice drafts an invoice for a sponsor's level, with addOns after
+// it. Cancelled sponsors and sponsors without a level can't be invoiced.
+func CreateInvoice(ctx context.Context, sponsorId int, currency string, taxRate int, dueDate *time.Time, notes string, addOns []InvoiceLine) (*Invoice, error) {
+	var invoice Invoice
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sponsor Sponsor
+		if err := tx.First(&sponsor, sponsorId).Error; err != nil {
+			return err
+		}
+		if sponsor.Status == SponsorCancelled {
+			return ErrSponsorCancelled
+		}
+		if sponsor.LevelID == nil {
+			return ErrNoLevel
+		}
+		var level Level
+		if err := tx.First(&level, *sponsor.LevelID).Error; err != nil {
+			return err
+		}
+		cost, err := ParseCost(level.Cost, currency)
+		if err != nil {
+			return err
+		}
+
+		invoice = Invoice{
+			EventID:   sponsor.EventID,
+			SponsorID: sponsor.ID,
+			Status:    InvoiceDraft,
+			BillTo:    sponsor.Name,
+			LevelName: level.Name,
+			Currency:  currency,
+			TaxRate:   taxRate,
+			Notes:     notes,
+			DueDate:   dueDate,
+		}
+		invoice.Lines = append(invoice.Lines, InvoiceLine{
+			Kind:        LineLevel,
+			Description: level.Name + " sponsorship",
+			Quantity:    1,
+			UnitAmount:  cost,
+		})
+		for _, a := range addOns {
+			a.Kind = LineAddOn
+			invoice.Lines = append(invoice.Lines, a)
+		}
+		if err := invoice.total(); err != nil {
+			return err
+		}
+		return tx.Create(&invoice).Error
+	})
+	return &invoice, err
+}
+
+// GetInvoice finds an invoice with its lines, in order
+func GetInvoice(ctx context.Context, id int) (*Invoice, error) {
+	conn := Database.WithContext(ctx)
+	var invoice Invoice
+	err := conn.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&invoice, id).Error
+	return &invoice, err
+}
+
+// GetInvoiceByReference finds an issued invoice by what's printed on it,
+// like INV-3-0042
+func GetInvoiceByReference(ctx context.Context, reference string) (*Invoice, error) {
+	var eventId, number int
+	var rest string
+	if n, _ := fmt.Sscanf(reference, "INV-%d-%d%s", &eventId, &number, &rest); n != 2 {
+		return nil, fmt.Errorf("%q is not an invoice reference: %w", reference, gorm.ErrRecordNotFound)
+	}
+	conn := Database.WithContext(ctx)
+	var invoice Invoice
+	err := conn.Where("event_id = ? AND number = ?", eventId, number).First(&invoice).Error
+	return &invoice, err
+}
+
+// GetInvoices returns an event's invoices with their lines, oldest first.
+// sponsorId and status narrow them down when they're not 0 and "".
+func GetInvoices(ctx context.Context, eventId int, sponsorId int, status string) ([]Invoice, error) {
+	conn := Database.WithContext(ctx)
+	query := conn.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Where("event_id = ?", eventId)
+	if sponsorId != 0 {
+		query = query.Where("sponsor_id = ?", sponsorId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var invoices []Invoice
+	err := query.Order("id").Find(&invoices).Error
+	return invoices, err
+}
+
+// CanChangeInvoiceStatus tells whether an invoice can go from one status to another
+func CanChangeInvoiceStatus(from string, to string) bool {
+	for _, s := range invoiceTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextInvoiceStatuses lists where an invoice can go from status
+func NextInvoiceStatuses(status string) []string {
+	return invoiceTransitions[status]
+}
+
+// Gets an event, locked so two invoices can't be given the same number
+func lockEvent(tx *gorm.DB, eventId int) (*Event, error) {
+	event := Event{}
+	err := forUpdate(tx).First(&event, eventId).Error
+	return &event, err
+}
+
+// ChangeInvoiceStatus moves an invoice to another status. Issuing gives it
+// the event's next number, and a due date paymentTerms away when it has
+// none. The sponsor follows along: a contracted sponsor becomes invoiced
+// when it's issued, and an invoiced one becomes paid once none of its
+// invoices are left to pay. The sponsor's status change is nil when it
+// stayed where it was. When version isn't 0, the invoice is only changed
+// while it's still at that version, ErrVersionMismatch otherwise.
+func ChangeInvoiceStatus(ctx context.Context, id int, to string, changedBy string, paymentTerms time.Duration, version int) (*Invoice, *Sponsor, *SponsorStatusChange, error) {
+	var invoice Invoice
+	var sponsor Sponsor
+	var change *SponsorStatusChange
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&invoice, id).Error; err != nil {
+			return err
+		}
+		var err error
+		change, err = setInvoiceStatus(tx, &invoice, &sponsor, to, changedBy, paymentTerms, version)
+		return err
+	})
+	return &invoice, &sponsor, change, translateError(err)
+}
+
+// Moves an invoice to another status, and the sponsor along with it, as
+// ChangeInvoiceStatus says. invoice and sponsor are loaded again afterwards.
+func setInvoiceStatus(tx *gorm.DB, invoice *Invoice, sponsor *Sponsor, to string, changedBy string, paymentTerms time.Duration, version int) (*SponsorStatusChange, error) {
+	if !CanChangeInvoiceStatus(invoice.Status, to) {
+		return nil, ErrInvoiceTransition
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":  to,
+		"version": gorm.Expr("version + 1"),
+	}
+	switch to {
+	case InvoiceIssued:
+		if _, err := lockEvent(tx, invoice.EventID); err != nil {
+			return nil, err
+		}
+		var last int
+		err := tx.Model(&Invoice{}).Unscoped().Where("event_id = ?", invoice.EventID).
+			Select("COALESCE(MAX(number), 0)").Scan(&last).Error
+		if err != nil {
+			return nil, err
+		}
+		updates["number"] = last + 1
+		updates["issued_at"] = now
+		if invoice.DueDate == nil {
+			updates["due_date"] = now.Add(paymentTerms)
+		}
+	case InvoicePaid:
+		updates["paid_at"] = now
+	case InvoiceVoid:
+		updates["voided_at"] = now
+	}
+
+	query := tx.Model(&Invoice{Model: Model{ID: invoice.ID}})
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrVersionMismatch
+	}
+	if err := tx.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(invoice, invoice.ID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.First(sponsor, invoice.SponsorID).Error; err != nil {
+		return nil, err
+	}
+	reason := fmt.Sprintf("invoice %s %s", invoice.Reference(), to)
+	if to == InvoiceIssued && sponsor.Status == SponsorContracted {
+		return setSponsorStatus(tx, sponsor, SponsorInvoiced, reason, changedBy, 0)
+	}
+	if to == InvoicePaid && sponsor.Status == SponsorInvoiced {
+		var unpaid int64
+		err := tx.Model(&Invoice{}).Where("sponsor_id = ? AND status = ?", sponsor.ID, InvoiceIssued).Count(&unpaid).Error
+		if err != nil || unpaid > 0 {
+			return nil, err
+		}
+		return setSponsorStatus(tx, sponsor, SponsorPaid, reason, changedBy, 0)
+	}
+	return nil, nil
+}
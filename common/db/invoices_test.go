@@ -0,0 +1,75 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCost(t *testing.T) {
+	tests := []struct {
+		cost     string
+		currency string
+		want     int64
+		ok       bool
+	}{
+		{"14500", "USD", 1450000, true},
+		{"$250K", "USD", 25000000, true},
+		{" $ 1,250.50 ", "USD", 125050, true},
+		{"1.5m", "USD", 150000000, true},
+		{"€1.5M", "EUR", 150000000, true},
+		{"0.1", "USD", 10, true},
+		{"¥1.5M", "JPY", 1500000, true},
+		{"1,000.4", "JPY", 1000, true},
+		{"1.5", "KWD", 1500, true},
+		{"€1.5M", "USD", 0, false},
+		{"£100", "USD", 0, false},
+		{"$100", "EUR", 0, false},
+		{"", "USD", 0, false},
+		{"TBD", "USD", 0, false},
+		{"-5", "USD", 0, false},
+		{"NaN", "USD", 0, false},
+		{"Inf", "USD", 0, false},
+		{"-Inf", "USD", 0, false},
+		{"1e30", "USD", 0, false},
+		{"100000000000000000M", "USD", 0, false},
+		{"10000000000001", "USD", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseCost(tt.cost, tt.currency)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("ParseCost(%q, %s) got %d, %v, want %d", tt.cost, tt.currency, got, err, tt.want)
+		}
+		if !tt.ok && err != ErrLevelNotPriced {
+			t.Errorf("ParseCost(%q, %s) got %d, %v, want ErrLevelNotPriced", tt.cost, tt.currency, got, err)
+		}
+	}
+}
+
+func TestInvoiceTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []InvoiceLine
+		taxRate int
+		want    [3]int64
+		err     error
+	}{
+		{"tax rounds half up", []InvoiceLine{{Quantity: 1, UnitAmount: 1000}, {Quantity: 3, UnitAmount: 50}}, 825, [3]int64{1150, 95, 1245}, nil},
+		{"no lines", nil, 825, [3]int64{0, 0, 0}, nil},
+		{"line overflows", []InvoiceLine{{Quantity: 10000, UnitAmount: math.MaxInt64 / 1000}}, 0, [3]int64{}, ErrInvoiceTooLarge},
+		{"subtotal overflows", []InvoiceLine{{Quantity: 1, UnitAmount: math.MaxInt64}, {Quantity: 1, UnitAmount: 1}}, 0, [3]int64{}, ErrInvoiceTooLarge},
+		{"tax overflows", []InvoiceLine{{Quantity: 10000, UnitAmount: MaxAmount}}, 10000, [3]int64{}, ErrInvoiceTooLarge},
+		{"total overflows", []InvoiceLine{{Quantity: 1, UnitAmount: math.MaxInt64 - 10}}, 1, [3]int64{}, ErrInvoiceTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := Invoice{Lines: tt.lines, TaxRate: tt.taxRate}
+			err := invoice.total()
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if got := [3]int64{invoice.Subtotal, invoice.Tax, invoice.Total}; err == nil && got != tt.want {
+				t.Errorf("got subtotal, tax and total %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
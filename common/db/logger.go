@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"time"
+)
+
+// Queries slower than this are logged as warnings
+const slowQueryThreshold = 200 * time.Millisecond
+
+// Sends gorm's logs through the logging package, so queries show up as
+// JSON lines with the correlation ID of the request (when the query was
+// made with Database.WithContext)
+type queryLogger struct {
+	level gormlogger.LogLevel
+}
+
+func (l queryLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	return queryLogger{level: level}
+}
+
+func (l queryLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		logging.FromContext(ctx).Info(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		logging.FromContext(ctx).Warn(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		logging.FromContext(ctx).Error(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	fields := logging.Fields{
+		"sql":        sql,
+		"rows":       rows,
+		"durationMs": float64(elapsed.Microseconds()) / 1000,
+	}
+	logger := logging.FromContext(ctx)
+
+	switch {
+	// Not finding a row is an answer, not a problem
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		fields["error"] = err
+		logger.Error("query failed", fields)
+	case elapsed > slowQueryThreshold:
+		logger.Warn("slow query", fields)
+	default:
+		logger.Debug("query", fields)
+	}
+}
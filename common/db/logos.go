@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Logo is the image a sponsor uploaded as its logo, and the thumbnail made
+// from it. Both are kept in the blob store, it's the zero value until the
+// sponsor has one.
+type Logo struct {
+	// Blob store key of the image as it was uploaded
+	Key          string `gorm:"not null;default:''"`
+	ThumbnailKey string `gorm:"not null;default:''"`
+	ContentType  string `gorm:"not null;default:''"`
+	Width        int    `gorm:"not null;default:0"`
+	Height       int    `gorm:"not null;default:0"`
+	// In bytes
+	Size       int64 `gorm:"not null;default:0"`
+	UploadedAt *time.Time
+}
+
+// SetSponsorLogo gives a sponsor a new logo, or takes its logo away when
+// logo is the zero value. When version isn't 0, the sponsor is only changed
+// while it's still at that version, ErrVersionMismatch otherwise. Returns
+// the sponsor as it was before too, so its old logo can be deleted.
+func SetSponsorLogo(ctx context.Context, id int, logo Logo, version int) (*Sponsor, *Sponsor, error) {
+	var sponsor, previous Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&previous, id).Error; err != nil {
+			return err
+		}
+		query := tx.Model(&Sponsor{Model: Model{ID: id}})
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(map[string]interface{}{
+			"logo_key":           logo.Key,
+			"logo_thumbnail_key": logo.ThumbnailKey,
+			"logo_content_type":  logo.ContentType,
+			"logo_width":         logo.Width,
+			"logo_height":        logo.Height,
+			"logo_size":          logo.Size,
+			"logo_uploaded_at":   logo.UploadedAt,
+			"version":            gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
+		return tx.First(&sponsor, id).Error
+	})
+	return &sponsor, &previous, err
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SchemaMigration keeps track of the data migrations that already ran,
+// so each one only runs once per database
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+type dataMigration struct {
+	id  string
+	run func(tx *gorm.DB) error
+}
+
+// Data migrations run in order, each inside its own transaction,
+// before AutoMigrate adds any new constraints to the tables
+var dataMigrations = []dataMigration{
+	{"0001_repair_rows_for_constraints", repairRowsForConstraints},
+	{"0002_sponsors_without_a_level_are_prospects", backfillProspects},
+}
+
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	for _, m := range dataMigrations {
+		var count int64
+		if err := db.Model(&SchemaMigration{}).Where("id = ?", m.id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.id}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("data migration %s failed | %w", m.id, err)
+		}
+		logging.Info("applied data migration", logging.Fields{"migration": m.id})
+	}
+
+	return db.AutoMigrate(&Event{}, &Level{}, &Sponsor{}, &Member{}, &APIKey{}, &Invite{}, &AuditEntry{}, &IdempotencyRecord{}, &WaitlistEntry{}, &Reservation{}, &SponsorStatusChange{}, &Invoice{}, &InvoiceLine{}, &Payment{}, &WebhookEvent{}, &Benefit{}, &Deliverable{}, &Badge{})
+}
+
+// Older versions of the service never set Member.EventID, stored a level ID
+// of 0 for sponsors without a level and had no unique or foreign key
+// constraints. This fixes up those rows so the constraints can be created.
+func repairRowsForConstraints(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasTable(&Event{}) || !m.HasTable(&Level{}) || !m.HasTable(&Sponsor{}) || !m.HasTable(&Member{}) {
+		// Fresh database, nothing to repair
+		return nil
+	}
+
+	// Rows pointing at parents that don't exist can't keep a foreign key,
+	// these have to be removed for real (not soft deleted)
+	statements := []string{
+		"DELETE FROM levels WHERE event_id NOT IN (SELECT id FROM events)",
+		"DELETE FROM sponsors WHERE event_id NOT IN (SELECT id FROM events)",
+		"DELETE FROM members WHERE sponsor_id NOT IN (SELECT id FROM sponsors)",
+		"UPDATE members SET event_id = (SELECT sponsors.event_id FROM sponsors WHERE sponsors.id = members.sponsor_id)",
+		"UPDATE sponsors SET level_id = NULL WHERE level_id = 0 OR level_id NOT IN (SELECT id FROM levels)",
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := mergeDuplicateLevels(tx); err != nil {
+		return err
+	}
+	if err := mergeDuplicateSponsors(tx); err != nil {
+		return err
+	}
+	return removeDuplicateMembers(tx)
+}
+
+type duplicate struct {
+	ParentID int
+	Name     string
+	KeepID   int
+}
+
+// Finds the (parent, name) pairs that appear more than once. The row
+// with the lowest ID is the one that gets kept.
+func findDuplicates(tx *gorm.DB, model interface{}, parentColumn string, nameColumn string) ([]duplicate, error) {
+	var dups []duplicate
+	err := tx.Model(model).
+		Select(fmt.Sprintf("%s AS parent_id, %s AS name, MIN(id) AS keep_id", parentColumn, nameColumn)).
+		Group(fmt.Sprintf("%s, %s", parentColumn, nameColumn)).
+		Having("COUNT(*) > 1").
+		Scan(&dups).Error
+	return dups, err
+}
+
+func duplicateIDs(tx *gorm.DB, model interface{}, parentColumn string, nameColumn string, d duplicate) ([]int, error) {
+	var ids []int
+	err := tx.Model(model).
+		Where(fmt.Sprintf("%s = ? AND %s = ? AND id <> ?", parentColumn, nameColumn), d.ParentID, d.Name, d.KeepID).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// Sponsors on a duplicate level are moved to the level that is kept
+func mergeDuplicateLevels(tx *gorm.DB) error {
+	dups, err := findDuplicates(tx, &Level{}, "event_id", "name")
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dups {
+		ids, err := duplicateIDs(tx, &Level{}, "event_id", "name", d)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&Sponsor{}).Where("level_id IN ?", ids).Update("level_id", d.KeepID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&Level{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Members of a duplicate sponsor are moved to the sponsor that is kept
+func mergeDuplicateSponsors(tx *gorm.DB) error {
+	dups, err := findDuplicates(tx, &Sponsor{}, "event_id", "name")
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dups {
+		ids, err := duplicateIDs(tx, &Sponsor{}, "event_id", "name", d)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&Member{}).Where("sponsor_id IN ?", ids).Update("sponsor_id", d.KeepID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&Sponsor{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeDuplicateMembers(tx *gorm.DB) error {
+	dups, err := findDuplicates(tx, &Member{}, "sponsor_id", "email")
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dups {
+		ids, err := duplicateIDs(tx, &Member{}, "sponsor_id", "email", d)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&Member{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sponsors from before there were statuses get the column's default of
+// contracted, but a contracted sponsor needs a level. Those without one are
+// prospects. The column is added here so it can be set before AutoMigrate.
+func backfillProspects(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasTable(&Sponsor{}) {
+		return nil
+	}
+	if !m.HasColumn(&Sponsor{}, "Status") {
+		if err := m.AddColumn(&Sponsor{}, "Status"); err != nil {
+			return err
+		}
+	}
+	return tx.Exec("UPDATE sponsors SET status = ? WHERE status = ? AND level_id IS NULL", SponsorProspect, SponsorContracted).Error
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// Tables as the first version of the service made them, with no
+// constraints and an int level ID
+type legacyEvent struct {
+	gorm.Model
+	EventServiceID int
+	Name           string
+}
+
+type legacyLevel struct {
+	gorm.Model
+	EventID               int
+	Name                  string
+	Cost                  string
+	MaxNumberOfSponsors   int
+	MaxNumberOfFreeBadges int
+}
+
+type legacySponsor struct {
+	gorm.Model
+	EventID   int
+	Name      string
+	LevelID   int
+	LevelName string
+}
+
+type legacyMember struct {
+	gorm.Model
+	Name      string
+	Email     string
+	SponsorID int
+	EventID   int
+}
+
+func (legacyEvent) TableName() string   { return "events" }
+func (legacyLevel) TableName() string   { return "levels" }
+func (legacySponsor) TableName() string { return "sponsors" }
+func (legacyMember) TableName() string  { return "members" }
+
+// Rows that break the constraints we have now
+var unrepairedRows = []string{
+	`INSERT INTO events (id, name) VALUES (1, 'Conf')`,
+	// Gold twice, and a level of an event that's gone
+	`INSERT INTO levels (id, event_id, name) VALUES (1, 1, 'Gold'), (2, 1, 'Gold'), (3, 99, 'Orphan')`,
+	// Doge Corp twice, one on each Gold, one on level 0, and one of an event that's gone
+	`INSERT INTO sponsors (id, event_id, name, level_id) VALUES (1, 1, 'Doge Corp', 1), (2, 1, 'Doge Corp', 2),
+		(3, 1, 'Lolcat Org', 0), (4, 99, 'Orphan', 3)`,
+	// first@doge.com ends up on sponsor 1 twice once the sponsors are merged,
+	// and member 5's sponsor is gone. None have an event.
+	`INSERT INTO members (id, name, email, sponsor_id, event_id) VALUES (1, 'First', 'first@doge.com', 1, 0),
+		(2, 'First Again', 'first@doge.com', 2, 0), (3, 'Second', 'second@doge.com', 2, 0),
+		(4, 'Cat', 'cat@lolcat.org', 3, 0), (5, 'Orphan', 'orphan@example.com', 42, 0)`,
+}
+
+func TestMigrateRepairsRowsBeforeAddingConstraints(t *testing.T) {
+	dialector, err := openDialector(Creds{Driver: DriverSqlite, SqlitePath: SqliteInMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sqlite can't add a foreign key to a table that already exists, so only
+	// the unique indexes are checked here
+	conn, err := gorm.Open(dialector, &gorm.Config{Logger: queryLogger{}, DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+	if err := conn.AutoMigrate(&legacyEvent{}, &legacyLevel{}, &legacySponsor{}, &legacyMember{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range unrepairedRows {
+		if err := conn.Exec(statement).Error; err != nil {
+			t.Fatalf("%s | %v", statement, err)
+		}
+	}
+
+	if err := migrate(conn); err != nil {
+		t.Fatalf("migrate got %v, want the rows repaired so the constraints can be added", err)
+	}
+
+	var levels []Level
+	conn.Order("id").Find(&levels)
+	if len(levels) != 1 || levels[0].ID != 1 {
+		t.Errorf("levels got %+v, want only the first Gold", levels)
+	}
+	var sponsors []Sponsor
+	conn.Order("id").Find(&sponsors)
+	if len(sponsors) != 2 || sponsors[0].ID != 1 || sponsors[1].ID != 3 {
+		t.Fatalf("sponsors got %+v, want the first Doge Corp and Lolcat Org", sponsors)
+	}
+	if sponsors[0].LevelID == nil || *sponsors[0].LevelID != 1 {
+		t.Errorf("Doge Corp got level %v, want the Gold that was kept", sponsors[0].LevelID)
+	}
+	if sponsors[1].LevelID != nil {
+		t.Errorf("Lolcat Org got level %v, want no level instead of 0", *sponsors[1].LevelID)
+	}
+	if sponsors[0].Status != SponsorContracted || sponsors[1].Status != SponsorProspect {
+		t.Errorf("got statuses %s and %s, want Doge Corp contracted and Lolcat Org, with no level, a prospect",
+			sponsors[0].Status, sponsors[1].Status)
+	}
+	var members []Member
+	conn.Order("id").Find(&members)
+	if len(members) != 3 {
+		t.Fatalf("members got %+v, want First, Second and Cat", members)
+	}
+	for _, m := range members {
+		if m.EventID != 1 {
+			t.Errorf("member %d got event %d, want its sponsor's", m.ID, m.EventID)
+		}
+	}
+	if members[0].ID != 1 || members[1].ID != 3 || members[1].SponsorID != 1 || members[2].ID != 4 {
+		t.Errorf("members got %+v, want Second moved to the Doge Corp that was kept and First Again removed", members)
+	}
+
+	// The unique indexes are there now
+	for _, index := range []struct {
+		model interface{}
+		name  string
+	}{{&Level{}, "idx_levels_event_name"}, {&Sponsor{}, "idx_sponsors_event_name"}, {&Member{}, "idx_members_sponsor_email"}} {
+		if !conn.Migrator().HasIndex(index.model, index.name) {
+			t.Errorf("got no %s index", index.name)
+		}
+	}
+	if err := conn.Create(&Level{EventID: 1, Name: "Gold"}).Error; translateError(err) != ErrDuplicate {
+		t.Errorf("adding another Gold got %v, want ErrDuplicate", err)
+	}
+
+	// It only runs once
+	var applied int64
+	conn.Model(&SchemaMigration{}).Count(&applied)
+	if applied != int64(len(dataMigrations)) {
+		t.Errorf("got %d data migrations applied, want %d", applied, len(dataMigrations))
+	}
+	if err := migrate(conn); err != nil {
+		t.Errorf("migrating again got %v", err)
+	}
+}
+
+func TestUpdateLevelOfAnotherEvent(t *testing.T) {
+	ctx := useTestDB(t)
+	conf := CreateEvent(ctx, "Conf", 1)
+	other := CreateEvent(ctx, "Other", 2)
+	level, err := CreateLevel(ctx, "Gold", "1000", 2, 2, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UpdateLevel(ctx, level.ID, "Stolen", "1", 1, 1, conf.ID, 0); err != gorm.ErrRecordNotFound {
+		t.Errorf("got %v, want gorm.ErrRecordNotFound", err)
+	}
+	saved, err := GetLevel(ctx, level.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.EventID != other.ID || saved.Name != "Gold" {
+		t.Errorf("got %+v, want the level left on its own event", saved)
+	}
+
+	updated, err := UpdateLevel(ctx, level.ID, "Platinum", "2000", 3, 4, other.ID, 1)
+	if err != nil || updated.Name != "Platinum" || updated.Version != 2 {
+		t.Errorf("got %+v, %v, want it renamed to Platinum at version 2", updated, err)
+	}
+	if _, err := UpdateLevel(ctx, level.ID, "Stale", "1", 1, 1, other.ID, 1); err != ErrVersionMismatch {
+		t.Errorf("updating version 1 again got %v, want ErrVersionMismatch", err)
+	}
+}
+
+func TestUpdateEventWithLevelsSavesAllOrNothing(t *testing.T) {
+	ctx := useTestDB(t)
+	conf := CreateEvent(ctx, "Conf", 1)
+	gold, err := CreateLevel(ctx, "Gold", "1000", 2, 2, conf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := []LevelChange{
+		{Name: "Bronze", Cost: "10"},
+		{ID: gold.ID, Version: gold.Version + 1, Name: "Gold", Cost: "2000"},
+	}
+	_, err = UpdateEventWithLevels(ctx, conf.ID, "Renamed", stale, 0)
+	var levelErr *LevelChangeError
+	if !errors.As(err, &levelErr) || levelErr.Index != 1 || !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("got %v, want ErrVersionMismatch for the second level", err)
+	}
+	event, err := GetEvent(ctx, conf.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != "Conf" || len(event.Levels) != 1 || event.Levels[0].Cost != "1000" {
+		t.Errorf("got %+v, want the event and its levels as they were", event)
+	}
+
+	current := []LevelChange{
+		{Name: "Bronze", Cost: "10"},
+		{ID: gold.ID, Version: gold.Version, Name: "Gold", Cost: "2000"},
+	}
+	saved, err := UpdateEventWithLevels(ctx, conf.ID, "Renamed", current, event.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "Renamed" || len(saved.Levels) != 2 || saved.Levels[0].Cost != "2000" {
+		t.Errorf("got %+v, want the event renamed with Gold at 2000 and Bronze", saved)
+	}
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Kinds of Payment
+const (
+	// Money the sponsor paid us
+	PaymentReceived = "payment"
+	// Money we paid back to the sponsor
+	PaymentRefund = "refund"
+	// Knocks an amount off what the sponsor owes, without any money moving
+	PaymentCreditNote = "creditNote"
+)
+
+// PaymentKinds lists every kind of ledger entry
+var PaymentKinds = []string{PaymentReceived, PaymentRefund, PaymentCreditNote}
+
+// Ways money can move
+var PaymentMethods = []string{"bankTransfer", "card", "check", "cash", "other"}
+
+// Payment is an entry in a sponsor's ledger. Amounts are always positive,
+// in the same minor unit as invoices, and Kind says which way they go.
+// Entries are never updated or deleted, a mistake is undone with a refund
+// or a credit note.
+type Payment struct {
+	ID        int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	EventID   int `gorm:"not null;index"`
+	SponsorID int `gorm:"not null;index"`
+	// The invoice it settles, if any
+	InvoiceID *int   `gorm:"index"`
+	Kind      string `gorm:"not null"`
+	Amount    int64  `gorm:"not null"`
+	Currency  string `gorm:"not null"`
+	// One of PaymentMethods, empty for credit notes
+	Method string
+	// The bank's, card processor's or our own reference for it
+	Reference string
+	Note      string
+	// API key or token subject that recorded it
+	RecordedBy string    `gorm:"not null"`
+	ReceivedAt time.Time `gorm:"not null"`
+}
+
+// Balance is what a sponsor, or a whole event, was billed and has paid.
+// Only issued and paid invoices count, drafts and void ones aren't owed.
+type Balance struct {
+	Invoiced int64
+	// Payments less refunds
+	Paid     int64
+	Credited int64
+}
+
+// Outstanding is what's left to pay, less than 0 when it was overpaid
+func (b Balance) Outstanding() int64 {
+	return b.Invoiced - b.Paid - b.Credited
+}
+
+func (b *Balance) add(other Balance) {
+	b.Invoiced += other.Invoiced
+	b.Paid += other.Paid
+	b.Credited += other.Credited
+}
+
+var (
+	// ErrInvoiceNotPayable is returned when paying against a draft or void
+	// invoice, or one of another sponsor
+	ErrInvoiceNotPayable = errors.New("the invoice can't be paid")
+	// ErrRefundTooLarge is returned when refunding more than the sponsor paid
+	ErrRefundTooLarge = errors.New("the refund is more than the sponsor paid")
+)
+
+// Adds up the ledgers of the sponsors the query is narrowed to, by sponsor
+func balances(tx *gorm.DB, column string, value int) (map[int]*Balance, error) {
+	out := map[int]*Balance{}
+	get := func(sponsorId int) *Balance {
+		if out[sponsorId] == nil {
+			out[sponsorId] = &Balance{}
+		}
+		return out[sponsorId]
+	}
+
+	var invoiced []struct {
+		SponsorID int
+		Total     int64
+	}
+	err := tx.Model(&Invoice{}).Select("sponsor_id, SUM(total) AS total").
+		Where(column+" = ? AND status IN ?", value, []string{InvoiceIssued, InvoicePaid}).
+		Group("sponsor_id").Scan(&invoiced).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range invoiced {
+		get(i.SponsorID).Invoiced = i.Total
+	}
+
+	var payments []struct {
+		SponsorID int
+		Kind      string
+		Amount    int64
+	}
+	err = tx.Model(&Payment{}).Select("sponsor_id, kind, SUM(amount) AS amount").
+		Where(column+" = ?", value).Group("sponsor_id, kind").Scan(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		b := get(p.SponsorID)
+		switch p.Kind {
+		case PaymentReceived:
+			b.Paid += p.Amount
+		case PaymentRefund:
+			b.Paid -= p.Amount
+		case PaymentCreditNote:
+			b.Credited += p.Amount
+		}
+	}
+	return out, nil
+}
+
+// GetBalance adds up a sponsor's ledger
+func GetBalance(ctx context.Context, sponsorId int) (Balance, error) {
+	all, err := balances(Database.WithContext(ctx), "sponsor_id", sponsorId)
+	if err != nil || all[sponsorId] == nil {
+		return Balance{}, err
+	}
+	return *all[sponsorId], nil
+}
+
+// GetEventBalances adds up the ledger of every sponsor of an event that
+// has one, and the event's total
+func GetEventBalances(ctx context.Context, eventId int) (map[int]Balance, Balance, error) {
+	var total Balance
+	all, err := balances(Database.WithContext(ctx), "event_id", eventId)
+	if err != nil {
+		return nil, total, err
+	}
+	out := map[int]Balance{}
+	for id, b := range all {
+		out[id] = *b
+		total.add(*b)
+	}
+	return out, total, nil
+}
+
+// GetPayments returns a sponsor's ledger, oldest first
+func GetPayments(ctx context.Context, sponsorId int) ([]Payment, error) {
+	conn := Database.WithContext(ctx)
+	var payments []Payment
+	err := conn.Where("sponsor_id = ?", sponsorId).Order("received_at, id").Find(&payments).Error
+	return payments, err
+}
+
+// GetPaymentByReference finds the payment the provider knows by reference,
+// the last one when it was recorded more than once
+func GetPaymentByReference(ctx context.Context, reference string) (*Payment, error) {
+	conn := Database.WithContext(ctx)
+	var payment Payment
+	err := conn.Where("kind = ? AND reference = ?", PaymentReceived, reference).Order("id DESC").First(&payment).Error
+	return &payment, err
+}
+
+// RecordPayment adds an entry to a sponsor's ledger. One against an issued
+// invoice that leaves nothing to pay on it marks the invoice paid, and the
+// sponsor follows it as ChangeInvoiceStatus says. The invoice is nil when
+// the payment isn't against one, and the status change nil when the sponsor
+// stayed where it was.
+func RecordPayment(ctx context.Context, payment Payment) (*Payment, *Invoice, *Sponsor, *SponsorStatusChange, error) {
+	var invoice *Invoice
+	var sponsor Sponsor
+	var change *SponsorStatusChange
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&sponsor, payment.SponsorID).Error; err != nil {
+			return err
+		}
+		payment.EventID = sponsor.EventID
+
+		if payment.InvoiceID != nil {
+			invoice = &Invoice{}
+			err := tx.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(invoice, *payment.InvoiceID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvoiceNotPayable
+			} else if err != nil {
+				return err
+			}
+			if invoice.SponsorID != sponsor.ID || (invoice.Status != InvoiceIssued && invoice.Status != InvoicePaid) {
+				return ErrInvoiceNotPayable
+			}
+		}
+		if payment.Kind == PaymentRefund {
+			balance, err := balances(tx, "sponsor_id", sponsor.ID)
+			if err != nil {
+				return err
+			}
+			if b := balance[sponsor.ID]; b == nil || payment.Amount > b.Paid {
+				return ErrRefundTooLarge
+			}
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+
+		if invoice == nil || invoice.Status != InvoiceIssued || payment.Kind == PaymentRefund {
+			return nil
+		}
+		var settled []struct {
+			Kind   string
+			Amount int64
+		}
+		err := tx.Model(&Payment{}).Select("kind, SUM(amount) AS amount").
+			Where("invoice_id = ?", invoice.ID).Group("kind").Scan(&settled).Error
+		if err != nil {
+			return err
+		}
+		left := invoice.Total
+		for _, s := range settled {
+			if s.Kind == PaymentRefund {
+				left += s.Amount
+			} else {
+				left -= s.Amount
+			}
+		}
+		if left > 0 {
+			return nil
+		}
+		change, err = setInvoiceStatus(tx, invoice, &sponsor, InvoicePaid, payment.RecordedBy, 0, 0)
+		return err
+	})
+	return &payment, invoice, &sponsor, change, err
+}
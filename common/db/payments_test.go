@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	ctx := useTestDB(t)
+	event := CreateEvent(ctx, "Conf", 1)
+	level, err := CreateLevel(ctx, "Silver", "100", 0, 0, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sponsor, err := CreateSponsorWithLevel(ctx, "Corgi Ltd", level.ID, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Database.Model(sponsor).Update("status", SponsorContracted).Error; err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := CreateInvoice(ctx, sponsor.ID, "USD", 0, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pay := func(kind string, amount int64, invoiceId *int) (*Invoice, error) {
+		_, paid, _, _, err := RecordPayment(ctx, Payment{
+			SponsorID:  sponsor.ID,
+			InvoiceID:  invoiceId,
+			Kind:       kind,
+			Amount:     amount,
+			Currency:   "USD",
+			RecordedBy: "test",
+			ReceivedAt: time.Now(),
+		})
+		return paid, err
+	}
+	if _, err := pay(PaymentReceived, 10000, &invoice.ID); err != ErrInvoiceNotPayable {
+		t.Errorf("paying a draft got %v, want ErrInvoiceNotPayable", err)
+	}
+	if _, _, _, err := ChangeInvoiceStatus(ctx, invoice.ID, InvoiceIssued, "test", time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if paid, err := pay(PaymentReceived, 4000, &invoice.ID); err != nil || paid.Status != InvoiceIssued {
+		t.Fatalf("part payment got %v, %v, want the invoice still issued", paid, err)
+	}
+	if paid, err := pay(PaymentReceived, 6000, &invoice.ID); err != nil || paid.Status != InvoicePaid {
+		t.Fatalf("paying the rest got %v, %v, want the invoice paid", paid, err)
+	}
+	if _, err := pay(PaymentRefund, 10001, nil); err != ErrRefundTooLarge {
+		t.Errorf("refunding more than was paid got %v, want ErrRefundTooLarge", err)
+	}
+	if _, err := pay(PaymentRefund, 1000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pay(PaymentCreditNote, 500, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := GetBalance(ctx, sponsor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Balance{Invoiced: 10000, Paid: 9000, Credited: 500}); balance != want || balance.Outstanding() != 500 {
+		t.Errorf("got %+v, want %+v with 500 outstanding", balance, want)
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Statuses of a Reservation. Only held ones take up a spot, the others
+// are soft deleted and kept for history.
+const (
+	ReservationHeld      = "held"
+	ReservationConverted = "converted"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds one of a level's spots for a prospect that hasn't
+// signed yet. It takes up the spot until it expires, is released, or is
+// converted into a sponsor.
+type Reservation struct {
+	Model
+	LevelID int    `gorm:"not null;index"`
+	Level   *Level `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Who the spot is held for, the sponsor's name when it's converted
+	Prospect string `gorm:"not null"`
+	Note     string
+	// API key or token subject that made the reservation
+	HeldBy    string    `gorm:"not null"`
+	Status    string    `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	// The sponsor it became, once converted
+	SponsorID *int
+}
+
+// ErrReservationExpired is returned when converting a reservation that ran out
+var ErrReservationExpired = errors.New("the reservation has expired")
+
+// CreateReservation holds a spot on the level until expiresAt, if it has one free
+func CreateReservation(ctx context.Context, levelId int, prospect string, note string, heldBy string, expiresAt time.Time) (*Reservation, error) {
+	reservation := Reservation{
+		LevelID:   levelId,
+		Prospect:  prospect,
+		Note:      note,
+		HeldBy:    heldBy,
+		Status:    ReservationHeld,
+		ExpiresAt: expiresAt,
+	}
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		level, err := lockLevel(tx, levelId)
+		if err != nil {
+			return err
+		}
+		if err := checkLevelHasRoom(tx, level); err != nil {
+			return err
+		}
+		return tx.Create(&reservation).Error
+	})
+	return &reservation, err
+}
+
+// GetReservations returns a level's held reservations, soonest to expire first
+func GetReservations(ctx context.Context, levelId int) ([]Reservation, error) {
+	conn := Database.WithContext(ctx)
+	var reservations []Reservation
+	err := conn.Where("level_id = ?", levelId).Order("expires_at, id").Find(&reservations).Error
+	return reservations, err
+}
+
+// GetReservation finds held reservations only
+func GetReservation(ctx context.Context, id int) (*Reservation, error) {
+	conn := Database.WithContext(ctx)
+	var reservation Reservation
+	err := conn.First(&reservation, id).Error
+	return &reservation, err
+}
+
+// Ends a reservation, it's kept with its final status but gives its spot back
+func closeReservation(tx *gorm.DB, reservation *Reservation, status string) error {
+	reservation.Status = status
+	err := tx.Model(reservation).Updates(map[string]interface{}{
+		"status":     status,
+		"sponsor_id": reservation.SponsorID,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Delete(reservation).Error
+}
+
+// ReleaseReservation gives a reservation's spot back before it expires
+func ReleaseReservation(ctx context.Context, id int) (*Reservation, error) {
+	conn := Database.WithContext(ctx)
+	var reservation Reservation
+	if err := conn.First(&reservation, id).Error; err != nil {
+		return &reservation, err
+	}
+	return &reservation, closeReservation(conn, &reservation, ReservationReleased)
+}
+
+// ConvertReservation turns a reservation into a contracted sponsor called
+// name on its level, taking the spot it held. It returns ErrReservationExpired
+// when the reservation ran out, even if the sweeper hasn't closed it yet.
+func ConvertReservation(ctx context.Context, id int, name string, eventId int) (*Reservation, *Sponsor, error) {
+	var reservation Reservation
+	var sponsor Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&reservation, id).Error; err != nil {
+			return err
+		}
+		level, err := lockLevel(tx, reservation.LevelID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if !now.Before(reservation.ExpiresAt) {
+			return ErrReservationExpired
+		}
+
+		// The reservation held the spot, so there's no need to check for room
+		sponsor = Sponsor{
+			Name:            name,
+			EventID:         eventId,
+			LevelID:         &level.ID,
+			LevelName:       level.Name,
+			Status:          SponsorContracted,
+			StatusChangedAt: &now,
+		}
+		if err := tx.Create(&sponsor).Error; err != nil {
+			return err
+		}
+		if err := syncDeliverables(tx, sponsor.ID); err != nil {
+			return err
+		}
+		reservation.SponsorID = &sponsor.ID
+		return closeReservation(tx, &reservation, ReservationConverted)
+	})
+	return &reservation, &sponsor, translateError(err)
+}
+
+// ExpireReservations closes reservations that ran out, and returns the
+// levels that got spots back
+func ExpireReservations(ctx context.Context, now time.Time) ([]int, error) {
+	var levelIds []int
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var expired []Reservation
+		if err := tx.Where("expires_at <= ?", now).Find(&expired).Error; err != nil {
+			return err
+		}
+		seen := map[int]bool{}
+		for i := range expired {
+			if err := closeReservation(tx, &expired[i], ReservationExpired); err != nil {
+				return err
+			}
+			if !seen[expired[i].LevelID] {
+				seen[expired[i].LevelID] = true
+				levelIds = append(levelIds, expired[i].LevelID)
+			}
+		}
+		return nil
+	})
+	return levelIds, err
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Statuses of a Sponsor, in the order a sponsor goes through them
+const (
+	SponsorProspect   = "prospect"
+	SponsorReserved   = "reserved"
+	SponsorContracted = "contracted"
+	SponsorInvoiced   = "invoiced"
+	SponsorPaid       = "paid"
+	SponsorCancelled  = "cancelled"
+)
+
+// SponsorStatuses lists every status, in order
+var SponsorStatuses = []string{SponsorProspect, SponsorReserved, SponsorContracted, SponsorInvoiced, SponsorPaid, SponsorCancelled}
+
+// Where a sponsor can go from each status. It moves one step at a time,
+// and can be cancelled from anywhere. Cancelled is the end.
+var sponsorTransitions = map[string][]string{
+	SponsorProspect:   {SponsorReserved, SponsorCancelled},
+	SponsorReserved:   {SponsorContracted, SponsorCancelled},
+	SponsorContracted: {SponsorInvoiced, SponsorCancelled},
+	SponsorInvoiced:   {SponsorPaid, SponsorCancelled},
+	SponsorPaid:       {SponsorCancelled},
+}
+
+// LevelChangeReason is why a sponsor's status changed when it was moved onto
+// levelId, or taken off its level when levelId is nil
+func LevelChangeReason(levelId *int) string {
+	if levelId == nil {
+		return "taken off its level"
+	}
+	return "moved onto a level"
+}
+
+// Statuses that hold a spot on the sponsor's level
+var spotStatuses = []string{SponsorReserved, SponsorContracted, SponsorInvoiced, SponsorPaid}
+
+// CanChangeStatus tells whether a sponsor can go from one status to another
+func CanChangeStatus(from string, to string) bool {
+	for _, s := range sponsorTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses lists where a sponsor can go from status
+func NextStatuses(status string) []string {
+	return sponsorTransitions[status]
+}
+
+// HoldsSpot tells whether a sponsor with status takes up a spot on its level
+func HoldsSpot(status string) bool {
+	for _, s := range spotStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// SponsorStatusChange records a sponsor moving from one status to another.
+// Changes are never updated or deleted.
+type SponsorStatusChange struct {
+	ID        int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	SponsorID int    `gorm:"not null;index"`
+	From      string `gorm:"not null"`
+	To        string `gorm:"not null"`
+	Reason    string
+	// API key or token subject that changed it
+	ChangedBy string `gorm:"not null"`
+}
+
+var (
+	// ErrInvalidTransition is returned when a sponsor can't go to a status from the one it has
+	ErrInvalidTransition = errors.New("the sponsor can't go to that status from its current one")
+	// ErrNoLevel is returned when a sponsor needs a level for a status and has none
+	ErrNoLevel = errors.New("the sponsor has no level")
+	// ErrSponsorCancelled is returned when a cancelled sponsor tries to get a spot
+	ErrSponsorCancelled = errors.New("the sponsor is cancelled")
+)
+
+// Moves a sponsor to a status, and records the change. It checks nothing,
+// callers have to make sure the change is allowed.
+func setSponsorStatus(tx *gorm.DB, sponsor *Sponsor, to string, reason string, changedBy string, version int) (*SponsorStatusChange, error) {
+	now := time.Now()
+	query := tx.Model(&Sponsor{Model: Model{ID: sponsor.ID}})
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":            to,
+		"status_changed_at": now,
+		"version":           gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrVersionMismatch
+	}
+
+	change, err := recordStatusChange(tx, sponsor.ID, sponsor.Status, to, reason, changedBy)
+	if err != nil {
+		return nil, err
+	}
+	return change, tx.First(sponsor, sponsor.ID).Error
+}
+
+// Adds to a sponsor's status history
+func recordStatusChange(tx *gorm.DB, sponsorId int, from string, to string, reason string, changedBy string) (*SponsorStatusChange, error) {
+	change := SponsorStatusChange{
+		SponsorID: sponsorId,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		ChangedBy: changedBy,
+	}
+	return &change, tx.Create(&change).Error
+}
+
+// ChangeSponsorStatus moves a sponsor to another status. Every status that
+// holds a spot needs a level, and going from one that doesn't to one that
+// does needs room on it. Cancelling takes the sponsor off every waitlist.
+// When version isn't 0, the sponsor is only changed while it's still at that
+// version, ErrVersionMismatch otherwise.
+func ChangeSponsorStatus(ctx context.Context, id int, to string, reason string, changedBy string, version int) (*Sponsor, *SponsorStatusChange, error) {
+	var sponsor Sponsor
+	var change *SponsorStatusChange
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&sponsor, id).Error; err != nil {
+			return err
+		}
+		if !CanChangeStatus(sponsor.Status, to) {
+			return ErrInvalidTransition
+		}
+		if HoldsSpot(to) && sponsor.LevelID == nil {
+			return ErrNoLevel
+		}
+		if HoldsSpot(to) && !HoldsSpot(sponsor.Status) {
+			level, err := lockLevel(tx, *sponsor.LevelID)
+			if err != nil {
+				return err
+			}
+			if err := checkLevelHasRoom(tx, level); err != nil {
+				return err
+			}
+		}
+		if to == SponsorCancelled {
+			if err := withdrawFromWaitlists(tx, id, nil); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		change, err = setSponsorStatus(tx, &sponsor, to, reason, changedBy, version)
+		return err
+	})
+	return &sponsor, change, err
+}
+
+// GetSponsorStatusChanges returns a sponsor's status changes, oldest first
+func GetSponsorStatusChanges(ctx context.Context, sponsorId int) ([]SponsorStatusChange, error) {
+	conn := Database.WithContext(ctx)
+	var changes []SponsorStatusChange
+	err := conn.Where("sponsor_id = ?", sponsorId).Order("id").Find(&changes).Error
+	return changes, err
+}
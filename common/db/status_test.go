@@ -0,0 +1,59 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTakingASponsorOffItsLevel(t *testing.T) {
+	ctx := useTestDB(t)
+	event := CreateEvent(ctx, "Conf", 1)
+	level, err := CreateLevel(ctx, "Gold", "1000", 2, 2, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reserved, err := CreateSponsorWithLevel(ctx, "Doge Corp", level.ID, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sponsor, _, err := ChangeSponsor(ctx, reserved.ID, reserved.Name, nil, "test", 0)
+	if err != nil {
+		t.Fatalf("taking a reserved sponsor off its level got %v", err)
+	}
+	if sponsor.LevelID != nil || sponsor.Status != SponsorProspect {
+		t.Errorf("got level %v and status %s, want no level and prospect", sponsor.LevelID, sponsor.Status)
+	}
+	changes, err := GetSponsorStatusChanges(ctx, sponsor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := changes[len(changes)-1]; last.From != SponsorReserved || last.To != SponsorProspect {
+		t.Errorf("last status change got %s -> %s, want reserved -> prospect", last.From, last.To)
+	}
+
+	for _, status := range []string{SponsorContracted, SponsorInvoiced, SponsorPaid} {
+		s, err := CreateSponsorWithLevel(ctx, "Lolcat "+status, level.ID, event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Database.Model(s).Update("status", status).Error; err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = ChangeSponsor(ctx, s.ID, s.Name, nil, "test", 0)
+		if !errors.Is(err, ErrNoLevel) {
+			t.Errorf("taking a %s sponsor off its level got %v, want ErrNoLevel", status, err)
+		}
+		s, err = GetSponsor(ctx, s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.LevelID == nil || s.Status != status {
+			t.Errorf("%s sponsor got level %v and status %s, want it left alone", status, s.LevelID, s.Status)
+		}
+		// Frees the spot for the next one
+		if _, err := DeleteSponsor(ctx, s.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Statuses of a WaitlistEntry. Only waiting and offered entries are active,
+// the others are soft deleted and kept for history.
+const (
+	WaitlistWaiting   = "waiting"
+	WaitlistOffered   = "offered"
+	WaitlistAccepted  = "accepted"
+	WaitlistExpired   = "expired"
+	WaitlistWithdrawn = "withdrawn"
+)
+
+// WaitlistEntry is a sponsor waiting for a spot on a full level. Entries
+// are offered spots in the order they joined, and an offer holds its spot
+// until it expires.
+type WaitlistEntry struct {
+	Model
+	// A sponsor can only wait for a level once at a time
+	LevelID        int      `gorm:"not null;uniqueIndex:idx_waitlist_level_sponsor,where:deleted_at IS NULL"`
+	Level          *Level   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	SponsorID      int      `gorm:"not null;uniqueIndex:idx_waitlist_level_sponsor,where:deleted_at IS NULL"`
+	Sponsor        *Sponsor `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Status         string   `gorm:"not null;index"`
+	OfferedAt      *time.Time
+	OfferExpiresAt *time.Time
+}
+
+var (
+	// ErrAlreadyOnLevel is returned when a sponsor waits for, or accepts, the level it's already on
+	ErrAlreadyOnLevel = errors.New("the sponsor is already on this level")
+	// ErrNoOffer is returned when accepting an entry that has no offer, or whose offer expired
+	ErrNoOffer = errors.New("the waitlist entry has no offer to accept")
+)
+
+// JoinWaitlist puts a sponsor at the end of a level's waitlist. It returns
+// ErrDuplicate when the sponsor is already waiting for the level, and
+// ErrSponsorCancelled when the sponsor can't take a spot anymore.
+func JoinWaitlist(ctx context.Context, levelId int, sponsorId int) (*WaitlistEntry, error) {
+	conn := Database.WithContext(ctx)
+	entry := WaitlistEntry{
+		LevelID:   levelId,
+		SponsorID: sponsorId,
+		Status:    WaitlistWaiting,
+	}
+	var sponsor Sponsor
+	if err := conn.First(&sponsor, sponsorId).Error; err != nil {
+		return &entry, err
+	}
+	if sponsor.Status == SponsorCancelled {
+		return &entry, ErrSponsorCancelled
+	}
+	if sponsor.LevelID != nil && *sponsor.LevelID == levelId {
+		return &entry, ErrAlreadyOnLevel
+	}
+	err := conn.Create(&entry).Error
+	return &entry, translateError(err)
+}
+
+// GetWaitlist returns a level's active entries, first in line first
+func GetWaitlist(ctx context.Context, levelId int) ([]WaitlistEntry, error) {
+	conn := Database.WithContext(ctx)
+	var entries []WaitlistEntry
+	err := conn.Where("level_id = ?", levelId).Order("id").Find(&entries).Error
+	return entries, err
+}
+
+// GetWaitlistEntry finds active entries only
+func GetWaitlistEntry(ctx context.Context, id int) (*WaitlistEntry, error) {
+	conn := Database.WithContext(ctx)
+	var entry WaitlistEntry
+	err := conn.First(&entry, id).Error
+	return &entry, err
+}
+
+// Ends an entry, it's kept with its final status but no longer active
+func closeWaitlistEntry(tx *gorm.DB, entry *WaitlistEntry, status string) error {
+	entry.Status = status
+	if err := tx.Model(entry).Update("status", status).Error; err != nil {
+		return err
+	}
+	return tx.Delete(entry).Error
+}
+
+// WithdrawFromWaitlist takes a sponsor off the waitlist, giving up any offer it has
+func WithdrawFromWaitlist(ctx context.Context, id int) (*WaitlistEntry, error) {
+	conn := Database.WithContext(ctx)
+	var entry WaitlistEntry
+	if err := conn.First(&entry, id).Error; err != nil {
+		return &entry, err
+	}
+	return &entry, closeWaitlistEntry(conn, &entry, WaitlistWithdrawn)
+}
+
+// Ends a sponsor's active entries, only the one for levelId when it isn't nil
+func withdrawFromWaitlists(tx *gorm.DB, sponsorId int, levelId *int) error {
+	query := tx.Where("sponsor_id = ?", sponsorId)
+	if levelId != nil {
+		query = query.Where("level_id = ?", *levelId)
+	}
+	var entries []WaitlistEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := closeWaitlistEntry(tx, &entries[i], WaitlistWithdrawn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OfferOpenSpots offers a level's free spots to the entries first in line,
+// each held for holdFor. It returns the entries it made offers to.
+func OfferOpenSpots(ctx context.Context, levelId int, holdFor time.Duration) ([]WaitlistEntry, error) {
+	var offered []WaitlistEntry
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		level, err := lockLevel(tx, levelId)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		taken, err := levelTaken(tx, levelId, now)
+		if err != nil {
+			return err
+		}
+
+		var waiting []WaitlistEntry
+		query := tx.Where("level_id = ? AND status = ?", levelId, WaitlistWaiting).Order("id")
+		if level.MaxNumberOfSponsors > 0 {
+			free := int64(level.MaxNumberOfSponsors) - taken
+			if free <= 0 {
+				return nil
+			}
+			query = query.Limit(int(free))
+		}
+		if err := query.Find(&waiting).Error; err != nil {
+			return err
+		}
+
+		expiresAt := now.Add(holdFor)
+		for i := range waiting {
+			waiting[i].Status = WaitlistOffered
+			waiting[i].OfferedAt = &now
+			waiting[i].OfferExpiresAt = &expiresAt
+			if err := tx.Save(&waiting[i]).Error; err != nil {
+				return err
+			}
+		}
+		offered = waiting
+		return nil
+	})
+	return offered, err
+}
+
+// AcceptWaitlistOffer moves the sponsor onto the level whose spot it was
+// offered. The offer has to still be there, ErrNoOffer otherwise. A prospect
+// is reserved, so it holds the spot. Returns the sponsor as it was before too.
+func AcceptWaitlistOffer(ctx context.Context, id int, changedBy string) (*WaitlistEntry, *Sponsor, *Sponsor, error) {
+	var entry WaitlistEntry
+	var sponsor, previous Sponsor
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&entry, id).Error; err != nil {
+			return err
+		}
+		level, err := lockLevel(tx, entry.LevelID)
+		if err != nil {
+			return err
+		}
+		if entry.Status != WaitlistOffered || !time.Now().Before(*entry.OfferExpiresAt) {
+			return ErrNoOffer
+		}
+		if err := tx.First(&sponsor, entry.SponsorID).Error; err != nil {
+			return err
+		}
+		if sponsor.LevelID != nil && *sponsor.LevelID == entry.LevelID {
+			return ErrAlreadyOnLevel
+		}
+		previous = sponsor
+
+		// The offer held the spot, so there's no need to check for room
+		if err := closeWaitlistEntry(tx, &entry, WaitlistAccepted); err != nil {
+			return err
+		}
+		err = tx.Model(&Sponsor{Model: Model{ID: sponsor.ID}}).Updates(map[string]interface{}{
+			"level_id":   level.ID,
+			"level_name": level.Name,
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := syncDeliverables(tx, sponsor.ID); err != nil {
+			return err
+		}
+		if err := tx.First(&sponsor, entry.SponsorID).Error; err != nil {
+			return err
+		}
+		if sponsor.Status == SponsorProspect {
+			_, err = setSponsorStatus(tx, &sponsor, SponsorReserved, "took a waitlist offer", changedBy, 0)
+		}
+		return err
+	})
+	return &entry, &sponsor, &previous, err
+}
+
+// ExpireWaitlistOffers ends offers that weren't accepted in time, and
+// returns the levels that got spots back
+func ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]int, error) {
+	var levelIds []int
+	err := Database.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var expired []WaitlistEntry
+		err := tx.Where("status = ? AND offer_expires_at <= ?", WaitlistOffered, now).Find(&expired).Error
+		if err != nil {
+			return err
+		}
+		seen := map[int]bool{}
+		for i := range expired {
+			if err := closeWaitlistEntry(tx, &expired[i], WaitlistExpired); err != nil {
+				return err
+			}
+			if !seen[expired[i].LevelID] {
+				seen[expired[i].LevelID] = true
+				levelIds = append(levelIds, expired[i].LevelID)
+			}
+		}
+		return nil
+	})
+	return levelIds, err
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitlistOffersFreedSpotsInOrder(t *testing.T) {
+	ctx := useTestDB(t)
+	event := CreateEvent(ctx, "Conf", 1)
+	level, err := CreateLevel(ctx, "Gold", "1000", 1, 0, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := CreateSponsorWithLevel(ctx, "Doge Corp", level.ID, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*WaitlistEntry
+	for _, name := range []string{"Shiba Inc", "Corgi Ltd"} {
+		sponsor, err := CreateSponsor(ctx, name, event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := JoinWaitlist(ctx, level.ID, sponsor.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if _, err := JoinWaitlist(ctx, level.ID, holder.ID); err != ErrAlreadyOnLevel {
+		t.Errorf("the sponsor on the level joining got %v, want ErrAlreadyOnLevel", err)
+	}
+
+	if offered, err := OfferOpenSpots(ctx, level.ID, time.Hour); err != nil || len(offered) != 0 {
+		t.Fatalf("offering a full level got %v, %v, want no offers", offered, err)
+	}
+	if _, _, err := ChangeSponsor(ctx, holder.ID, holder.Name, nil, "test", 0); err != nil {
+		t.Fatal(err)
+	}
+	offered, err := OfferOpenSpots(ctx, level.ID, time.Hour)
+	if err != nil || len(offered) != 1 || offered[0].ID != entries[0].ID {
+		t.Fatalf("got %v, %v, want Shiba Inc offered the one free spot", offered, err)
+	}
+	if _, _, _, err := AcceptWaitlistOffer(ctx, entries[1].ID, "test"); err != ErrNoOffer {
+		t.Errorf("accepting without an offer got %v, want ErrNoOffer", err)
+	}
+
+	entry, sponsor, previous, err := AcceptWaitlistOffer(ctx, entries[0].ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != WaitlistAccepted || previous.LevelID != nil {
+		t.Errorf("got entry %s from level %v, want it accepted by a sponsor with no level", entry.Status, previous.LevelID)
+	}
+	if sponsor.LevelID == nil || *sponsor.LevelID != level.ID || sponsor.Status != SponsorReserved {
+		t.Errorf("got level %v and status %s, want Gold and reserved", sponsor.LevelID, sponsor.Status)
+	}
+}
+
+func TestExpiredWaitlistOffersGiveTheirSpotBack(t *testing.T) {
+	ctx := useTestDB(t)
+	event := CreateEvent(ctx, "Conf", 1)
+	level, err := CreateLevel(ctx, "Gold", "1000", 1, 0, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sponsor, err := CreateSponsor(ctx, "Shiba Inc", event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := JoinWaitlist(ctx, level.ID, sponsor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OfferOpenSpots(ctx, level.ID, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if levels, err := ExpireWaitlistOffers(ctx, time.Now()); err != nil || len(levels) != 0 {
+		t.Errorf("expiring before the hold is up got %v, %v, want nothing", levels, err)
+	}
+	levels, err := ExpireWaitlistOffers(ctx, time.Now().Add(time.Hour))
+	if err != nil || len(levels) != 1 || levels[0] != level.ID {
+		t.Errorf("got %v, %v, want Gold's spot back", levels, err)
+	}
+	if _, _, _, err := AcceptWaitlistOffer(ctx, entry.ID, "test"); err == nil {
+		t.Error("accepting an expired offer got no error")
+	}
+}
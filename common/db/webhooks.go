@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// What became of a payment provider callback
+const (
+	// Still being handled, or it failed and was never finished
+	WebhookReceived = "received"
+	// It added an entry to a sponsor's ledger
+	WebhookProcessed = "processed"
+	// A kind of event we don't act on
+	WebhookIgnored = "ignored"
+	// We couldn't tell which invoice or payment it was for
+	WebhookUnmatched = "unmatched"
+	// It was for an invoice or payment, but the ledger wouldn't take it
+	WebhookRejected = "rejected"
+)
+
+// WebhookEvent is a callback from the payment provider. ID is the
+// provider's event ID, which it sends again on every retry, so the row
+// existing means the callback has already been handled.
+type WebhookEvent struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Type      string `gorm:"not null"`
+	Status    string `gorm:"not null"`
+	// The ledger entry it made, when it was processed
+	PaymentID *int
+	// Why it was unmatched or rejected
+	Detail string
+}
+
+// ClaimWebhookEvent stores a callback before it's handled, it returns
+// ErrDuplicate when the event was already received
+func ClaimWebhookEvent(ctx context.Context, event *WebhookEvent) error {
+	conn := Database.WithContext(ctx)
+	event.Status = WebhookReceived
+	return translateError(conn.Create(event).Error)
+}
+
+func GetWebhookEvent(ctx context.Context, id string) (*WebhookEvent, error) {
+	conn := Database.WithContext(ctx)
+	var event WebhookEvent
+	err := conn.Where("id = ?", id).First(&event).Error
+	return &event, err
+}
+
+// FinishWebhookEvent records what became of a callback
+func FinishWebhookEvent(ctx context.Context, event *WebhookEvent) error {
+	conn := Database.WithContext(ctx)
+	return conn.Model(event).Updates(map[string]interface{}{
+		"status":     event.Status,
+		"payment_id": event.PaymentID,
+		"detail":     event.Detail,
+	}).Error
+}
+
+// ReleaseWebhookEvent forgets a callback that couldn't be handled, so the
+// provider's retry is handled from scratch
+func ReleaseWebhookEvent(ctx context.Context, id string) error {
+	conn := Database.WithContext(ctx)
+	return conn.Where("id = ?", id).Delete(&WebhookEvent{}).Error
+}
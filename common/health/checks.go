@@ -0,0 +1,103 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/circuitbreaker"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/messaging"
+	"time"
+)
+
+// How long the database gets to answer a ping
+const databaseTimeout = 2 * time.Second
+
+// DatabaseCheck pings the gorm connection
+func DatabaseCheck() Check {
+	return func() Result {
+		ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+		defer cancel()
+
+		stats, err := db.Ping(ctx)
+		details := map[string]interface{}{
+			"openConnections": stats.OpenConnections,
+			"inUse":           stats.InUse,
+			"idle":            stats.Idle,
+		}
+		if err != nil {
+			return Down(err, details)
+		}
+		return Up(details)
+	}
+}
+
+// MessagingCheck looks at the AMQP connection and the channels our consumers read from
+func MessagingCheck(client messaging.IRabbitMQClient) Check {
+	return func() Result {
+		state := client.State()
+		channels := map[string]interface{}{}
+		closed := 0
+		for _, c := range state.Consumers {
+			channels[c.Queue] = c.ChannelOpen
+			if !c.ChannelOpen {
+				closed++
+			}
+		}
+		details := map[string]interface{}{
+			"connected": state.Connected,
+			"channels":  channels,
+		}
+
+		if !state.Connected {
+			return Down(errors.New("not connected to the message broker"), details)
+		}
+		if closed > 0 {
+			return Down(fmt.Errorf("%d consumer channel(s) closed", closed), details)
+		}
+		return Up(details)
+	}
+}
+
+// ConsumersCheck makes sure there's a running consumer goroutine for every queue we subscribe to
+func ConsumersCheck(client messaging.IRabbitMQClient, queues []string) Check {
+	return func() Result {
+		running := map[string]bool{}
+		for _, c := range client.State().Consumers {
+			running[c.Queue] = running[c.Queue] || c.Running
+		}
+
+		details := map[string]interface{}{}
+		var missing []string
+		for _, q := range queues {
+			details[q] = running[q]
+			if !running[q] {
+				missing = append(missing, q)
+			}
+		}
+
+		if len(missing) > 0 {
+			return Down(fmt.Errorf("no consumer running for %v", missing), details)
+		}
+		return Up(details)
+	}
+}
+
+// CircuitBreakerCheck is down while the breaker is tripped
+func CircuitBreakerCheck() Check {
+	return func() Result {
+		if circuitbreaker.CB == nil {
+			return Down(errors.New("circuit breaker has not been initialized yet"), nil)
+		}
+
+		details := map[string]interface{}{
+			"tripped":             circuitbreaker.CB.Tripped(),
+			"failures":            circuitbreaker.CB.Failures(),
+			"consecutiveFailures": circuitbreaker.CB.ConsecFailures(),
+		}
+		if circuitbreaker.CB.Tripped() {
+			return Down(errors.New("circuit breaker is tripped"), details)
+		}
+		return Up(details)
+	}
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Result of checking one dependency
+type Result struct {
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Check reports on one dependency, it should return quickly
+type Check func() Result
+
+// Checks are what /readyz runs on every request, by the name they're reported under
+type Checks map[string]Check
+
+// Up and Down build results for checks
+func Up(details map[string]interface{}) Result {
+	return Result{Status: StatusUp, Details: details}
+}
+
+func Down(err error, details map[string]interface{}) Result {
+	return Result{Status: StatusDown, Error: err.Error(), Details: details}
+}
+
+// HealthJSON is the response body for /healthz and /readyz
+type HealthJSON struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Liveness answers /healthz. If the process can answer at all, it's alive,
+// a missing dependency is something for /readyz to report.
+func Liveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthJSON{Status: StatusUp})
+}
+
+// Readiness answers /readyz with the result of every check.
+// Returns 503 if any dependency is down, so no traffic gets sent our way.
+func Readiness(checks Checks) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		response := HealthJSON{
+			Status: StatusUp,
+			Checks: map[string]Result{},
+		}
+		for name, check := range checks {
+			result := check()
+			if result.Status != StatusUp {
+				response.Status = StatusDown
+			}
+			response.Checks[name] = result
+		}
+
+		if response.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
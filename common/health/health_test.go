@@ -0,0 +1,48 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	up := func() Result { return Up(nil) }
+	down := func() Result { return Down(errors.New("unreachable"), map[string]interface{}{"host": "db"}) }
+
+	tests := []struct {
+		name   string
+		checks Checks
+		code   int
+		status string
+	}{
+		{"no checks", Checks{}, http.StatusOK, StatusUp},
+		{"all up", Checks{"database": up, "messaging": up}, http.StatusOK, StatusUp},
+		{"one down", Checks{"database": down, "messaging": up}, http.StatusServiceUnavailable, StatusDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Readiness(tt.checks)(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != tt.code {
+				t.Errorf("got %d, want %d", rec.Code, tt.code)
+			}
+
+			var body HealthJSON
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != tt.status || len(body.Checks) != len(tt.checks) {
+				t.Errorf("got %+v, want %s with every check reported", body, tt.status)
+			}
+			if tt.code == http.StatusServiceUnavailable {
+				database := body.Checks["database"]
+				if database.Status != StatusDown || database.Error != "unreachable" || database.Details["host"] != "db" {
+					t.Errorf("database got %+v, want it named as down with its error", database)
+				}
+			}
+		})
+	}
+}
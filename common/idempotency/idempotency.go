@@ -0,0 +1,198 @@
+// Package idempotency lets clients retry a POST safely. The first response
+// to a request with an Idempotency-Key header is stored, and a retry with
+// the same key gets that response back instead of doing the work again.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/response"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// Set on responses that were stored, rather than made for this request
+	ReplayedHeader = "Idempotent-Replayed"
+	MaxKeyLength   = 255
+)
+
+// Response headers a replay sends again, the rest belong to the request that made it
+var replayedHeaders = []string{"Content-Type", "ETag"}
+
+// Middleware handles POST requests that have an Idempotency-Key. It has to
+// run after auth, keys are only unique per caller. Responses are kept for
+// ttl, except 5xx ones, so a retry after a failure runs the request again.
+// Bodies are read whole to hash them, so ones over maxBody get a 413.
+func Middleware(ttl time.Duration, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > MaxKeyLength {
+				apierror.Write(w, r, apierror.New(apierror.InvalidIdempotencyKey,
+					"%s can't be longer than %d characters", Header, MaxKeyLength))
+				return
+			}
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+			if err != nil && int64(len(body)) >= maxBody {
+				apierror.Write(w, r, apierror.New(apierror.BodyTooLarge, "the body can't be bigger than %d bytes", maxBody))
+				return
+			} else if err != nil {
+				apierror.Write(w, r, apierror.Wrap(apierror.MalformedRequest, err, "could not read the request body"))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			record, err := claim(r.Context(), scope(r), key, requestHash(r, body), ttl)
+			if err != nil {
+				apierror.Write(w, r, err)
+				return
+			}
+			if record.StatusCode != 0 {
+				replay(w, record)
+				return
+			}
+
+			// A handler that panics never finishes the record, so the key
+			// is freed for a retry rather than stuck in progress until ttl
+			defer func() {
+				if p := recover(); p != nil {
+					if err := db.DeleteIdempotencyRecord(context.Background(), record.ID); err != nil {
+						logging.FromContext(r.Context()).Error("could not free the idempotency key", logging.Fields{"error": err})
+					}
+					panic(p)
+				}
+			}()
+
+			// Records the response so it can be stored
+			rec := response.NewRecorder(w)
+			rec.Body = &bytes.Buffer{}
+			next.ServeHTTP(rec, r)
+
+			// The client may have given up, the response still has to be saved
+			ctx := context.Background()
+			if rec.Status >= http.StatusInternalServerError {
+				err = db.DeleteIdempotencyRecord(ctx, record.ID)
+			} else {
+				err = db.CompleteIdempotencyRecord(ctx, record.ID, rec.Status, savedHeaders(rec.Header()), rec.Body.Bytes())
+			}
+			if err != nil {
+				logging.FromContext(r.Context()).Error("could not save the response for the idempotency key", logging.Fields{"error": err})
+			}
+		})
+	}
+}
+
+// Keys are per caller, so one can't see another's responses
+func scope(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Method + ":" + p.Subject
+	}
+	return "anonymous"
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Takes the key for this request. When a request already has it, its record
+// is returned if it's finished and for the same request.
+func claim(ctx context.Context, scope string, key string, hash string, ttl time.Duration) (*db.IdempotencyRecord, error) {
+	now := time.Now()
+	record := &db.IdempotencyRecord{
+		Scope:          scope,
+		IdempotencyKey: key,
+		RequestHash:    hash,
+		ExpiresAt:      now.Add(ttl),
+	}
+	err := db.CreateIdempotencyRecord(ctx, record)
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, db.ErrDuplicate) {
+		return nil, err
+	}
+
+	existing, err := db.GetIdempotencyRecord(ctx, scope, key)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(existing.ExpiresAt) {
+		// Not swept yet, treat it as gone
+		if err := db.DeleteIdempotencyRecord(ctx, existing.ID); err != nil {
+			return nil, err
+		}
+		err = db.CreateIdempotencyRecord(ctx, record)
+		if errors.Is(err, db.ErrDuplicate) {
+			return nil, apierror.New(apierror.RequestInProgress, "a request with this %s is still being handled", Header)
+		}
+		return record, err
+	}
+	if existing.RequestHash != hash {
+		return nil, apierror.New(apierror.IdempotencyKeyReused,
+			"this %s was already used for a different request", Header)
+	}
+	if existing.StatusCode == 0 {
+		return nil, apierror.New(apierror.RequestInProgress, "a request with this %s is still being handled", Header)
+	}
+	return existing, nil
+}
+
+func savedHeaders(h http.Header) string {
+	headers := map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := h.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	data, _ := json.Marshal(headers)
+	return string(data)
+}
+
+func replay(w http.ResponseWriter, record *db.IdempotencyRecord) {
+	var headers map[string]string
+	json.Unmarshal([]byte(record.Headers), &headers)
+	for name, value := range headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// Sweep deletes expired records every interval until ctx is done
+func Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := db.DeleteExpiredIdempotencyRecords(ctx, now)
+			if err != nil {
+				logging.Error("could not delete expired idempotency keys", logging.Fields{"error": err})
+			} else if deleted > 0 {
+				logging.Debug("deleted expired idempotency keys", logging.Fields{"count": deleted})
+			}
+		}
+	}
+}
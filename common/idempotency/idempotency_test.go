@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// A handler that counts how often it really ran, behind the middleware
+// with a new in-memory database
+func counted(t *testing.T, ttl time.Duration, handler http.HandlerFunc) (http.Handler, *int) {
+	t.Helper()
+	db.InitDB(db.Creds{Driver: db.DriverSqlite, SqlitePath: db.SqliteInMemory})
+	conn := db.Database
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	calls := 0
+	return Middleware(ttl, 64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		handler(w, r)
+	})), &calls
+}
+
+func created(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"id":1}`))
+}
+
+func post(h http.Handler, key string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/sponsor-service/v1/event", strings.NewReader(body))
+	req.Header.Set(Header, key)
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) apierror.Code {
+	t.Helper()
+	var problem apierror.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("got %d with %q, want a problem", rec.Code, rec.Body.String())
+	}
+	return problem.Code
+}
+
+func TestRetryGetsTheFirstResponse(t *testing.T) {
+	h, calls := counted(t, time.Hour, created)
+	first := post(h, "a", `{"name":"Conf"}`)
+	retry := post(h, "a", `{"name":"Conf"}`)
+
+	if *calls != 1 {
+		t.Errorf("the handler ran %d times, want once", *calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry got %d with %q, want %d with %q", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get(ReplayedHeader) != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("retry got headers %v, want it marked as replayed with the first Content-Type", retry.Header())
+	}
+}
+
+func TestKeyReusedForAnotherBody(t *testing.T) {
+	h, calls := counted(t, time.Hour, created)
+	post(h, "a", `{"name":"Conf"}`)
+	rec := post(h, "a", `{"name":"Other"}`)
+
+	if code := problemCode(t, rec); rec.Code != http.StatusConflict || code != apierror.IdempotencyKeyReused {
+		t.Errorf("got %d %s, want 409 %s", rec.Code, code, apierror.IdempotencyKeyReused)
+	}
+	if *calls != 1 {
+		t.Errorf("the handler ran %d times, want once", *calls)
+	}
+}
+
+func TestRetryWhileTheFirstIsRunning(t *testing.T) {
+	var h http.Handler
+	var retry *httptest.ResponseRecorder
+	h, _ = counted(t, time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		if retry == nil {
+			retry = post(h, "a", `{"name":"Conf"}`)
+		}
+		created(w, r)
+	})
+	post(h, "a", `{"name":"Conf"}`)
+
+	if code := problemCode(t, retry); retry.Code != http.StatusConflict || code != apierror.RequestInProgress {
+		t.Errorf("got %d %s, want 409 %s", retry.Code, code, apierror.RequestInProgress)
+	}
+}
+
+func TestExpiredKeysRunAgain(t *testing.T) {
+	h, calls := counted(t, 0, created)
+	post(h, "a", `{"name":"Conf"}`)
+	rec := post(h, "a", `{"name":"Other"}`)
+
+	if rec.Code != http.StatusCreated || rec.Header().Get(ReplayedHeader) != "" || *calls != 2 {
+		t.Errorf("got %d, replayed %q after %d calls, want the request run again", rec.Code, rec.Header().Get(ReplayedHeader), *calls)
+	}
+}
+
+func TestServerErrorsAreNotKept(t *testing.T) {
+	failed := true
+	h, calls := counted(t, time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		if failed {
+			failed = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		created(w, r)
+	})
+	post(h, "a", `{"name":"Conf"}`)
+	if rec := post(h, "a", `{"name":"Conf"}`); rec.Code != http.StatusCreated || *calls != 2 {
+		t.Errorf("retry got %d after %d calls, want it run again", rec.Code, *calls)
+	}
+}
+
+func TestPanicsFreeTheKey(t *testing.T) {
+	panicked := false
+	h, calls := counted(t, time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		if !panicked {
+			panicked = true
+			panic("boom")
+		}
+		created(w, r)
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the handler's panic was swallowed")
+			}
+		}()
+		post(h, "a", `{"name":"Conf"}`)
+	}()
+
+	if rec := post(h, "a", `{"name":"Conf"}`); rec.Code != http.StatusCreated || *calls != 2 {
+		t.Errorf("retry got %d after %d calls, want it run again", rec.Code, *calls)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	h, calls := counted(t, time.Hour, created)
+	rec := post(h, "a", `{"name":"`+strings.Repeat("x", 64)+`"}`)
+
+	if code := problemCode(t, rec); rec.Code != http.StatusRequestEntityTooLarge || code != apierror.BodyTooLarge {
+		t.Errorf("got %d %s, want 413 %s", rec.Code, code, apierror.BodyTooLarge)
+	}
+	if *calls != 0 {
+		t.Errorf("the handler ran %d times, want never", *calls)
+	}
+}
+
+func TestKeyTooLong(t *testing.T) {
+	h, calls := counted(t, time.Hour, created)
+	rec := post(h, strings.Repeat("k", MaxKeyLength+1), `{}`)
+
+	if code := problemCode(t, rec); rec.Code != http.StatusBadRequest || code != apierror.InvalidIdempotencyKey || *calls != 0 {
+		t.Errorf("got %d %s after %d calls, want 400 %s", rec.Code, code, *calls, apierror.InvalidIdempotencyKey)
+	}
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/r3dcrosse/sponsor-service/common/response"
+	"net/http"
+	"time"
+)
+
+// Header used to pass a correlation ID along on HTTP requests and AMQP messages
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// Field name the correlation ID is logged under
+const correlationIDField = "correlationId"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	correlationIDKey
+)
+
+// Longest correlation ID we take from a caller
+const MaxCorrelationIDLength = 128
+
+// NewCorrelationID makes a random ID for requests and messages that didn't come with one
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidCorrelationID is true for IDs of at most MaxCorrelationIDLength
+// letters, digits, '-', '_' and '.', the only ones we pass along and log
+func ValidCorrelationID(id string) bool {
+	if id == "" || len(id) > MaxCorrelationIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithCorrelationID stores the ID, and a logger that includes it, on the context
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, correlationIDKey, id)
+	return context.WithValue(ctx, loggerKey, FromContext(ctx).With(Fields{correlationIDField: id}))
+}
+
+// CorrelationID returns the ID stored with WithCorrelationID, or ""
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// FromContext returns the logger for a request or message,
+// falling back to the root logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return root
+}
+
+// Middleware takes the correlation ID from the request (or makes one when
+// it's missing or not valid), sends it back on the response, and logs every
+// request once it's done
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationIDHeader)
+		if !ValidCorrelationID(id) {
+			id = NewCorrelationID()
+		}
+		w.Header().Set(CorrelationIDHeader, id)
+		ctx := WithCorrelationID(r.Context(), id)
+
+		start := time.Now()
+		recorder := response.NewRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		fields := Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     recorder.Status,
+			"durationMs": float64(time.Since(start).Microseconds()) / 1000,
+		}
+		logger := FromContext(ctx)
+		switch {
+		case recorder.Status >= 500:
+			logger.Error("request failed", fields)
+		case recorder.Status >= 400:
+			logger.Warn("request rejected", fields)
+		default:
+			logger.Info("request handled", fields)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareCorrelationID(t *testing.T) {
+	SetOutput(ioutil.Discard)
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"uuid", "4f1c2a6e-9b1d-4c2e-8f4a-0d3b2c1a9e8f", true},
+		{"dotted", "req_42.retry-1", true},
+		{"longest", strings.Repeat("a", MaxCorrelationIDLength), true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", MaxCorrelationIDLength+1), false},
+		{"spaces", "not an id", false},
+		{"log injection", "abc\",\"level\":\"error", false},
+		{"unicode", "ïd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = CorrelationID(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.sent != "" {
+				req.Header.Set(CorrelationIDHeader, tt.sent)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			sent := rec.Header().Get(CorrelationIDHeader)
+			if sent != seen {
+				t.Errorf("response has %q, handler saw %q", sent, seen)
+			}
+			if tt.kept && seen != tt.sent {
+				t.Errorf("got %q, want %q kept", seen, tt.sent)
+			}
+			if !tt.kept && (seen == tt.sent || !ValidCorrelationID(seen)) {
+				t.Errorf("got %q, want a new ID", seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/metrics"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+// In-process stand-in for RabbitMQ, so the service can run on a laptop or
+// in CI without a broker. Queues behave like RabbitMQ work queues:
+// messages wait in the queue until a consumer picks them up, and
+// consumers on the same queue take turns. Nothing in this process reads
+// most of the queues we publish on, so a full queue drops its oldest
+// message rather than making the publisher wait.
+type InMemoryClient struct {
+	mu        sync.Mutex
+	queues    map[string]chan amqp.Delivery
+	closed    bool
+	consumers consumerStates
+}
+
+// How many messages a queue holds before it starts dropping the oldest
+const inMemoryQueueSize = 1024
+
+func (m *InMemoryClient) ConnectToRabbitMQ(_ string) {
+	logging.Info("using the in-memory message broker, messages will not leave this process")
+}
+
+func (m *InMemoryClient) queue(queueName string) (chan amqp.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queueLocked(queueName)
+}
+
+// Like queue, with m.mu already held
+func (m *InMemoryClient) queueLocked(queueName string) (chan amqp.Delivery, error) {
+	if m.closed {
+		return nil, errors.New("in-memory message broker is closed")
+	}
+	if m.queues == nil {
+		m.queues = map[string]chan amqp.Delivery{}
+	}
+	q, ok := m.queues[queueName]
+	if !ok {
+		q = make(chan amqp.Delivery, inMemoryQueueSize)
+		m.queues[queueName] = q
+	}
+	return q, nil
+}
+
+// Puts a message on a queue without waiting, dropping the oldest one when
+// the queue is full. It holds m.mu so Close can't close the queue under it,
+// and returns whether a message was dropped.
+func (m *InMemoryClient) push(queueName string, d amqp.Delivery) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queueLocked(queueName)
+	if err != nil {
+		return false, err
+	}
+	dropped := false
+	for {
+		select {
+		case q <- d:
+			return dropped, nil
+		default:
+		}
+		// Full, make room. A consumer may take one first, that's fine too.
+		select {
+		case <-q:
+			dropped = true
+		default:
+		}
+	}
+}
+
+func (m *InMemoryClient) Send(msg []byte, exchangeName string, exchangeType string) error {
+	return errors.New("exchanges are not supported by the in-memory message broker")
+}
+
+func (m *InMemoryClient) Subscribe(exchangeName string, exchangeType string, consumerName string, handlerFunc HandlerFunc) error {
+	return errors.New("exchanges are not supported by the in-memory message broker")
+}
+
+func (m *InMemoryClient) SendOnQueue(ctx context.Context, body []byte, queueName string) error {
+	ctx, span := startPublishSpan(ctx, queueName)
+	logger := logging.FromContext(ctx).With(logging.Fields{"queue": queueName})
+	dropped, err := m.push(queueName, amqp.Delivery{
+		ContentType:   "application/json",
+		CorrelationId: logging.CorrelationID(ctx),
+		Headers:       publishHeaders(ctx),
+		RoutingKey:    queueName,
+		Timestamp:     time.Now(),
+		Body:          body,
+	})
+	endSpan(span, err)
+	if err != nil {
+		metrics.MessagePublishFailed(queueName)
+		logger.Error("could not publish message", logging.Fields{"error": err})
+		return err
+	}
+	if dropped {
+		metrics.MessageDropped(queueName)
+		logger.Warn("queue is full, dropped its oldest message", logging.Fields{"size": inMemoryQueueSize})
+	}
+
+	metrics.MessagePublished(queueName)
+	logger.Debug("published message")
+	return nil
+}
+
+func (m *InMemoryClient) SubscribeToQueue(queueName string, consumerName string, handlerFunc HandlerFunc) error {
+	q, err := m.queue(queueName)
+	if err != nil {
+		return err
+	}
+
+	state := m.consumers.add(queueName, consumerName)
+	go consumeLoop(queueName, q, handlerFunc, func() {
+		m.consumers.update(state, func(s *ConsumerState) {
+			s.ChannelOpen = false
+			s.Running = false
+		})
+	})
+	return nil
+}
+
+func (m *InMemoryClient) State() State {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+
+	return State{
+		Connected: !closed,
+		Consumers: m.consumers.snapshot(),
+	}
+}
+
+func (m *InMemoryClient) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	for _, q := range m.queues {
+		close(q)
+	}
+}
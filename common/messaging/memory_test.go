@@ -0,0 +1,71 @@
+package messaging
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryQueueDropsOldestWhenFull(t *testing.T) {
+	m := &InMemoryClient{}
+	defer m.Close()
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < inMemoryQueueSize+10; i++ {
+			if err := m.SendOnQueue(context.Background(), []byte(strconv.Itoa(i)), "nobody.listens"); err != nil {
+				t.Errorf("message %d got %v", i, err)
+			}
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a full queue with no consumer")
+	}
+
+	got := make(chan string, inMemoryQueueSize+10)
+	err := m.SubscribeToQueue("nobody.listens", "late", func(_ context.Context, d amqp.Delivery) error {
+		got <- string(d.Body)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := 10; want < inMemoryQueueSize+10; want++ {
+		select {
+		case body := <-got:
+			if body != strconv.Itoa(want) {
+				t.Fatalf("got message %s, want %d once the 10 oldest were dropped", body, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", want)
+		}
+	}
+}
+
+func TestInMemoryCloseWhileSending(t *testing.T) {
+	m := &InMemoryClient{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				// Errors once closed, but must never panic
+				m.SendOnQueue(context.Background(), []byte("x"), "racing")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	m.Close()
+	wg.Wait()
+
+	if err := m.SendOnQueue(context.Background(), []byte("x"), "racing"); err == nil {
+		t.Error("sending after Close got no error")
+	}
+}
@@ -0,0 +1,34 @@
+package messaging
+
+import (
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestDeliveryCorrelationID(t *testing.T) {
+	bad := "bad id\n"
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		want     string
+	}{
+		{"property", amqp.Delivery{CorrelationId: "from-property", Headers: amqp.Table{logging.CorrelationIDHeader: "from-header"}}, "from-property"},
+		{"header", amqp.Delivery{Headers: amqp.Table{logging.CorrelationIDHeader: "from-header"}}, "from-header"},
+		{"bad property", amqp.Delivery{CorrelationId: bad, Headers: amqp.Table{logging.CorrelationIDHeader: "from-header"}}, "from-header"},
+		{"both bad", amqp.Delivery{CorrelationId: bad, Headers: amqp.Table{logging.CorrelationIDHeader: strings.Repeat("x", 129)}}, ""},
+		{"none", amqp.Delivery{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deliveryCorrelationID(tt.delivery)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && (!logging.ValidCorrelationID(got) || got == bad) {
+				t.Errorf("got %q, want a new ID", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package messaging
+
+import "sync"
+
+// State of a client's connection to the broker, reported by /readyz
+type State struct {
+	Connected bool            `json:"connected"`
+	Consumers []ConsumerState `json:"consumers"`
+}
+
+// State of one SubscribeToQueue call
+type ConsumerState struct {
+	Queue    string `json:"queue"`
+	Consumer string `json:"consumer"`
+	// False once the broker closes the channel the consumer is reading from
+	ChannelOpen bool `json:"channelOpen"`
+	// False once the goroutine handling deliveries has stopped
+	Running bool `json:"running"`
+}
+
+// Keeps track of the consumers a client started, safe to use from
+// the consumer goroutines and the health checks at the same time
+type consumerStates struct {
+	mu     sync.Mutex
+	states []*ConsumerState
+}
+
+func (c *consumerStates) add(queueName string, consumerName string) *ConsumerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &ConsumerState{Queue: queueName, Consumer: consumerName, ChannelOpen: true, Running: true}
+	c.states = append(c.states, s)
+	return s
+}
+
+func (c *consumerStates) update(s *ConsumerState, change func(s *ConsumerState)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change(s)
+}
+
+func (c *consumerStates) snapshot() []ConsumerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	states := make([]ConsumerState, 0, len(c.states))
+	for _, s := range c.states {
+		states = append(states, *s)
+	}
+	return states
+}
@@ -0,0 +1,79 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/tracing"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Lets the OpenTelemetry propagator read and write AMQP message headers
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Headers for an outgoing message: the correlation ID and trace context from ctx
+func publishHeaders(ctx context.Context) amqp.Table {
+	headers := amqp.Table{}
+	if id := logging.CorrelationID(ctx); id != "" {
+		headers[logging.CorrelationIDHeader] = id
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+func startPublishSpan(ctx context.Context, queueName string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, fmt.Sprintf("%s send", queueName),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(queueName),
+			semconv.MessagingDestinationKindKey.String("queue"),
+		),
+	)
+}
+
+// Continues the trace the message was sent with, if it has one
+func startConsumeSpan(d amqp.Delivery, queueName string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(d.Headers))
+	return tracing.Tracer().Start(ctx, fmt.Sprintf("%s process", queueName),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(queueName),
+			semconv.MessagingDestinationKindKey.String("queue"),
+			semconv.MessagingOperationProcess,
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,94 @@
+package messaging
+
+import (
+	"context"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+	"time"
+)
+
+// Records spans with the propagator tracing.Init sets up
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
+
+func TestPublishHeadersCarryTheTrace(t *testing.T) {
+	recordSpans()
+	ctx, span := otel.Tracer("test").Start(logging.WithCorrelationID(context.Background(), "abc123"), "request")
+	defer span.End()
+
+	headers := publishHeaders(ctx)
+	if headers[logging.CorrelationIDHeader] != "abc123" || headers["traceparent"] == nil {
+		t.Fatalf("got headers %v, want the correlation ID and traceparent", headers)
+	}
+	extracted := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(headers)))
+	if extracted.TraceID() != span.SpanContext().TraceID() || extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("got %s/%s back out of the headers, want %s/%s", extracted.TraceID(), extracted.SpanID(),
+			span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	}
+
+	if headers := publishHeaders(context.Background()); headers != nil {
+		t.Errorf("got headers %v with no trace or correlation ID, want none", headers)
+	}
+}
+
+func TestConsumerContinuesThePublishersTrace(t *testing.T) {
+	exporter := recordSpans()
+	m := &InMemoryClient{}
+	defer m.Close()
+
+	handled := make(chan trace.SpanContext, 1)
+	err := m.SubscribeToQueue("sponsor.traced", "test", func(ctx context.Context, _ amqp.Delivery) error {
+		handled <- trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, request := otel.Tracer("test").Start(context.Background(), "request")
+	if err := m.SendOnQueue(ctx, []byte(`{}`), "sponsor.traced"); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+
+	var consumer trace.SpanContext
+	select {
+	case consumer = <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was never handled")
+	}
+	// The consume span ends once the handler returns
+	spans := map[string]*sdktrace.SpanSnapshot{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && spans["sponsor.traced process"] == nil; {
+		time.Sleep(10 * time.Millisecond)
+		for _, s := range exporter.GetSpans() {
+			spans[s.Name] = s
+		}
+	}
+
+	send, process := spans["sponsor.traced send"], spans["sponsor.traced process"]
+	if send == nil || process == nil {
+		t.Fatalf("got spans %v, want a send and a process span", spans)
+	}
+	if send.SpanKind != trace.SpanKindProducer || send.Parent.SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("send got kind %s and parent %s, want a producer under the request", send.SpanKind, send.Parent.SpanID())
+	}
+	if process.SpanKind != trace.SpanKindConsumer || process.Parent.SpanID() != send.SpanContext.SpanID() || !process.Parent.IsRemote() {
+		t.Errorf("process got kind %s and parent %s, want a consumer under the send span from the message headers",
+			process.SpanKind, process.Parent.SpanID())
+	}
+	if process.SpanContext.TraceID() != request.SpanContext().TraceID() || consumer.SpanID() != process.SpanContext.SpanID() {
+		t.Errorf("the handler got span %s in trace %s, want the process span in the request's trace",
+			consumer.SpanID(), consumer.TraceID())
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/r3dcrosse/sponsor-service/common/response"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware counts and times every request, labelled with the mux route
+// template (/sponsor-service/v1/event/{id}) rather than the real path,
+// so IDs don't end up as labels
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := response.NewRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.Status)).Inc()
+	})
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// Everything we export starts with this
+const namespace = "sponsor_service"
+
+//////////////////////////////////////////////////////////////
+//
+// Collectors
+//
+//////////////////////////////////////////////////////////////
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by mux route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "How long HTTP requests took to handle, by mux route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "How long gorm queries took, by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "gorm queries that returned an error (not counting record not found), by operation and table.",
+	}, []string{"operation", "table"})
+
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Messages received from the broker, by queue.",
+	}, []string{"queue"})
+
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that could not be handled (direction=consume) or sent (direction=publish), by queue.",
+	}, []string{"queue", "direction"})
+
+	messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_published_total",
+		Help:      "Messages published to the broker, by queue.",
+	}, []string{"queue"})
+
+	messagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages the in-memory broker dropped because their queue was full, by queue.",
+	}, []string{"queue"})
+
+	publishInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "publish_in_flight_messages",
+		Help:      "Messages being sent in the background for records that were already saved, by queue. Lost if the service stops.",
+	}, []string{"queue"})
+
+	publishLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "publish_latency_seconds",
+		Help:      "Time between a record being saved and the attempt to publish its message, by queue.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60},
+	}, []string{"queue"})
+
+	breakerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_events_total",
+		Help:      "Events from the RabbitMQ circuit breaker, by event (tripped, reset, fail, ready).",
+	}, []string{"event"})
+
+	breakerTripped = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_tripped",
+		Help:      "1 while the RabbitMQ circuit breaker is tripped, 0 otherwise.",
+	})
+)
+
+// Handler serves /metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+//////////////////////////////////////////////////////////////
+//
+// Messaging
+//
+//////////////////////////////////////////////////////////////
+func MessageConsumed(queue string) {
+	messagesConsumed.WithLabelValues(queue).Inc()
+}
+
+func MessageConsumeFailed(queue string) {
+	messagesFailed.WithLabelValues(queue, "consume").Inc()
+}
+
+func MessagePublished(queue string) {
+	messagesPublished.WithLabelValues(queue).Inc()
+}
+
+func MessagePublishFailed(queue string) {
+	messagesFailed.WithLabelValues(queue, "publish").Inc()
+}
+
+func MessageDropped(queue string) {
+	messagesDropped.WithLabelValues(queue).Inc()
+}
+
+// PublishStarted is called once a record is saved and its message starts being sent
+func PublishStarted(queue string) {
+	publishInFlight.WithLabelValues(queue).Inc()
+}
+
+// PublishFinished is called after trying to send a message started with
+// PublishStarted, savedAt is when the record the message is about was saved
+func PublishFinished(queue string, savedAt time.Time) {
+	publishInFlight.WithLabelValues(queue).Dec()
+	publishLatency.WithLabelValues(queue).Observe(time.Since(savedAt).Seconds())
+}
+
+//////////////////////////////////////////////////////////////
+//
+// Circuit breaker
+//
+//////////////////////////////////////////////////////////////
+const (
+	BreakerTripped = "tripped"
+	BreakerReset   = "reset"
+	BreakerFail    = "fail"
+	BreakerReady   = "ready"
+)
+
+func BreakerEvent(event string) {
+	breakerEvents.WithLabelValues(event).Inc()
+
+	switch event {
+	case BreakerTripped:
+		breakerTripped.Set(1)
+	case BreakerReset:
+		breakerTripped.Set(0)
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareLabelsByRoute(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/event/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("/event/{id}", "GET", "418"))
+	for _, path := range []string{"/event/1", "/event/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("/event/{id}", "GET", "418")) - before; got != 2 {
+		t.Errorf("got %v requests counted under the route, want 2", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("/event/1", "GET", "418")); got != 0 {
+		t.Errorf("got %v requests counted under the path, want none", got)
+	}
+}
+
+type widget struct {
+	ID   int
+	Name string
+}
+
+func TestGormPlugin(t *testing.T) {
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+	errorsBefore := testutil.ToFloat64(dbErrors.WithLabelValues("create", "widgets"))
+	conn.Create(&widget{ID: 1, Name: "first"})
+	// The same primary key again
+	conn.Create(&widget{ID: 1, Name: "again"})
+	// Not found isn't an error worth counting
+	conn.First(&widget{}, 99)
+
+	if got := testutil.ToFloat64(dbErrors.WithLabelValues("create", "widgets")) - errorsBefore; got != 1 {
+		t.Errorf("got %v create errors, want the duplicate's", got)
+	}
+	if got := testutil.ToFloat64(dbErrors.WithLabelValues("query", "widgets")); got != 0 {
+		t.Errorf("got %v query errors, want none for a record that isn't there", got)
+	}
+	if n := testutil.CollectAndCount(dbDuration); n == 0 {
+		t.Error("no queries were timed")
+	}
+}
+
+func TestPublishInFlight(t *testing.T) {
+	PublishStarted("test.queue")
+	PublishStarted("test.queue")
+	if got := testutil.ToFloat64(publishInFlight.WithLabelValues("test.queue")); got != 2 {
+		t.Errorf("got %v in flight, want 2", got)
+	}
+	PublishFinished("test.queue", time.Now().Add(-time.Second))
+	if got := testutil.ToFloat64(publishInFlight.WithLabelValues("test.queue")); got != 1 {
+		t.Errorf("got %v in flight, want 1", got)
+	}
+	PublishFinished("test.queue", time.Now())
+}
+
+func TestBreakerEvent(t *testing.T) {
+	BreakerEvent(BreakerTripped)
+	if got := testutil.ToFloat64(breakerTripped); got != 1 {
+		t.Errorf("got %v, want 1 while tripped", got)
+	}
+	BreakerEvent(BreakerFail)
+	if got := testutil.ToFloat64(breakerTripped); got != 1 {
+		t.Errorf("got %v, want a failure to leave it tripped", got)
+	}
+	BreakerEvent(BreakerReset)
+	if got := testutil.ToFloat64(breakerTripped); got != 0 {
+		t.Errorf("got %v, want 0 once reset", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	MessagePublished("test.handler")
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `sponsor_service_messages_published_total{queue="test.handler"} 1`) {
+		t.Errorf("/metrics is missing the published message:\n%s", rec.Body.String())
+	}
+}
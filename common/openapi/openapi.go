@@ -0,0 +1,1134 @@
+// Package openapi describes the REST API as an OpenAPI 3 document.
+// The contract tests in main_test.go check real responses against it,
+// so update it along with any handler whose request or response changes.
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/idempotency"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Version of the API the document describes
+const Version = "1.0.0"
+
+// Object is a piece of the document
+type Object map[string]interface{}
+
+//////////////////////////////////////////////////////////////
+//
+// Helpers for building schemas
+//
+//////////////////////////////////////////////////////////////
+
+func ref(name string) Object {
+	return Object{"$ref": "#/components/schemas/" + name}
+}
+
+func str() Object      { return Object{"type": "string"} }
+func integer() Object  { return Object{"type": "integer"} }
+func boolean() Object  { return Object{"type": "boolean"} }
+func dateTime() Object { return Object{"type": "string", "format": "date-time"} }
+
+func nullable(schema Object) Object {
+	out := Object{"nullable": true}
+	for k, v := range schema {
+		out[k] = v
+	}
+	return out
+}
+
+func array(items Object) Object {
+	return Object{"type": "array", "items": items}
+}
+
+func enum(values ...string) Object {
+	return Object{"type": "string", "enum": values}
+}
+
+// Objects are closed, a field that isn't listed is a mistake
+// in either the handler or this document
+func object(required []string, properties Object) Object {
+	o := Object{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		o["required"] = required
+	}
+	return o
+}
+
+// Every success response is {"success": true, "data": {...}}
+func envelope(data Object) Object {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	return object([]string{"success", "data"}, Object{
+		"success": boolean(),
+		"data":    object(keys, data),
+	})
+}
+
+func jsonContent(schema Object) Object {
+	return Object{"application/json": Object{"schema": schema}}
+}
+
+func response(description string, schema Object) Object {
+	return Object{"description": description, "content": jsonContent(schema)}
+}
+
+// Response that has the resource's ETag
+func withETag(response Object) Object {
+	response["headers"] = Object{"ETag": Object{"$ref": "#/components/headers/ETag"}}
+	return response
+}
+
+func body(name string) Object {
+	return Object{"required": true, "content": jsonContent(ref(name))}
+}
+
+func pathParam(name string, description string) Object {
+	return Object{"name": name, "in": "path", "required": true, "description": description, "schema": integer()}
+}
+
+// Operation with a 200 response and the problems it can send
+func operation(summary string, tag string, ok Object, problems ...int) Object {
+	responses := Object{"200": ok}
+	addProblems(responses, problems...)
+	return Object{"summary": summary, "tags": []string{tag}, "responses": responses}
+}
+
+func addProblems(responses Object, statuses ...int) {
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = Object{"$ref": "#/components/responses/" + problemResponseName(status)}
+	}
+	responses["default"] = Object{"$ref": "#/components/responses/Problem"}
+}
+
+func problemResponseName(status int) string {
+	return "Problem" + strconv.Itoa(status)
+}
+
+// Statuses operations refer to as #/components/responses/Problem<status>
+var problemStatuses = []int{
+	http.StatusBadRequest,
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusConflict,
+	http.StatusPreconditionFailed,
+	http.StatusRequestEntityTooLarge,
+	http.StatusUnprocessableEntity,
+	http.StatusNotImplemented,
+}
+
+//////////////////////////////////////////////////////////////
+//
+// The document
+//
+//////////////////////////////////////////////////////////////
+
+func schemas() Object {
+	// Not a $ref, so Sponsor can say it's nullable
+	logo := object([]string{"url", "thumbnailUrl", "contentType", "width", "height", "size", "uploadedAt"}, Object{
+		"url": Object{"type": "string", "format": "uri"},
+		// PNG, at most 256 pixels on either side
+		"thumbnailUrl": Object{"type": "string", "format": "uri"},
+		"contentType":  enum("image/png", "image/jpeg"),
+		"width":        integer(),
+		"height":       integer(),
+		// In bytes
+		"size":       integer(),
+		"uploadedAt": nullable(dateTime()),
+	})
+	codes := []string{}
+	for _, c := range apierror.Codes() {
+		codes = append(codes, string(c))
+	}
+	roles := []string{auth.RoleOrganizer, auth.RoleSponsorAdmin, auth.RoleFinance}
+
+	return Object{
+		"Level": object([]string{"id", "eventId", "name", "cost", "maxSponsors", "maxFreeBadgesPerSponsor", "version"}, Object{
+			"id":                      integer(),
+			"version":                 integer(),
+			"eventId":                 integer(),
+			"name":                    str(),
+			"cost":                    str(),
+			"maxSponsors":             integer(),
+			"maxFreeBadgesPerSponsor": integer(),
+		}),
+		"Member": object([]string{"id", "name", "email", "sponsorId"}, Object{
+			"id":        integer(),
+			"name":      str(),
+			"email":     str(),
+			"sponsorId": integer(),
+		}),
+		"Sponsor": object([]string{"id", "event", "eventId", "name", "level", "members", "status", "logo"}, Object{
+			"id":      integer(),
+			"event":   str(),
+			"eventId": integer(),
+			"name":    str(),
+			// All zeros when the sponsor has no level
+			"level":   ref("Level"),
+			"members": nullable(array(ref("Member"))),
+			"status":  enum(db.SponsorStatuses...),
+			// null until the sponsor uploads one
+			"logo": nullable(logo),
+		}),
+		"Logo": logo,
+		"StatusChange": object([]string{"id", "from", "to", "reason", "changedBy", "changedAt"}, Object{
+			"id":        integer(),
+			"from":      enum(db.SponsorStatuses...),
+			"to":        enum(db.SponsorStatuses...),
+			"reason":    str(),
+			"changedBy": str(),
+			"changedAt": dateTime(),
+		}),
+		"Event": object([]string{"id", "name", "levels", "sponsors"}, Object{
+			"id":       integer(),
+			"name":     str(),
+			"levels":   nullable(array(ref("Level"))),
+			"sponsors": nullable(array(ref("Sponsor"))),
+		}),
+		"WaitlistEntry": object([]string{"id", "levelId", "sponsorId", "status", "joinedAt", "offeredAt", "offerExpiresAt"}, Object{
+			"id":        integer(),
+			"levelId":   integer(),
+			"sponsorId": integer(),
+			"status":    enum(db.WaitlistWaiting, db.WaitlistOffered, db.WaitlistAccepted, db.WaitlistExpired, db.WaitlistWithdrawn),
+			// Left out once the entry isn't in line anymore
+			"position":       integer(),
+			"joinedAt":       dateTime(),
+			"offeredAt":      nullable(dateTime()),
+			"offerExpiresAt": nullable(dateTime()),
+		}),
+		"Reservation": object([]string{"id", "levelId", "prospect", "note", "heldBy", "status", "expiresAt", "createdAt", "sponsorId"}, Object{
+			"id":        integer(),
+			"levelId":   integer(),
+			"prospect":  str(),
+			"note":      str(),
+			"heldBy":    str(),
+			"status":    enum(db.ReservationHeld, db.ReservationConverted, db.ReservationReleased, db.ReservationExpired),
+			"expiresAt": dateTime(),
+			"createdAt": dateTime(),
+			"sponsorId": nullable(integer()),
+		}),
+		// Dates are like 2021-06-30
+		"Benefit": object([]string{"id", "levelId", "name", "kind", "quantity", "details", "dueDate"}, Object{
+			"id":       integer(),
+			"levelId":  integer(),
+			"name":     str(),
+			"kind":     enum(db.BenefitKinds...),
+			"quantity": integer(),
+			"details":  str(),
+			"dueDate":  nullable(Object{"type": "string", "format": "date"}),
+		}),
+		"Deliverable": object([]string{"id", "sponsorId", "benefitId", "name", "kind", "quantity", "details", "status", "owner",
+			"dueDate", "overdue", "notes", "deliveredAt", "updatedAt"}, Object{
+			"id":          integer(),
+			"sponsorId":   integer(),
+			"benefitId":   integer(),
+			"name":        str(),
+			"kind":        str(),
+			"quantity":    integer(),
+			"details":     str(),
+			"status":      enum(db.DeliverableStatuses...),
+			"owner":       str(),
+			"dueDate":     nullable(Object{"type": "string", "format": "date"}),
+			"overdue":     boolean(),
+			"notes":       str(),
+			"deliveredAt": nullable(dateTime()),
+			"updatedAt":   dateTime(),
+		}),
+		"Badge": object([]string{"id", "memberId", "sponsorId", "code", "name", "email", "sponsor", "levelLabel", "status",
+			"issuedAt", "printedAt", "revokedAt", "qrCodeUrl"}, Object{
+			"id":        integer(),
+			"memberId":  integer(),
+			"sponsorId": integer(),
+			// What the QR code on it holds, read back at the door
+			"code":  str(),
+			"name":  str(),
+			"email": str(),
+			// The sponsor's name
+			"sponsor": str(),
+			// The sponsor's level, like "Gold"
+			"levelLabel": str(),
+			"status":     enum(db.BadgeStatuses...),
+			"issuedAt":   dateTime(),
+			"printedAt":  nullable(dateTime()),
+			"revokedAt":  nullable(dateTime()),
+			"qrCodeUrl":  str(),
+		}),
+		// Amounts are in the currency's minor unit, cents for USD
+		"Invoice": object([]string{"id", "eventId", "sponsorId", "number", "reference", "status", "billTo", "level", "currency", "taxRate",
+			"lines", "subtotal", "tax", "total", "notes", "dueDate", "createdAt", "issuedAt", "paidAt", "voidedAt"}, Object{
+			"id":        integer(),
+			"eventId":   integer(),
+			"sponsorId": integer(),
+			// Given when it's issued, counting up per event
+			"number":    nullable(integer()),
+			"reference": str(),
+			"status":    enum(db.InvoiceStatuses...),
+			"billTo":    str(),
+			"level":     str(),
+			"currency":  str(),
+			"taxRate":   Object{"type": "number"},
+			"lines":     array(ref("InvoiceLine")),
+			"subtotal":  integer(),
+			"tax":       integer(),
+			"total":     integer(),
+			"notes":     str(),
+			"dueDate":   nullable(Object{"type": "string", "format": "date"}),
+			"createdAt": dateTime(),
+			"issuedAt":  nullable(dateTime()),
+			"paidAt":    nullable(dateTime()),
+			"voidedAt":  nullable(dateTime()),
+		}),
+		"InvoiceLine": object([]string{"kind", "description", "quantity", "unitAmount", "amount"}, Object{
+			"kind":        enum(db.LineLevel, db.LineAddOn),
+			"description": str(),
+			"quantity":    integer(),
+			"unitAmount":  integer(),
+			"amount":      integer(),
+		}),
+		"Payment": object([]string{"id", "sponsorId", "invoiceId", "kind", "amount", "currency", "method", "reference", "note",
+			"recordedBy", "receivedAt", "createdAt"}, Object{
+			"id":         integer(),
+			"sponsorId":  integer(),
+			"invoiceId":  nullable(integer()),
+			"kind":       enum(db.PaymentKinds...),
+			"amount":     integer(),
+			"currency":   str(),
+			"method":     str(),
+			"reference":  str(),
+			"note":       str(),
+			"recordedBy": str(),
+			"receivedAt": dateTime(),
+			"createdAt":  dateTime(),
+		}),
+		// Outstanding is less than 0 when the sponsor overpaid
+		"Balance": object([]string{"currency", "invoiced", "paid", "credited", "outstanding"}, Object{
+			"currency":    str(),
+			"invoiced":    integer(),
+			"paid":        integer(),
+			"credited":    integer(),
+			"outstanding": integer(),
+		}),
+		"WebhookEvent": object([]string{"id", "type", "status", "detail", "paymentId", "duplicate", "receivedAt"}, Object{
+			"id":         str(),
+			"type":       str(),
+			"status":     enum(db.WebhookProcessed, db.WebhookIgnored, db.WebhookUnmatched, db.WebhookRejected),
+			"detail":     str(),
+			"paymentId":  nullable(integer()),
+			"duplicate":  boolean(),
+			"receivedAt": dateTime(),
+		}),
+		"SponsorReport": object([]string{"id", "name", "level", "status", "balance"}, Object{
+			"id":      integer(),
+			"name":    str(),
+			"level":   str(),
+			"status":  enum(db.SponsorStatuses...),
+			"balance": ref("Balance"),
+		}),
+		"Allowance": object([]string{"freeBadges", "used", "remaining"}, Object{
+			"freeBadges": integer(),
+			"used":       integer(),
+			"remaining":  integer(),
+		}),
+		"Invite": object([]string{"id", "sponsorId", "expiresAt", "revokedAt", "createdBy", "createdAt"}, Object{
+			"id":        integer(),
+			"sponsorId": integer(),
+			"expiresAt": dateTime(),
+			"revokedAt": nullable(dateTime()),
+			"createdBy": str(),
+			"createdAt": dateTime(),
+		}),
+		"AuditEntry": object([]string{"id", "createdAt", "actor", "action", "sponsorId", "details", "correlationId"}, Object{
+			"id":            integer(),
+			"createdAt":     dateTime(),
+			"actor":         str(),
+			"action":        str(),
+			"sponsorId":     nullable(integer()),
+			"details":       Object{"type": "object", "additionalProperties": true},
+			"correlationId": str(),
+		}),
+		"APIKey": object([]string{"id", "name", "prefix", "admin", "role", "sponsorId", "createdAt", "revokedAt"}, Object{
+			"id":        integer(),
+			"name":      str(),
+			"prefix":    str(),
+			"admin":     boolean(),
+			"role":      str(),
+			"sponsorId": nullable(integer()),
+			"createdAt": dateTime(),
+			"revokedAt": nullable(dateTime()),
+		}),
+		"Health": object([]string{"status"}, Object{
+			"status": enum("up", "down"),
+			"checks": Object{
+				"type": "object",
+				"additionalProperties": object([]string{"status"}, Object{
+					"status":  enum("up", "down"),
+					"error":   str(),
+					"details": Object{"type": "object", "additionalProperties": true},
+				}),
+			},
+		}),
+		"FieldError": object([]string{"field", "rule", "message"}, Object{
+			"field":   str(),
+			"rule":    str(),
+			"message": str(),
+		}),
+		"Problem": object([]string{"type", "title", "status", "code"}, Object{
+			"type":          str(),
+			"title":         str(),
+			"status":        integer(),
+			"detail":        str(),
+			"instance":      str(),
+			"code":          enum(codes...),
+			"correlationId": str(),
+			"errors":        array(ref("FieldError")),
+		}),
+
+		// Request bodies
+		"EventRequest": object([]string{"name"}, Object{
+			"name": str(),
+		}),
+		"PatchEventRequest": object(nil, Object{
+			"name":   str(),
+			"levels": array(ref("LevelRequest")),
+		}),
+		// version is only checked when a PATCH to the event updates the level
+		"LevelRequest": object([]string{"name", "cost"}, Object{
+			"id":                      integer(),
+			"version":                 integer(),
+			"name":                    str(),
+			"cost":                    str(),
+			"maxSponsors":             integer(),
+			"maxFreeBadgesPerSponsor": integer(),
+		}),
+		"PatchLevelRequest": object(nil, Object{
+			"name":                    str(),
+			"cost":                    str(),
+			"maxSponsors":             integer(),
+			"maxFreeBadgesPerSponsor": integer(),
+		}),
+		"SponsorRequest": object([]string{"name"}, Object{
+			"name":  str(),
+			"level": ref("LevelRequest"),
+		}),
+		"PatchSponsorRequest": object(nil, Object{
+			"name": str(),
+			// 0 takes the sponsor off its level
+			"levelId": integer(),
+		}),
+		"SponsorStatusRequest": object([]string{"status"}, Object{
+			"status": enum(db.SponsorStatuses...),
+			// Required when cancelling
+			"reason": str(),
+		}),
+		"WaitlistRequest": object([]string{"sponsorId"}, Object{
+			"sponsorId": integer(),
+		}),
+		"BenefitRequest": object([]string{"name", "kind"}, Object{
+			"name":     str(),
+			"kind":     enum(db.BenefitKinds...),
+			"quantity": integer(),
+			"details":  str(),
+			"dueDate":  Object{"type": "string", "format": "date"},
+		}),
+		// An empty dueDate takes the due date off
+		"PatchDeliverableRequest": object(nil, Object{
+			"status":  enum(db.DeliverableStatuses...),
+			"owner":   str(),
+			"dueDate": str(),
+			"notes":   str(),
+		}),
+		"ReservationRequest": object([]string{"prospect"}, Object{
+			"prospect": str(),
+			"note":     str(),
+			"holdFor":  str(),
+		}),
+		"ConvertReservationRequest": object(nil, Object{
+			"name": str(),
+		}),
+		"InvoiceRequest": object(nil, Object{
+			"addOns": array(object([]string{"description", "quantity", "unitAmount"}, Object{
+				"description": str(),
+				"quantity":    integer(),
+				"unitAmount":  integer(),
+			})),
+			"taxRate": Object{"type": "number"},
+			"dueDate": Object{"type": "string", "format": "date"},
+			"notes":   str(),
+		}),
+		"InvoiceStatusRequest": object([]string{"status"}, Object{
+			"status": enum(db.InvoiceStatuses...),
+		}),
+		"BadgeStatusRequest": object([]string{"status"}, Object{
+			"status": enum(db.BadgeStatuses...),
+		}),
+		"PaymentRequest": object([]string{"amount"}, Object{
+			"kind":   enum(db.PaymentKinds...),
+			"amount": integer(),
+			// Required unless it's a credit note
+			"method":     enum(db.PaymentMethods...),
+			"reference":  str(),
+			"note":       str(),
+			"invoiceId":  integer(),
+			"receivedAt": dateTime(),
+		}),
+		// A Stripe event, only the fields we read are listed
+		"ProviderEvent": Object{
+			"type":     "object",
+			"required": []string{"id", "type", "data"},
+			"properties": Object{
+				"id":      str(),
+				"type":    str(),
+				"created": integer(),
+				"data": Object{
+					"type": "object",
+					"properties": Object{"object": Object{
+						"type": "object",
+						"properties": Object{
+							"id":              str(),
+							"amount":          integer(),
+							"amount_received": integer(),
+							"currency":        str(),
+							"payment_intent":  str(),
+							"metadata":        Object{"type": "object", "additionalProperties": str()},
+						},
+						"additionalProperties": true,
+					}},
+					"additionalProperties": true,
+				},
+			},
+			"additionalProperties": true,
+		},
+		"MemberRequest": object([]string{"name", "email"}, Object{
+			"name":  str(),
+			"email": str(),
+		}),
+		"PatchMemberRequest": object(nil, Object{
+			"name":  str(),
+			"email": str(),
+		}),
+		"InviteRequest": object(nil, Object{
+			"expiresIn": str(),
+		}),
+		"APIKeyRequest": object([]string{"name", "role"}, Object{
+			"name":      str(),
+			"admin":     boolean(),
+			"role":      enum(roles...),
+			"sponsorId": integer(),
+		}),
+	}
+}
+
+func responses() Object {
+	problem := Object{"application/problem+json": Object{"schema": ref("Problem")}}
+	out := Object{
+		"Problem": Object{"description": "Something went wrong", "content": problem},
+	}
+	descriptions := map[int]string{
+		http.StatusBadRequest:            "The body isn't JSON, an ID in the path isn't a number, or the Idempotency-Key is too long",
+		http.StatusUnauthorized:          "Missing or bad credentials",
+		http.StatusForbidden:             "The caller's role doesn't allow it",
+		http.StatusNotFound:              "Something in the path doesn't exist",
+		http.StatusConflict:              "It clashes with something that's already there",
+		http.StatusPreconditionFailed:    "It changed since the ETag in If-Match",
+		http.StatusRequestEntityTooLarge: "The body is bigger than any endpoint takes",
+		http.StatusUnprocessableEntity:   "The body broke some rules",
+		http.StatusNotImplemented:        "Not done yet",
+	}
+	for _, status := range problemStatuses {
+		out[problemResponseName(status)] = Object{"description": descriptions[status], "content": problem}
+	}
+	return out
+}
+
+func paths() Object {
+	const v1 = "/sponsor-service/v1"
+	eventId := pathParam("event_id", "Our ID of the event")
+	sponsorId := pathParam("sponsor_id", "ID of a sponsor of the event")
+	levelId := pathParam("level_id", "ID of a level of the event")
+	entryId := pathParam("entry_id", "ID of an entry on the level's waitlist")
+	reservationId := pathParam("reservation_id", "ID of a reservation of the level")
+	invoiceId := pathParam("invoice_id", "ID of an invoice of the event")
+	badgeId := pathParam("badge_id", "ID of a badge of the event")
+	badgeFilters := []Object{eventId,
+		{"name": "sponsorId", "in": "query", "required": false, "description": "Only this sponsor's badges", "schema": integer()},
+		{"name": "status", "in": "query", "required": false, "description": "Only badges with this status, revoked ones are left out otherwise", "schema": enum(db.BadgeStatuses...)},
+		{"name": "code", "in": "query", "required": false, "description": "Only the badge with this code, as read off its QR code", "schema": str()}}
+	noAuth := []Object{}
+	invite := []Object{{"inviteToken": []string{}}, {"inviteHeader": []string{}}}
+
+	createdInvite := response("The invite, its token is only ever sent here", envelope(Object{
+		"invite": ref("Invite"),
+		"token":  str(),
+		"url":    str(),
+	}))
+	createInvite := Object{
+		"summary":     "Create an invite link for the sponsor's contact",
+		"tags":        []string{"invites"},
+		"requestBody": Object{"required": false, "content": jsonContent(ref("InviteRequest"))},
+		"responses":   Object{"201": createdInvite},
+	}
+	addProblems(createInvite["responses"].(Object), 401, 403, 404, 422)
+
+	createPortalMember := Object{
+		"summary":     "Add a member to the team, while there are free badges left",
+		"tags":        []string{"portal"},
+		"security":    invite,
+		"requestBody": body("MemberRequest"),
+		"responses":   Object{"201": response("The new member", envelope(Object{"member": ref("Member")}))},
+	}
+	addProblems(createPortalMember["responses"].(Object), 401, 403, 409, 422)
+
+	removeMember := Object{
+		"summary":   "Remove a member from a sponsor",
+		"tags":      []string{"members"},
+		"responses": Object{},
+	}
+	addProblems(removeMember["responses"].(Object), 400, 401, 403, 501)
+
+	getMember := operation("Get a member of a sponsor", "members", withETag(response("The member",
+		envelope(Object{"member": ref("Member")}))), 400, 401, 403, 404)
+	getMember["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getMember["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	readyResponses := Object{
+		"200": response("Every dependency is up", ref("Health")),
+		"503": response("Some dependency is down", ref("Health")),
+	}
+
+	withBody := func(op Object, name string) Object {
+		op["requestBody"] = body(name)
+		return op
+	}
+	withSecurity := func(op Object, security []Object) Object {
+		op["security"] = security
+		return op
+	}
+
+	getEvent := operation("Get an event", "events", withETag(response("The event",
+		envelope(Object{"event": ref("Event")}))), 400, 401, 403, 404)
+	getEvent["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getEvent["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	patchEvent := withBody(operation("Rename an event, and add or change its levels", "events", withETag(response("The event with its levels",
+		envelope(Object{"event": ref("Event")}))), 400, 401, 403, 404, 409, 412, 422), "PatchEventRequest")
+	patchEvent["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	getLevel := operation("Get a sponsorship level", "events", withETag(response("The level",
+		envelope(Object{"level": ref("Level")}))), 400, 401, 403, 404, 422)
+	getLevel["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getLevel["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	patchLevel := withBody(operation("Change a level's name, cost or limits, offering any new spots to the waitlist", "events",
+		withETag(response("The level", envelope(Object{"level": ref("Level")}))), 400, 401, 403, 404, 409, 412, 422), "PatchLevelRequest")
+	patchLevel["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	getSponsor := operation("Get a sponsor", "sponsors", withETag(response("The sponsor",
+		envelope(Object{"sponsor": ref("Sponsor")}))), 400, 401, 403, 404)
+	getSponsor["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getSponsor["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	patchMember := withBody(operation("Change a member's name or email, and send sponsor.member.updated", "members",
+		withETag(response("The member", envelope(Object{"member": ref("Member")}))), 400, 401, 403, 404, 409, 412, 422), "PatchMemberRequest")
+	patchMember["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	patchSponsor := withBody(operation("Rename a sponsor or move it to another level, offering the spot it leaves to the waitlist", "sponsors",
+		withETag(response("The sponsor", envelope(Object{"sponsor": ref("Sponsor")}))), 400, 401, 403, 404, 409, 412, 422), "PatchSponsorRequest")
+	patchSponsor["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	deleteSponsor := operation("Delete a sponsor, offering its spot to the waitlist", "sponsors",
+		response("The deleted sponsor", envelope(Object{"sponsor": ref("Sponsor")})), 400, 401, 403, 404, 412)
+	deleteSponsor["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	changeStatus := withBody(operation("Move a sponsor to its next status, or cancel it, and send sponsor.status.changed", "sponsors",
+		withETag(response("The sponsor and the change", envelope(Object{"sponsor": ref("Sponsor"), "change": ref("StatusChange")}))),
+		400, 401, 403, 404, 409, 412, 422), "SponsorStatusRequest")
+	changeStatus["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	uploadLogo := Object{
+		"summary": "Upload a sponsor's logo, replacing the one it had, and make a thumbnail of it",
+		"tags":    []string{"sponsors"},
+		"requestBody": Object{"required": true, "content": Object{"multipart/form-data": Object{"schema": object([]string{"file"}, Object{
+			"file": Object{"type": "string", "format": "binary", "description": "A PNG or JPEG, 64 to 5000 pixels on each side"},
+		})}}},
+		"parameters": []Object{{"$ref": "#/components/parameters/IfMatch"}},
+		"responses":  Object{"200": withETag(response("The sponsor with its new logo", envelope(Object{"sponsor": ref("Sponsor")})))},
+	}
+	addProblems(uploadLogo["responses"].(Object), 400, 401, 403, 404, 412, 422, 501)
+
+	deleteLogo := operation("Take a sponsor's logo away", "sponsors",
+		withETag(response("The sponsor without its logo", envelope(Object{"sponsor": ref("Sponsor")}))), 400, 401, 403, 404, 412)
+	deleteLogo["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	getAsset := Object{
+		"summary":     "Get an uploaded logo or its thumbnail",
+		"tags":        []string{"sponsors"},
+		"description": "The URLs are in each sponsor's logo. They never change what they point at, so they can be cached for good.",
+		"security":    noAuth,
+		"parameters":  []Object{{"name": "key", "in": "path", "required": true, "description": "The asset's key, from its URL", "schema": str()}},
+		"responses": Object{"200": Object{
+			"description": "The image",
+			"content": Object{
+				"image/png":  Object{"schema": Object{"type": "string", "format": "binary"}},
+				"image/jpeg": Object{"schema": Object{"type": "string", "format": "binary"}},
+			},
+		}},
+	}
+	addProblems(getAsset["responses"].(Object), 404)
+
+	joinWaitlist := Object{
+		"summary":     "Put a sponsor on a level's waitlist",
+		"tags":        []string{"waitlists"},
+		"requestBody": body("WaitlistRequest"),
+		"responses": Object{"201": response("The new entry, with an offer when the level had room",
+			envelope(Object{"entry": ref("WaitlistEntry")}))},
+	}
+	addProblems(joinWaitlist["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	createReservation := Object{
+		"summary":     "Hold one of a level's spots for a prospect",
+		"tags":        []string{"reservations"},
+		"requestBody": body("ReservationRequest"),
+		"responses": Object{"201": response("The new reservation",
+			envelope(Object{"reservation": ref("Reservation")}))},
+	}
+	addProblems(createReservation["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	convertReservation := Object{
+		"summary":     "Turn a reservation into a sponsor on its level",
+		"tags":        []string{"reservations"},
+		"requestBody": Object{"required": false, "content": jsonContent(ref("ConvertReservationRequest"))},
+		"responses": Object{"201": withETag(response("The converted reservation and the new sponsor",
+			envelope(Object{"reservation": ref("Reservation"), "sponsor": ref("Sponsor")})))},
+	}
+	addProblems(convertReservation["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	createInvoice := Object{
+		"summary":     "Draft an invoice for a sponsor's level, with any add-ons",
+		"tags":        []string{"invoices"},
+		"requestBody": Object{"required": false, "content": jsonContent(ref("InvoiceRequest"))},
+		"responses": Object{"201": withETag(response("The draft invoice",
+			envelope(Object{"invoice": ref("Invoice")})))},
+	}
+	addProblems(createInvoice["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	pdf := Object{"application/pdf": Object{"schema": Object{"type": "string", "format": "binary"}}}
+	invoiceJSON := withETag(response("The invoice", envelope(Object{"invoice": ref("Invoice")})))
+	invoiceJSON["content"].(Object)["application/pdf"] = pdf["application/pdf"]
+	getInvoice := operation("Get an invoice, as a PDF when the client accepts application/pdf", "invoices", invoiceJSON, 400, 401, 403, 404)
+	getInvoice["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getInvoice["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	getInvoicePDF := operation("Get an invoice as a PDF", "invoices", withETag(Object{"description": "The invoice", "content": pdf}), 400, 401, 403, 404)
+	getInvoicePDF["parameters"] = []Object{{"$ref": "#/components/parameters/IfNoneMatch"}}
+	getInvoicePDF["responses"].(Object)["304"] = withETag(Object{"description": "The client already has this version"})
+
+	changeInvoiceStatus := withBody(operation("Issue, pay or void an invoice, moving the sponsor along with it", "invoices",
+		withETag(response("The invoice", envelope(Object{"invoice": ref("Invoice")}))), 400, 401, 403, 404, 409, 412, 422), "InvoiceStatusRequest")
+	changeInvoiceStatus["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	issueBadge := Object{
+		"summary": "Issue a member their badge, out of their sponsor's free badges",
+		"tags":    []string{"badges"},
+		"responses": Object{"201": withETag(response("The new badge",
+			envelope(Object{"badge": ref("Badge")})))},
+	}
+	addProblems(issueBadge["responses"].(Object), 400, 401, 403, 404, 409)
+
+	getBadgeSheet := operation("Get an event's badges as an A4 PDF to print, six to a page", "badges",
+		Object{"description": "The badges", "content": pdf}, 400, 401, 403, 404)
+	getBadgeQRCode := operation("Get a badge's QR code as a PNG", "badges", Object{"description": "The QR code",
+		"content": Object{"image/png": Object{"schema": Object{"type": "string", "format": "binary"}}}}, 400, 401, 403, 404)
+	getBadgeQRCode["parameters"] = []Object{{"name": "size", "in": "query", "required": false,
+		"description": "Width and height in pixels, from 64 to 1024, 256 when not given", "schema": integer()}}
+
+	changeBadgeStatus := withBody(operation("Mark a badge printed, or revoke it", "badges",
+		withETag(response("The badge", envelope(Object{"badge": ref("Badge")}))), 400, 401, 403, 404, 409, 412, 422), "BadgeStatusRequest")
+	changeBadgeStatus["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	createBenefit := Object{
+		"summary":     "Add a benefit to a level, giving each of its sponsors a deliverable for it",
+		"tags":        []string{"benefits"},
+		"requestBody": body("BenefitRequest"),
+		"responses": Object{"201": response("The new benefit, and how many sponsors got a deliverable for it",
+			envelope(Object{"benefit": ref("Benefit"), "sponsors": integer()}))},
+	}
+	addProblems(createBenefit["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	patchDeliverable := withBody(operation("Change a deliverable's status, owner, due date or notes", "benefits",
+		withETag(response("The deliverable", envelope(Object{"deliverable": ref("Deliverable")}))), 400, 401, 403, 404, 412, 422), "PatchDeliverableRequest")
+	patchDeliverable["parameters"] = []Object{{"$ref": "#/components/parameters/IfMatch"}}
+
+	recordPayment := Object{
+		"summary":     "Record a payment, refund or credit note, and send sponsor.payment.received for payments",
+		"tags":        []string{"payments"},
+		"requestBody": body("PaymentRequest"),
+		"responses": Object{"201": response("The ledger entry, the sponsor's balance, and the invoice when it was against one",
+			object([]string{"success", "data"}, Object{
+				"success": boolean(),
+				"data":    object([]string{"payment", "balance"}, Object{"payment": ref("Payment"), "balance": ref("Balance"), "invoice": ref("Invoice")}),
+			}))},
+	}
+	addProblems(recordPayment["responses"].(Object), 400, 401, 403, 404, 409, 422)
+
+	receiveWebhook := Object{
+		"summary": "Take a payment provider callback, recording succeeded payments and refunds against invoices",
+		"tags":    []string{"payments"},
+		"description": "Signed by the provider in the " + auth.WebhookSignatureHeader + " header. Each event is handled " +
+			"once, the provider's retries get back what happened the first time.",
+		"security":    []Object{{"webhookSignature": []string{}}},
+		"requestBody": body("ProviderEvent"),
+		"responses":   Object{"200": response("What became of the event", envelope(Object{"webhookEvent": ref("WebhookEvent")}))},
+	}
+	addProblems(receiveWebhook["responses"].(Object), 400, 401, 409, 501)
+
+	return Object{
+		"/healthz": Object{"get": withSecurity(operation("Liveness check", "health", response("The process is up", ref("Health"))), noAuth)},
+		"/readyz": Object{"get": Object{
+			"summary":   "Readiness check",
+			"tags":      []string{"health"},
+			"security":  noAuth,
+			"responses": readyResponses,
+		}},
+		"/metrics": Object{"get": Object{
+			"summary":  "Prometheus metrics",
+			"tags":     []string{"health"},
+			"security": noAuth,
+			"responses": Object{"200": Object{
+				"description": "Metrics in the Prometheus text format",
+				"content":     Object{"text/plain": Object{"schema": str()}},
+			}},
+		}},
+		"/openapi.json": Object{"get": withSecurity(operation("This document", "health",
+			response("OpenAPI 3 document", Object{"type": "object", "additionalProperties": true})), noAuth)},
+
+		v1 + "/events": Object{
+			"get": operation("List every event", "events", response("Every event",
+				envelope(Object{"events": array(ref("Event"))})), 401, 403),
+		},
+		v1 + "/event": Object{
+			"post": withBody(operation("Create an event", "events", withETag(response("The new event",
+				envelope(Object{"event": ref("Event")}))), 400, 401, 403, 422), "EventRequest"),
+		},
+		v1 + "/event/{id}": Object{
+			"parameters": []Object{pathParam("id", "Our ID of the event")},
+			"get":        getEvent,
+			"patch":      patchEvent,
+		},
+		v1 + "/event/{event_id}/level": Object{
+			"parameters": []Object{eventId},
+			"post": withBody(operation("Create a sponsorship level", "events", withETag(response("The new level",
+				envelope(Object{"level": ref("Level")}))), 400, 401, 403, 404, 409, 422), "LevelRequest"),
+		},
+		v1 + "/event/{event_id}/level/{level_id}": Object{
+			"parameters": []Object{eventId, levelId},
+			"get":        getLevel,
+			"patch":      patchLevel,
+		},
+		v1 + "/event/{event_id}/sponsor": Object{
+			"parameters": []Object{eventId},
+			"post": withBody(operation("Create a sponsor, with an existing or new level", "sponsors", withETag(response("The new sponsor",
+				envelope(Object{"sponsor": ref("Sponsor")}))), 400, 401, 403, 404, 409, 422), "SponsorRequest"),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get":        getSponsor,
+			"patch":      patchSponsor,
+			"delete":     deleteSponsor,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/logo": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"post":       uploadLogo,
+			"delete":     deleteLogo,
+		},
+		v1 + "/assets/{key}": Object{"get": getAsset},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/status": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get": operation("Get a sponsor's status, where it can go next, and its history", "sponsors", response("The status",
+				envelope(Object{"status": enum(db.SponsorStatuses...), "next": array(enum(db.SponsorStatuses...)), "history": array(ref("StatusChange"))})),
+				400, 401, 403, 404),
+			"post": changeStatus,
+		},
+		v1 + "/event/{event_id}/level/{level_id}/waitlist": Object{
+			"parameters": []Object{eventId, levelId},
+			"get": operation("List a level's waitlist, first in line first", "waitlists", response("The waitlist",
+				envelope(Object{"waitlist": array(ref("WaitlistEntry"))})), 400, 401, 403, 404, 422),
+			"post": joinWaitlist,
+		},
+		v1 + "/event/{event_id}/level/{level_id}/waitlist/{entry_id}": Object{
+			"parameters": []Object{eventId, levelId, entryId},
+			"delete": operation("Take a sponsor off a waitlist, passing on any offer it had", "waitlists", response("The withdrawn entry",
+				envelope(Object{"entry": ref("WaitlistEntry")})), 400, 401, 403, 404, 422),
+		},
+		v1 + "/event/{event_id}/level/{level_id}/waitlist/{entry_id}/accept": Object{
+			"parameters": []Object{eventId, levelId, entryId},
+			"post": operation("Accept an offered spot, moving the sponsor onto the level", "waitlists", withETag(response("The accepted entry and the sponsor",
+				envelope(Object{"entry": ref("WaitlistEntry"), "sponsor": ref("Sponsor")}))), 400, 401, 403, 404, 409, 422),
+		},
+		v1 + "/event/{event_id}/level/{level_id}/reservation": Object{
+			"parameters": []Object{eventId, levelId},
+			"get": operation("List the reservations holding a level's spots", "reservations", response("The held reservations",
+				envelope(Object{"reservations": array(ref("Reservation"))})), 400, 401, 403, 404, 422),
+			"post": createReservation,
+		},
+		v1 + "/event/{event_id}/level/{level_id}/reservation/{reservation_id}": Object{
+			"parameters": []Object{eventId, levelId, reservationId},
+			"delete": operation("Give a reserved spot back", "reservations", response("The released reservation",
+				envelope(Object{"reservation": ref("Reservation")})), 400, 401, 403, 404, 422),
+		},
+		v1 + "/event/{event_id}/level/{level_id}/reservation/{reservation_id}/convert": Object{
+			"parameters": []Object{eventId, levelId, reservationId},
+			"post":       convertReservation,
+		},
+		v1 + "/event/{event_id}/level/{level_id}/benefit": Object{
+			"parameters": []Object{eventId, levelId},
+			"get": operation("List what a level gives its sponsors", "benefits", response("The level's benefits",
+				envelope(Object{"benefits": array(ref("Benefit"))})), 400, 401, 403, 404, 422),
+			"post": createBenefit,
+		},
+		v1 + "/event/{event_id}/level/{level_id}/benefit/{benefit_id}": Object{
+			"parameters": []Object{eventId, levelId, pathParam("benefit_id", "ID of a benefit of the level")},
+			"delete": operation("Take a benefit off a level, sponsors keep the deliverables for it that were started", "benefits",
+				response("The removed benefit", envelope(Object{"benefit": ref("Benefit")})), 400, 401, 403, 404, 422),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/deliverable": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get": operation("List a sponsor's deliverables, soonest due first", "benefits", response("The deliverables",
+				envelope(Object{"deliverables": array(ref("Deliverable"))})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/deliverable/{deliverable_id}": Object{
+			"parameters": []Object{eventId, sponsorId, pathParam("deliverable_id", "ID of a deliverable of the sponsor")},
+			"patch":      patchDeliverable,
+		},
+		v1 + "/event/{event_id}/deliverable": Object{
+			"parameters": []Object{eventId,
+				{"name": "overdue", "in": "query", "required": false, "description": "Only deliverables past their due date that aren't delivered or waived", "schema": Object{"type": "boolean"}},
+				{"name": "status", "in": "query", "required": false, "description": "Only deliverables with this status", "schema": enum(db.DeliverableStatuses...)},
+				{"name": "owner", "in": "query", "required": false, "description": "Only deliverables this person owns", "schema": str()}},
+			"get": operation("List the deliverables of an event's sponsors, soonest due first", "benefits", response("The deliverables",
+				envelope(Object{"deliverables": array(ref("Deliverable"))})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/invoice": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"post":       createInvoice,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/payment": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"post":       recordPayment,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/ledger": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get": operation("Get a sponsor's ledger, oldest first, and what it still owes", "payments", response("The ledger",
+				envelope(Object{"payments": array(ref("Payment")), "balance": ref("Balance")})), 400, 401, 403, 404),
+		},
+		v1 + "/webhooks/payments": Object{"post": receiveWebhook},
+		v1 + "/event/{event_id}/report": Object{
+			"parameters": []Object{eventId},
+			"get": operation("Get what each of an event's sponsors was billed, has paid and still owes", "payments", response("The report",
+				envelope(Object{
+					"eventId":  integer(),
+					"name":     str(),
+					"sponsors": array(ref("SponsorReport")),
+					"byStatus": Object{"type": "object", "additionalProperties": integer()},
+					"totals":   ref("Balance"),
+				})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/invoice": Object{
+			"parameters": []Object{eventId,
+				{"name": "sponsorId", "in": "query", "required": false, "description": "Only this sponsor's invoices", "schema": integer()},
+				{"name": "status", "in": "query", "required": false, "description": "Only invoices with this status", "schema": enum(db.InvoiceStatuses...)}},
+			"get": operation("List an event's invoices, oldest first", "invoices", response("The invoices",
+				envelope(Object{"invoices": array(ref("Invoice"))})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/invoice/{invoice_id}": Object{
+			"parameters": []Object{eventId, invoiceId},
+			"get":        getInvoice,
+		},
+		v1 + "/event/{event_id}/invoice/{invoice_id}/pdf": Object{
+			"parameters": []Object{eventId, invoiceId},
+			"get":        getInvoicePDF,
+		},
+		v1 + "/event/{event_id}/invoice/{invoice_id}/status": Object{
+			"parameters": []Object{eventId, invoiceId},
+			"post":       changeInvoiceStatus,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/member": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get": operation("List the members of a sponsor", "members", response("The sponsor's members",
+				envelope(Object{"members": array(ref("Member"))})), 400, 401, 403, 404),
+			"post": withBody(operation("Add a member to a sponsor", "members", withETag(response("The new member",
+				envelope(Object{"member": ref("Member")}))), 400, 401, 403, 404, 409, 422), "MemberRequest"),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}": Object{
+			"parameters": []Object{eventId, sponsorId, pathParam("member_id", "ID of the member")},
+			"get":        getMember,
+			"patch":      patchMember,
+			"delete":     removeMember,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}/badge": Object{
+			"parameters": []Object{eventId, sponsorId, pathParam("member_id", "ID of the member")},
+			"post":       issueBadge,
+		},
+		v1 + "/event/{event_id}/badge": Object{
+			"parameters": badgeFilters,
+			"get": operation("List an event's badges, sponsor by sponsor", "badges", response("The badges",
+				envelope(Object{"badges": array(ref("Badge"))})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/badge/sheet": Object{
+			"parameters": badgeFilters,
+			"get":        getBadgeSheet,
+		},
+		v1 + "/event/{event_id}/badge/{badge_id}/png": Object{
+			"parameters": []Object{eventId, badgeId},
+			"get":        getBadgeQRCode,
+		},
+		v1 + "/event/{event_id}/badge/{badge_id}/status": Object{
+			"parameters": []Object{eventId, badgeId},
+			"post":       changeBadgeStatus,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/invites": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"post":       createInvite,
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/invites/{invite_id}": Object{
+			"parameters": []Object{eventId, sponsorId, pathParam("invite_id", "ID of the invite")},
+			"delete": operation("Revoke an invite link", "invites", response("The revoked invite",
+				envelope(Object{"invite": ref("Invite")})), 400, 401, 403, 404),
+		},
+		v1 + "/event/{event_id}/sponsor/{sponsor_id}/audit": Object{
+			"parameters": []Object{eventId, sponsorId},
+			"get": operation("The last 100 things done to a sponsor, newest first", "invites", response("Audit entries",
+				envelope(Object{"audit": array(ref("AuditEntry"))})), 400, 401, 403, 404),
+		},
+
+		v1 + "/portal/members": Object{
+			"get": withSecurity(operation("List the team and its free badges", "portal", response("The team",
+				envelope(Object{"sponsor": ref("Sponsor"), "members": array(ref("Member")), "allowance": ref("Allowance")})), 401, 403), invite),
+			"post": createPortalMember,
+		},
+		v1 + "/portal/members/{member_id}": Object{
+			"parameters": []Object{pathParam("member_id", "ID of a member of the team")},
+			"delete": withSecurity(operation("Remove a member from the team", "portal", response("The removed member",
+				envelope(Object{"member": ref("Member")})), 400, 401, 403, 404), invite),
+		},
+
+		v1 + "/admin/api-keys": Object{
+			"get": operation("List every API key, revoked ones too", "admin", response("Every API key",
+				envelope(Object{"apiKeys": array(ref("APIKey"))})), 401, 403),
+			"post": withBody(operation("Create an API key", "admin", response("The new key, it's only ever sent here",
+				envelope(Object{"apiKey": ref("APIKey"), "key": str()})), 400, 401, 403, 404, 422), "APIKeyRequest"),
+		},
+		v1 + "/admin/api-keys/{id}": Object{
+			"parameters": []Object{pathParam("id", "ID of the API key")},
+			"delete": operation("Revoke an API key", "admin", response("The revoked key",
+				envelope(Object{"apiKey": ref("APIKey")})), 400, 401, 403, 404),
+		},
+	}
+}
+
+// Every POST takes an Idempotency-Key, and can clash with an earlier request
+// using it. Webhooks don't, the provider's event ID does that job.
+func addIdempotencyKey(paths Object) {
+	for path, item := range paths {
+		post, ok := item.(Object)["post"].(Object)
+		if !ok || strings.HasPrefix(path, "/sponsor-service/v1/webhooks/") {
+			continue
+		}
+		params, _ := post["parameters"].([]Object)
+		post["parameters"] = append(params, Object{"$ref": "#/components/parameters/IdempotencyKey"})
+		addProblems(post["responses"].(Object), http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func parameters() Object {
+	return Object{
+		"IdempotencyKey": Object{
+			"name":     idempotency.Header,
+			"in":       "header",
+			"required": false,
+			"description": "Makes retries safe. The first response is stored, and a retry with the same key " +
+				"gets it back with an Idempotent-Replayed: true header.",
+			"schema": Object{"type": "string", "minLength": 1, "maxLength": idempotency.MaxKeyLength},
+		},
+		"IfMatch": Object{
+			"name":        "If-Match",
+			"in":          "header",
+			"required":    false,
+			"description": "Only make the change while the ETag is still this one, a 412 otherwise",
+			"schema":      str(),
+		},
+		"IfNoneMatch": Object{
+			"name":        "If-None-Match",
+			"in":          "header",
+			"required":    false,
+			"description": "Get a 304 with no body when the ETag is still this one",
+			"schema":      str(),
+		},
+	}
+}
+
+func headers() Object {
+	return Object{
+		"ETag": Object{
+			"description": "Changes with every change to the resource, send it back in If-Match or If-None-Match",
+			"schema":      str(),
+		},
+	}
+}
+
+// Document is the whole OpenAPI 3 document
+func Document() Object {
+	p := paths()
+	addIdempotencyKey(p)
+	return Object{
+		"openapi": "3.0.3",
+		"info": Object{
+			"title":       "Sponsor service",
+			"version":     Version,
+			"description": "Sponsors, their levels and team members for events. See docs/REST_API.md for more.",
+		},
+		// API keys or JWTs everywhere, unless an operation says otherwise
+		"security": []Object{{"apiKey": []string{}}, {"apiKeyHeader": []string{}}, {"bearer": []string{}}},
+		"paths":    p,
+		"components": Object{
+			"schemas":    schemas(),
+			"responses":  responses(),
+			"parameters": parameters(),
+			"headers":    headers(),
+			"securitySchemes": Object{
+				"apiKey":       Object{"type": "apiKey", "in": "header", "name": auth.APIKeyHeader},
+				"apiKeyHeader": Object{"type": "apiKey", "in": "header", "name": "Authorization", "description": "ApiKey <key>"},
+				"bearer":       Object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"inviteToken":  Object{"type": "apiKey", "in": "query", "name": auth.InviteTokenParam},
+				"inviteHeader": Object{"type": "apiKey", "in": "header", "name": "Authorization", "description": "Invite <token>"},
+				"webhookSignature": Object{"type": "apiKey", "in": "header", "name": auth.WebhookSignatureHeader,
+					"description": "t=<unix seconds>,v1=<hex HMAC-SHA256 of \"<t>.<body>\" with the webhook secret>"},
+			},
+		},
+	}
+}
+
+// Handler serves the document as JSON
+func Handler() http.Handler {
+	data, err := json.MarshalIndent(Document(), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
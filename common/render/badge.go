@@ -0,0 +1,100 @@
+package render
+
+import (
+	"bytes"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/skip2/go-qrcode"
+	"io"
+)
+
+// BadgeQR makes a PNG of the QR code for a badge's code, size pixels square.
+// It's what's scanned at the door, and can go on a badge printed elsewhere.
+func BadgeQR(code string, size int) ([]byte, error) {
+	return qrcode.Encode(code, qrcode.Medium, size)
+}
+
+// Badges are 95x80mm, six to an A4 page with room around them for the
+// printer's margins. Dashed lines show where to cut.
+const (
+	badgeWidth   = 95.0
+	badgeHeight  = 80.0
+	badgeColumns = 2
+	badgeRows    = 3
+	sheetLeft    = 10.0
+	sheetTop     = 28.5
+)
+
+// BadgeSheetPDF writes an A4 PDF of badges for eventName, six to a page.
+// Each has the member's name, their sponsor, a band with the level label,
+// and the QR code of its code.
+func BadgeSheetPDF(w io.Writer, eventName string, badges []db.Badge) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Badges for "+eventName, true)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	if len(badges) == 0 {
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "", 12)
+		pdf.CellFormat(0, 10, "No badges to print", "", 1, "L", false, 0, "")
+		return pdf.Output(w)
+	}
+
+	perPage := badgeColumns * badgeRows
+	for i, b := range badges {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+		x := sheetLeft + float64(i%badgeColumns)*badgeWidth
+		y := sheetTop + float64(i%perPage/badgeColumns)*badgeHeight
+		qr, err := BadgeQR(b.Code, 256)
+		if err != nil {
+			return err
+		}
+		badge(pdf, tr, x, y, eventName, b, qr)
+	}
+	return pdf.Output(w)
+}
+
+// Draws one badge with its top left corner at x, y
+func badge(pdf *gofpdf.Fpdf, tr func(string) string, x float64, y float64, eventName string, b db.Badge, qr []byte) {
+	pdf.SetDrawColor(160, 160, 160)
+	pdf.SetDashPattern([]float64{1, 1}, 0)
+	pdf.Rect(x, y, badgeWidth, badgeHeight, "D")
+	pdf.SetDashPattern([]float64{}, 0)
+
+	pdf.SetTextColor(90, 90, 90)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetXY(x+5, y+5)
+	pdf.CellFormat(55, 5, tr(eventName), "", 0, "L", false, 0, "")
+
+	// Long names are shrunk until they fit next to the QR code
+	pdf.SetTextColor(0, 0, 0)
+	name := tr(b.Name)
+	size := 20.0
+	pdf.SetFont("Helvetica", "B", size)
+	for size > 9 && pdf.GetStringWidth(name) > 55 {
+		size--
+		pdf.SetFont("Helvetica", "B", size)
+	}
+	pdf.SetXY(x+5, y+18)
+	pdf.CellFormat(55, 10, name, "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.SetXY(x+5, y+30)
+	pdf.CellFormat(55, 7, tr(b.SponsorName), "", 0, "L", false, 0, "")
+
+	key := "qr-" + b.Code
+	pdf.RegisterImageOptionsReader(key, gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+	pdf.ImageOptions(key, x+63, y+6, 28, 28, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetFont("Courier", "", 7)
+	pdf.SetXY(x+60, y+35)
+	pdf.CellFormat(34, 4, b.Code, "", 0, "C", false, 0, "")
+
+	pdf.SetFillColor(40, 40, 40)
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.SetXY(x, y+badgeHeight-18)
+	pdf.CellFormat(badgeWidth, 18, tr(b.LevelLabel), "", 0, "C", true, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+}
@@ -0,0 +1,132 @@
+// Package render turns records into documents people print or send, like
+// invoice PDFs and badge sheets, and uploaded images into thumbnails
+package render
+
+import (
+	"fmt"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Amount formats an amount in the currency's minor unit, like USD 250,000.00
+// or JPY 1,500
+func Amount(minor int64, currency string) string {
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	digits := db.MinorUnitDigits(currency)
+	scale := int64(math.Pow10(digits))
+	whole := strconv.FormatInt(minor/scale, 10)
+	// Thousands separators, from the right
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+	grouped = append([]string{whole}, grouped...)
+	if digits == 0 {
+		return fmt.Sprintf("%s %s%s", currency, sign, strings.Join(grouped, ","))
+	}
+	return fmt.Sprintf("%s %s%s.%0*d", currency, sign, strings.Join(grouped, ","), digits, minor%scale)
+}
+
+// Percent formats a rate in hundredths of a percent, like 8.25%
+func Percent(rate int) string {
+	s := strconv.FormatFloat(float64(rate)/100, 'f', -1, 64)
+	return s + "%"
+}
+
+func date(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2 Jan 2006")
+}
+
+// InvoicePDF writes an A4 PDF of the invoice, from issuer to the sponsor
+// it bills, for one of eventName's sponsorships. Drafts are marked as
+// drafts and voided invoices as void, so neither gets paid by mistake.
+func InvoicePDF(w io.Writer, issuer string, eventName string, inv *db.Invoice) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Invoice "+inv.Reference(), true)
+	pdf.SetAuthor(issuer, true)
+	pdf.AddPage()
+	// The core fonts are cp1252, this maps what it can of UTF-8 onto it
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 20)
+	title := "Invoice " + inv.Reference()
+	switch inv.Status {
+	case db.InvoiceDraft:
+		title = "Draft invoice"
+	case db.InvoiceVoid:
+		title += " (void)"
+	}
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(issuer), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(95, 6, "Bill to", "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Details", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	details := [][2]string{
+		{tr(inv.BillTo), "Issued: " + date(inv.IssuedAt)},
+		{tr(eventName), "Due: " + date(inv.DueDate)},
+		{tr(inv.LevelName + " sponsor"), "Status: " + inv.Status},
+	}
+	for _, d := range details {
+		pdf.CellFormat(95, 6, d[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, d[1], "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	widths := []float64{100, 20, 35, 35}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetFillColor(230, 230, 230)
+	for i, h := range []string{"Description", "Qty", "Unit price", "Amount"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 8, h, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 11)
+	for _, l := range inv.Lines {
+		pdf.CellFormat(widths[0], 7, tr(l.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, strconv.Itoa(l.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 7, Amount(l.UnitAmount, inv.Currency), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, Amount(l.Amount(), inv.Currency), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	totals := [][2]string{
+		{"Subtotal", Amount(inv.Subtotal, inv.Currency)},
+		{"Tax (" + Percent(inv.TaxRate) + ")", Amount(inv.Tax, inv.Currency)},
+		{"Total", Amount(inv.Total, inv.Currency)},
+	}
+	for i, t := range totals {
+		if i == len(totals)-1 {
+			pdf.SetFont("Helvetica", "B", 11)
+		}
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 7, t[0], "T", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, t[1], "T", 1, "R", false, 0, "")
+	}
+
+	if inv.Notes != "" {
+		pdf.Ln(8)
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 5, tr(inv.Notes), "", "L", false)
+	}
+
+	return pdf.Output(w)
+}
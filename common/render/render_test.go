@@ -0,0 +1,171 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"testing"
+)
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{0, "USD", "USD 0.00"},
+		{5, "USD", "USD 0.05"},
+		{25000000, "USD", "USD 250,000.00"},
+		{-123456, "EUR", "EUR -1,234.56"},
+		{1500, "JPY", "JPY 1,500"},
+		{1234567, "KWD", "KWD 1,234.567"},
+	}
+	for _, tt := range tests {
+		if got := Amount(tt.minor, tt.currency); got != tt.want {
+			t.Errorf("Amount(%d, %s) got %q, want %q", tt.minor, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	for rate, want := range map[int]string{0: "0%", 825: "8.25%", 2000: "20%", 5: "0.05%"} {
+		if got := Percent(rate); got != want {
+			t.Errorf("Percent(%d) got %q, want %q", rate, got, want)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+	}{
+		{"wide", 600, 300, 128, 64},
+		{"tall", 100, 400, 32, 128},
+		{"already fits", 50, 20, 50, 20},
+		{"a line", 1000, 2, 128, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			got := Thumbnail(img, 128).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("got %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestThumbnailAveragesPixels(t *testing.T) {
+	// Black and white stripes a pixel wide come out grey
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	got := Thumbnail(img, 2).NRGBAAt(0, 0)
+	if got.R != 127 || got.A != 255 {
+		t.Errorf("got %v, want grey", got)
+	}
+}
+
+func TestBadgeQR(t *testing.T) {
+	out, err := BadgeQR("ABCD-1234", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Errorf("got %v, want 200px square", b)
+	}
+}
+
+// A PDF page object, /Pages being the list of them
+var pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+
+func pages(t *testing.T, pdf []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Fatalf("got %.20q, want a PDF", pdf)
+	}
+	return len(pdfPage.FindAll(pdf, -1))
+}
+
+func TestBadgeSheetPDF(t *testing.T) {
+	tests := []struct {
+		badges int
+		pages  int
+	}{
+		{0, 1},
+		{1, 1},
+		{6, 1},
+		{7, 2},
+		{13, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d badges", tt.badges), func(t *testing.T) {
+			var badges []db.Badge
+			for i := 0; i < tt.badges; i++ {
+				badges = append(badges, db.Badge{
+					Code:        fmt.Sprintf("CODE-%04d", i),
+					Name:        "Zoë Example",
+					SponsorName: "Doge Corp",
+					LevelLabel:  "Gold",
+				})
+			}
+			var out bytes.Buffer
+			if err := BadgeSheetPDF(&out, "Conf", badges); err != nil {
+				t.Fatal(err)
+			}
+			if got := pages(t, out.Bytes()); got != tt.pages {
+				t.Errorf("got %d pages, want %d", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestInvoicePDF(t *testing.T) {
+	number := 7
+	inv := &db.Invoice{
+		EventID:   1,
+		Number:    &number,
+		Status:    db.InvoiceIssued,
+		BillTo:    "Doge Corp",
+		LevelName: "Gold",
+		Currency:  "USD",
+		TaxRate:   825,
+	}
+	inv.Lines = []db.InvoiceLine{{Kind: db.LineLevel, Description: "Gold sponsorship", Quantity: 1, UnitAmount: 25000000}}
+	var out bytes.Buffer
+	if err := InvoicePDF(&out, "Conf Inc", "Conf", inv); err != nil {
+		t.Fatal(err)
+	}
+	if got := pages(t, out.Bytes()); got != 1 {
+		t.Errorf("got %d pages, want 1", got)
+	}
+
+	// Lines past the bottom of the page go on to the next
+	for i := 0; i < 60; i++ {
+		inv.Lines = append(inv.Lines, db.InvoiceLine{Kind: db.LineAddOn, Description: "Extra booth", Quantity: 1, UnitAmount: 100})
+	}
+	out.Reset()
+	if err := InvoicePDF(&out, "Conf Inc", "Conf", inv); err != nil {
+		t.Fatal(err)
+	}
+	if got := pages(t, out.Bytes()); got < 2 {
+		t.Errorf("got %d pages, want the lines to carry on past the first", got)
+	}
+}
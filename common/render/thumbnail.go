@@ -0,0 +1,55 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Thumbnail shrinks img to fit in a size by size square, keeping its
+// proportions. Each pixel is the average of the ones it covers, which is
+// plenty for logos. Images that already fit are only copied.
+func Thumbnail(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+		if tw < 1 {
+			tw = 1
+		}
+		if th < 1 {
+			th = 1
+		}
+	}
+
+	// Premultiplied, so transparent pixels don't bleed their color
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	out := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					bl += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			c := color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)}
+			out.Set(x, y, c)
+		}
+	}
+	return out
+}
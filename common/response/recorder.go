@@ -0,0 +1,32 @@
+// Package response lets middleware see what the handlers after it wrote
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// Recorder remembers the status code a handler wrote, and a copy of the
+// body when Body is set
+type Recorder struct {
+	http.ResponseWriter
+	Status int
+	Body   *bytes.Buffer
+}
+
+// NewRecorder wraps w. The status is 200 until the handler writes another.
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.Body != nil {
+		r.Body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,150 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKey JSON struct, the key itself is only sent back once, when it's created
+type APIKey struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Admin     bool       `json:"admin"`
+	Role      string     `json:"role"`
+	SponsorId *int       `json:"sponsorId"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+func toAPIKey(k db.APIKey) APIKey {
+	return APIKey{
+		Id:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Admin:     k.Admin,
+		Role:      k.Role,
+		SponsorId: k.SponsorID,
+		CreatedAt: k.CreatedAt,
+		RevokedAt: k.RevokedAt,
+	}
+}
+
+type APIKeyRequest struct {
+	Name      string `json:"name"`
+	Admin     bool   `json:"admin"`
+	Role      string `json:"role"`
+	SponsorId *int   `json:"sponsorId"`
+}
+
+func (k APIKeyRequest) Validate(v *validation.Validator) {
+	if v.Required("name", k.Name) {
+		v.MaxLength("name", k.Name, maxNameLength)
+	}
+	v.OneOf("role", k.Role, auth.RoleOrganizer, auth.RoleSponsorAdmin, auth.RoleFinance)
+	if k.Role == auth.RoleSponsorAdmin {
+		if v.Check(k.SponsorId != nil, "sponsorId", validation.RuleRequired, "is required for sponsor admins") {
+			v.Min("sponsorId", *k.SponsorId, 1)
+		}
+	} else {
+		v.Check(k.SponsorId == nil, "sponsorId", validation.RuleNotAllowed, "is only for sponsor admins")
+	}
+}
+
+// To create an API key
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body APIKeyRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.SponsorId != nil {
+		_, err := db.GetSponsor(r.Context(), *body.SponsorId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(w, r, apierror.New(apierror.SponsorNotFound, "sponsor %d does not exist", *body.SponsorId))
+			return
+		} else if err != nil {
+			sendError(w, r, err)
+			return
+		}
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	result, err := db.CreateAPIKey(r.Context(), body.Name, prefix, hash, body.Admin, body.Role, body.SponsorId)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"apiKey": toAPIKey(*result),
+			// Only time the key is shown, we don't keep it
+			"key": key,
+		},
+	})
+}
+
+// To list all API keys, revoked ones included
+func GetAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	results, err := db.GetAllAPIKeys(r.Context())
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	keys := []APIKey{}
+	for _, k := range results {
+		keys = append(keys, toAPIKey(k))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"apiKeys": keys,
+		},
+	})
+}
+
+// To revoke an API key
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, ok := pathInt(w, r, "id")
+	if !ok {
+		return
+	}
+
+	result, err := db.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendError(w, r, apierror.New(apierror.APIKeyNotFound, "API key %d does not exist", id))
+		return
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"apiKey": toAPIKey(*result),
+		},
+	})
+}
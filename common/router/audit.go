@@ -0,0 +1,131 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"net/http"
+	"time"
+)
+
+// Things we keep an audit trail of
+const (
+	AuditInviteCreated  = "invite.created"
+	AuditInviteRevoked  = "invite.revoked"
+	AuditMembersListed  = "members.listed"
+	AuditMemberAdded    = "member.added"
+	AuditMemberRemoved  = "member.removed"
+	AuditActionRejected = "action.rejected"
+	// A reservation became the sponsor
+	AuditReservationConverted = "reservation.converted"
+	AuditStatusChanged        = "status.changed"
+	AuditInvoiceCreated       = "invoice.created"
+	AuditInvoiceStatusChanged = "invoice.status.changed"
+	AuditPaymentRecorded      = "payment.recorded"
+	AuditDeliverableUpdated   = "deliverable.updated"
+	AuditLogoUploaded         = "logo.uploaded"
+	AuditLogoRemoved          = "logo.removed"
+	AuditBadgeIssued          = "badge.issued"
+	AuditBadgeStatusChanged   = "badge.status.changed"
+)
+
+// How many audit entries GetSponsorAudit sends back
+const auditPageSize = 100
+
+// AuditEntry JSON struct
+type AuditEntry struct {
+	Id            int                    `json:"id"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	Actor         string                 `json:"actor"`
+	Action        string                 `json:"action"`
+	SponsorId     *int                   `json:"sponsorId"`
+	Details       map[string]interface{} `json:"details"`
+	CorrelationId string                 `json:"correlationId"`
+}
+
+// Who made the request, the API key, token subject or invite
+func actor(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Subject
+	}
+	return "anonymous"
+}
+
+// Records that whoever made the request did action to a sponsor. The
+// request has already happened by the time we get here, so a failure
+// is logged rather than sent back.
+func audit(r *http.Request, action string, sponsorId int, details map[string]interface{}) {
+	data, _ := json.Marshal(details)
+	entry := db.AuditEntry{
+		Actor:         actor(r),
+		Action:        action,
+		SponsorID:     &sponsorId,
+		Details:       string(data),
+		CorrelationID: logging.CorrelationID(r.Context()),
+	}
+	if err := db.CreateAuditEntry(r.Context(), &entry); err != nil {
+		logging.FromContext(r.Context()).Error("could not write audit entry", logging.Fields{
+			"action":    action,
+			"sponsorId": sponsorId,
+			"error":     err,
+		})
+	}
+}
+
+func toAuditEntry(e db.AuditEntry) AuditEntry {
+	details := map[string]interface{}{}
+	json.Unmarshal([]byte(e.Details), &details)
+	return AuditEntry{
+		Id:            e.ID,
+		CreatedAt:     e.CreatedAt,
+		Actor:         e.Actor,
+		Action:        e.Action,
+		SponsorId:     e.SponsorID,
+		Details:       details,
+		CorrelationId: e.CorrelationID,
+	}
+}
+
+// To see what's been done to a sponsor, newest first
+func GetSponsorAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ManageSponsors, 0) {
+		return
+	}
+	s, ok := sponsorOfRequest(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetAuditEntriesOfSponsor(r.Context(), s.ID, auditPageSize)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	entries := []AuditEntry{}
+	for _, e := range results {
+		entries = append(entries, toAuditEntry(e))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"audit": entries,
+		},
+	})
+}
+
+// Gets the sponsor from the event_id and sponsor_id path params,
+// sending a 400 or 404 when it can't
+func sponsorOfRequest(w http.ResponseWriter, r *http.Request) (*db.Sponsor, bool) {
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, false
+	}
+	sponsorId, ok := pathInt(w, r, "sponsor_id")
+	if !ok {
+		return nil, false
+	}
+	return getSponsorOfEvent(w, r, sponsorId, eventId)
+}
@@ -0,0 +1,318 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/render"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sizes a badge's QR code PNG can be asked for in, in pixels
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// Badge JSON struct
+type Badge struct {
+	Id         int        `json:"id"`
+	MemberId   int        `json:"memberId"`
+	SponsorId  int        `json:"sponsorId"`
+	Code       string     `json:"code"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Sponsor    string     `json:"sponsor"`
+	LevelLabel string     `json:"levelLabel"`
+	Status     string     `json:"status"`
+	IssuedAt   time.Time  `json:"issuedAt"`
+	PrintedAt  *time.Time `json:"printedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	QRCodeUrl  string     `json:"qrCodeUrl"`
+}
+
+func toBadge(b db.Badge) Badge {
+	return Badge{
+		Id:         b.ID,
+		MemberId:   b.MemberID,
+		SponsorId:  b.SponsorID,
+		Code:       b.Code,
+		Name:       b.Name,
+		Email:      b.Email,
+		Sponsor:    b.SponsorName,
+		LevelLabel: b.LevelLabel,
+		Status:     b.Status,
+		IssuedAt:   b.CreatedAt,
+		PrintedAt:  b.PrintedAt,
+		RevokedAt:  b.RevokedAt,
+		QRCodeUrl:  fmt.Sprintf("%s/sponsor-service/v1/event/%d/badge/%d/png", strings.TrimRight(PublicURL, "/"), b.EventID, b.ID),
+	}
+}
+
+// BadgeStatusRequest is the body to mark a badge printed or revoked
+type BadgeStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func (b BadgeStatusRequest) Validate(v *validation.Validator) {
+	if v.Required("status", b.Status) {
+		v.OneOf("status", b.Status, db.BadgeStatuses...)
+	}
+}
+
+// Gets the badge in the path, sending a 404 when the event has no such badge
+func getBadgeOfEvent(w http.ResponseWriter, r *http.Request, eventId int) (*db.Badge, bool) {
+	badgeId, ok := pathInt(w, r, "badge_id")
+	if !ok {
+		return nil, false
+	}
+	b, err := db.GetBadge(r.Context(), badgeId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && b.EventID != eventId) {
+		sendError(w, r, apierror.New(apierror.BadgeNotFound, "event %d has no badge %d", eventId, badgeId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return b, true
+}
+
+// Checks the caller may read the event, and gets it with the badges the
+// query asks for: ?sponsorId, ?status and ?code narrow them down, and
+// revoked ones are left out unless ?status=revoked
+func eventBadges(w http.ResponseWriter, r *http.Request) (*db.Event, []db.Badge, bool) {
+	if !authorize(w, r, auth.ReadEvents, 0) {
+		return nil, nil, false
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, nil, false
+	}
+	sponsorId, ok := queryInt(w, r, "sponsorId")
+	if !ok {
+		return nil, nil, false
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !validStatus(status, db.BadgeStatuses) {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "status must be one of %s", strings.Join(db.BadgeStatuses, ", ")))
+		return nil, nil, false
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return nil, nil, false
+	}
+	badges, err := db.GetBadges(r.Context(), event.ID, sponsorId, status, r.URL.Query().Get("code"))
+	if err != nil {
+		sendError(w, r, err)
+		return nil, nil, false
+	}
+	return event, badges, true
+}
+
+// Issue a member their badge. It counts against their sponsor's free
+// badges, and is labelled with the sponsor's level.
+func IssueBadge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := memberSponsor(w, r)
+	if !ok {
+		return
+	}
+	memberId, ok := pathInt(w, r, "member_id")
+	if !ok {
+		return
+	}
+	m, ok := getMemberOfSponsor(w, r, memberId, s.ID)
+	if !ok {
+		return
+	}
+	var l *db.Level
+	if s.LevelID != nil {
+		level, err := db.GetLevel(r.Context(), *s.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		l = level
+	}
+	allowance := freeBadges(s, l)
+	if allowance == 0 {
+		sendError(w, r, apierror.New(apierror.BadgeLimitReached, "%s has no free badges, it needs a level and a spot on it", s.Name))
+		return
+	}
+
+	result, err := db.IssueBadge(r.Context(), m, s, l.Name, allowance)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.BadgeExists, "member %d already has a badge, revoke it to issue another", m.ID))
+		return
+	} else if errors.Is(err, db.ErrAllowanceUsed) {
+		sendError(w, r, apierror.New(apierror.BadgeLimitReached, "%s has issued all %d of its free badges", s.Name, allowance))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditBadgeIssued, s.ID, map[string]interface{}{
+		"badgeId":  result.ID,
+		"memberId": m.ID,
+		"code":     result.Code,
+	})
+
+	setETag(w, result.Version)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"badge": toBadge(*result),
+		},
+	})
+}
+
+// List an event's badges, sponsor by sponsor. ?code finds the badge a
+// scanned QR code belongs to.
+func GetBadges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, results, ok := eventBadges(w, r)
+	if !ok {
+		return
+	}
+	badges := []Badge{}
+	for _, b := range results {
+		badges = append(badges, toBadge(b))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"badges": badges,
+		},
+	})
+}
+
+// Get the badges a list would have as an A4 PDF to print and cut out,
+// six to a page. Printing doesn't mark them printed, that's done once
+// they're actually out of the printer.
+func GetBadgeSheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, badges, ok := eventBadges(w, r)
+	if !ok {
+		return
+	}
+	// Rendered into a buffer first, so a failure can still be sent as a problem
+	var buf bytes.Buffer
+	if err := render.BadgeSheetPDF(&buf, event.Name, badges); err != nil {
+		sendError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", pdfContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="badges-%d.pdf"`, event.ID))
+	w.Write(buf.Bytes())
+}
+
+// Get a badge's QR code as a PNG, ?size pixels square, for printing badges
+// some other way
+func GetBadgeQRCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ReadEvents, 0) {
+		return
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
+	size, ok := queryInt(w, r, "size")
+	if !ok {
+		return
+	}
+	if size == 0 {
+		size = defaultQRSize
+	}
+	if size < minQRSize || size > maxQRSize {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "size must be from %d to %d", minQRSize, maxQRSize))
+		return
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return
+	}
+	b, ok := getBadgeOfEvent(w, r, event.ID)
+	if !ok {
+		return
+	}
+	if b.Status == db.BadgeRevoked {
+		sendError(w, r, apierror.New(apierror.BadgeNotFound, "badge %d was revoked", b.ID))
+		return
+	}
+
+	qr, err := render.BadgeQR(b.Code, size)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="badge-%s.png"`, b.Code))
+	w.Write(qr)
+}
+
+// Mark a badge printed, or revoke it so it can't be used to get in
+func ChangeBadgeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return
+	}
+	current, ok := getBadgeOfEvent(w, r, event.ID)
+	if !ok || !authorize(w, r, auth.ManageMembers, current.SponsorID) {
+		return
+	}
+	var body BadgeStatusRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	result, err := db.ChangeBadgeStatus(r.Context(), current.ID, body.Status, version)
+	if errors.Is(err, db.ErrBadgeTransition) {
+		next := strings.Join(db.NextBadgeStatuses(current.Status), ", ")
+		if next == "" {
+			next = "nothing"
+		}
+		sendError(w, r, apierror.New(apierror.InvalidBadgeTransition, "the badge is %s, it can't be %s, it can go to %s", current.Status, body.Status, next))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditBadgeStatusChanged, result.SponsorID, map[string]interface{}{
+		"badgeId": result.ID,
+		"from":    current.Status,
+		"to":      result.Status,
+	})
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"badge": toBadge(*result),
+		},
+	})
+}
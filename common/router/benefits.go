@@ -0,0 +1,404 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Most of one benefit a level can give, like social posts
+const maxBenefitQuantity = 1000
+
+// Benefit JSON struct
+type Benefit struct {
+	Id       int     `json:"id"`
+	LevelId  int     `json:"levelId"`
+	Name     string  `json:"name"`
+	Kind     string  `json:"kind"`
+	Quantity int     `json:"quantity"`
+	Details  string  `json:"details"`
+	DueDate  *string `json:"dueDate"`
+}
+
+func toBenefit(b db.Benefit) Benefit {
+	return Benefit{
+		Id:       b.ID,
+		LevelId:  b.LevelID,
+		Name:     b.Name,
+		Kind:     b.Kind,
+		Quantity: b.Quantity,
+		Details:  b.Details,
+		DueDate:  formatDate(b.DueDate),
+	}
+}
+
+// Deliverable JSON struct
+type Deliverable struct {
+	Id          int        `json:"id"`
+	SponsorId   int        `json:"sponsorId"`
+	BenefitId   int        `json:"benefitId"`
+	Name        string     `json:"name"`
+	Kind        string     `json:"kind"`
+	Quantity    int        `json:"quantity"`
+	Details     string     `json:"details"`
+	Status      string     `json:"status"`
+	Owner       string     `json:"owner"`
+	DueDate     *string    `json:"dueDate"`
+	Overdue     bool       `json:"overdue"`
+	Notes       string     `json:"notes"`
+	DeliveredAt *time.Time `json:"deliveredAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+func toDeliverable(d db.Deliverable, now time.Time) Deliverable {
+	return Deliverable{
+		Id:          d.ID,
+		SponsorId:   d.SponsorID,
+		BenefitId:   d.BenefitID,
+		Name:        d.Name,
+		Kind:        d.Kind,
+		Quantity:    d.Quantity,
+		Details:     d.Details,
+		Status:      d.Status,
+		Owner:       d.Owner,
+		DueDate:     formatDate(d.DueDate),
+		Overdue:     d.Overdue(now),
+		Notes:       d.Notes,
+		DeliveredAt: d.DeliveredAt,
+		UpdatedAt:   d.UpdatedAt,
+	}
+}
+
+func formatDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	date := t.Format(dateLayout)
+	return &date
+}
+
+type BenefitRequest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// 1 when it's not sent
+	Quantity *int   `json:"quantity"`
+	Details  string `json:"details"`
+	DueDate  string `json:"dueDate"`
+}
+
+func (b BenefitRequest) Validate(v *validation.Validator) {
+	if v.Required("name", b.Name) {
+		v.MaxLength("name", b.Name, maxNameLength)
+	}
+	if v.Required("kind", b.Kind) {
+		v.OneOf("kind", b.Kind, db.BenefitKinds...)
+	}
+	if b.Quantity != nil {
+		v.Min("quantity", *b.Quantity, 1)
+		v.Max("quantity", *b.Quantity, maxBenefitQuantity)
+	}
+	v.MaxLength("details", b.Details, maxNoteLength)
+	if b.DueDate != "" {
+		_, err := time.Parse(dateLayout, b.DueDate)
+		v.Check(err == nil, "dueDate", validation.RuleFormat, "must be a date like 2021-06-30")
+	}
+}
+
+// Only what's sent is changed, an empty dueDate takes the due date off
+type PatchDeliverableRequest struct {
+	Status  *string `json:"status"`
+	Owner   *string `json:"owner"`
+	DueDate *string `json:"dueDate"`
+	Notes   *string `json:"notes"`
+}
+
+func (p PatchDeliverableRequest) Validate(v *validation.Validator) {
+	if p.Status != nil {
+		v.OneOf("status", *p.Status, db.DeliverableStatuses...)
+	}
+	if p.Owner != nil {
+		v.MaxLength("owner", *p.Owner, maxNameLength)
+	}
+	if p.DueDate != nil && *p.DueDate != "" {
+		_, err := time.Parse(dateLayout, *p.DueDate)
+		v.Check(err == nil, "dueDate", validation.RuleFormat, "must be a date like 2021-06-30")
+	}
+	if p.Notes != nil {
+		v.MaxLength("notes", *p.Notes, maxNoteLength)
+	}
+}
+
+// Gets the benefit in the path, sending a 404 when the level has no such benefit
+func getBenefitOfLevel(w http.ResponseWriter, r *http.Request, levelId int) (*db.Benefit, bool) {
+	benefitId, ok := pathInt(w, r, "benefit_id")
+	if !ok {
+		return nil, false
+	}
+	b, err := db.GetBenefit(r.Context(), benefitId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && b.LevelID != levelId) {
+		sendError(w, r, apierror.New(apierror.BenefitNotFound, "level %d has no benefit %d", levelId, benefitId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return b, true
+}
+
+// Gets the deliverable in the path, sending a 404 when the sponsor has no such deliverable
+func getDeliverableOfSponsor(w http.ResponseWriter, r *http.Request, sponsorId int) (*db.Deliverable, bool) {
+	deliverableId, ok := pathInt(w, r, "deliverable_id")
+	if !ok {
+		return nil, false
+	}
+	d, err := db.GetDeliverable(r.Context(), deliverableId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && d.SponsorID != sponsorId) {
+		sendError(w, r, apierror.New(apierror.DeliverableNotFound, "sponsor %d has no deliverable %d", sponsorId, deliverableId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return d, true
+}
+
+func sendDeliverables(w http.ResponseWriter, results []db.Deliverable) {
+	now := time.Now()
+	deliverables := []Deliverable{}
+	for _, d := range results {
+		deliverables = append(deliverables, toDeliverable(d, now))
+	}
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"deliverables": deliverables,
+		},
+	})
+}
+
+// List what a level gives its sponsors
+func GetBenefits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := levelFor(w, r, auth.ReadEvents)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetBenefits(r.Context(), l.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	benefits := []Benefit{}
+	for _, b := range results {
+		benefits = append(benefits, toBenefit(b))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"benefits": benefits,
+		},
+	})
+}
+
+// Add a benefit to a level, giving every sponsor on it a deliverable for it
+func CreateBenefit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, l, ok := levelFor(w, r, auth.ManageEvents)
+	if !ok {
+		return
+	}
+	var body BenefitRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	benefit := db.Benefit{
+		EventID:  event.ID,
+		LevelID:  l.ID,
+		Name:     body.Name,
+		Kind:     body.Kind,
+		Quantity: 1,
+		Details:  body.Details,
+	}
+	if body.Quantity != nil {
+		benefit.Quantity = *body.Quantity
+	}
+	if body.DueDate != "" {
+		due, _ := time.Parse(dateLayout, body.DueDate)
+		benefit.DueDate = &due
+	}
+
+	result, given, err := db.CreateBenefit(r.Context(), benefit)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.BenefitExists, "%s already has a benefit called %s", l.Name, body.Name))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"benefit":  toBenefit(*result),
+			"sponsors": given,
+		},
+	})
+}
+
+// Take a benefit off a level. Sponsors keep the deliverables for it that
+// were already started.
+func DeleteBenefit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := levelFor(w, r, auth.ManageEvents)
+	if !ok {
+		return
+	}
+	b, ok := getBenefitOfLevel(w, r, l.ID)
+	if !ok {
+		return
+	}
+
+	result, err := db.DeleteBenefit(r.Context(), b.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"benefit": toBenefit(*result),
+		},
+	})
+}
+
+// List a sponsor's deliverables, soonest due first
+func GetDeliverables(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := sponsorFor(w, r, auth.ReadEvents)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetDeliverables(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	sendDeliverables(w, results)
+}
+
+// List the deliverables of an event's sponsors, soonest due first.
+// ?overdue=true keeps the ones past their due date, and ?status and
+// ?owner narrow them down.
+func GetEventDeliverables(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ReadEvents, 0) {
+		return
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && !validStatus(status, db.DeliverableStatuses) {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "status must be one of %s", strings.Join(db.DeliverableStatuses, ", ")))
+		return
+	}
+	overdue := false
+	switch query.Get("overdue") {
+	case "", "false":
+	case "true":
+		overdue = true
+	default:
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "overdue must be true or false"))
+		return
+	}
+
+	results, err := db.GetEventDeliverables(r.Context(), event.ID, status, query.Get("owner"), overdue, time.Now())
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	sendDeliverables(w, results)
+}
+
+// Change a deliverable's status, owner, due date or notes
+func PatchDeliverable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := sponsorFor(w, r, auth.ManageSponsors)
+	if !ok {
+		return
+	}
+	var patch PatchDeliverableRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+	current, ok := getDeliverableOfSponsor(w, r, s.ID)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	changed := *current
+	if patch.Status != nil {
+		changed.Status = *patch.Status
+	}
+	if patch.Owner != nil {
+		changed.Owner = *patch.Owner
+	}
+	if patch.DueDate != nil {
+		changed.DueDate = nil
+		if *patch.DueDate != "" {
+			due, _ := time.Parse(dateLayout, *patch.DueDate)
+			changed.DueDate = &due
+		}
+	}
+	if patch.Notes != nil {
+		changed.Notes = *patch.Notes
+	}
+
+	result, err := db.UpdateDeliverable(r.Context(), changed, version)
+	if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditDeliverableUpdated, s.ID, map[string]interface{}{
+		"deliverableId": result.ID,
+		"name":          result.Name,
+		"from":          current.Status,
+		"to":            result.Status,
+		"owner":         result.Owner,
+	})
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"deliverable": toDeliverable(*result, time.Now()),
+		},
+	})
+}
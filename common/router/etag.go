@@ -0,0 +1,68 @@
+package router
+
+import (
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"net/http"
+	"strings"
+)
+
+// ETags are the row's version, so they change with every change to it
+func etag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// Whether any of the ETags in an If-Match or If-None-Match header is tag.
+// weak compares them without their W/ prefix, as If-None-Match does.
+func etagMatches(header string, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch sends a 412 when the request has an If-Match that isn't the
+// current version. It returns the version the change has to be made to,
+// or 0 when the request doesn't care.
+func ifMatch(w http.ResponseWriter, r *http.Request, version int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	if !etagMatches(header, etag(version), false) {
+		sendError(w, r, apierror.New(apierror.PreconditionFailed,
+			"it was changed since you got it, get it again and retry (the current ETag is %s)", etag(version)))
+		return 0, false
+	}
+	return version, true
+}
+
+// For when the version changed between checking If-Match and saving
+func changedSince() error {
+	return apierror.New(apierror.PreconditionFailed, "it was changed since you got it, get it again and retry")
+}
+
+// notModified sets the ETag, and sends a 304 when the client already has this version
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	setETag(w, version)
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag(version), true) {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,165 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Signs invite links, main sets this up from the config
+var Invites *auth.Invites
+
+// Where clients reach the service, main sets this from the config
+var PublicURL = "http://localhost:8000"
+
+// Path of the sponsor portal that invite links point at
+const PortalMembersPath = "/sponsor-service/v1/portal/members"
+
+// How long invite links last when the request doesn't say, and at most
+const (
+	defaultInviteLifetime = 7 * 24 * time.Hour
+	maxInviteLifetime     = 30 * 24 * time.Hour
+)
+
+// Invite JSON struct, the token is only sent back once, when it's created
+type Invite struct {
+	Id        int        `json:"id"`
+	SponsorId int        `json:"sponsorId"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func toInvite(i db.Invite) Invite {
+	return Invite{
+		Id:        i.ID,
+		SponsorId: i.SponsorID,
+		ExpiresAt: i.ExpiresAt,
+		RevokedAt: i.RevokedAt,
+		CreatedBy: i.CreatedBy,
+		CreatedAt: i.CreatedAt,
+	}
+}
+
+type InviteRequest struct {
+	// Like 72h, the default lifetime when empty
+	ExpiresIn string `json:"expiresIn"`
+}
+
+func (i InviteRequest) Validate(v *validation.Validator) {
+	if i.ExpiresIn == "" {
+		return
+	}
+	d, err := time.ParseDuration(i.ExpiresIn)
+	v.Check(err == nil && d > 0 && d <= maxInviteLifetime, "expiresIn", validation.RuleFormat,
+		"must be a duration like 72h, up to %.0fh", maxInviteLifetime.Hours())
+}
+
+// Link a sponsor's contact opens to manage their team
+func inviteURL(token string) string {
+	return strings.TrimRight(PublicURL, "/") + PortalMembersPath + "?" + auth.InviteTokenParam + "=" + url.QueryEscape(token)
+}
+
+// To create an invite link for a sponsor's contact
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ManageSponsors, 0) {
+		return
+	}
+	s, ok := sponsorOfRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Body is optional, {"expiresIn": "72h"}
+	var body InviteRequest
+	if !decodeOptionalBody(w, r, &body) {
+		return
+	}
+	lifetime := defaultInviteLifetime
+	if body.ExpiresIn != "" {
+		lifetime, _ = time.ParseDuration(body.ExpiresIn)
+	}
+
+	createdBy := "anonymous"
+	if p := auth.FromContext(r.Context()); p != nil {
+		createdBy = p.Subject
+	}
+	// Signed tokens only carry whole seconds
+	expiresAt := time.Now().Add(lifetime).Truncate(time.Second)
+	invite, err := db.CreateInvite(r.Context(), s.ID, expiresAt, createdBy)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	token, err := Invites.Sign(invite)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditInviteCreated, s.ID, map[string]interface{}{
+		"inviteId":  invite.ID,
+		"expiresAt": invite.ExpiresAt,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invite": toInvite(*invite),
+			// Only time the token is shown
+			"token": token,
+			"url":   inviteURL(token),
+		},
+	})
+}
+
+// To revoke an invite link before it expires
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ManageSponsors, 0) {
+		return
+	}
+	s, ok := sponsorOfRequest(w, r)
+	if !ok {
+		return
+	}
+	inviteId, ok := pathInt(w, r, "invite_id")
+	if !ok {
+		return
+	}
+
+	invite, err := db.GetInvite(r.Context(), inviteId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && invite.SponsorID != s.ID) {
+		sendError(w, r, apierror.New(apierror.InviteNotFound, "sponsor %d has no invite %d", s.ID, inviteId))
+		return
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	invite, err = db.RevokeInvite(r.Context(), inviteId)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditInviteRevoked, s.ID, map[string]interface{}{
+		"inviteId": invite.ID,
+	})
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invite": toInvite(*invite),
+		},
+	})
+}
@@ -0,0 +1,403 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/render"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Invoice settings, main sets these from the config
+var (
+	InvoiceCurrency     = "USD"
+	InvoicePaymentTerms = 30 * 24 * time.Hour
+	InvoiceIssuer       = "Sponsor Service"
+)
+
+const (
+	// Most add-ons one invoice can have
+	maxInvoiceAddOns = 50
+	// Most of one add-on an invoice can bill for
+	maxAddOnQuantity = 10000
+	pdfContentType   = "application/pdf"
+	dateLayout       = "2006-01-02"
+)
+
+// Invoice JSON struct. Amounts are in the currency's minor unit, cents for USD.
+type Invoice struct {
+	Id        int    `json:"id"`
+	EventId   int    `json:"eventId"`
+	SponsorId int    `json:"sponsorId"`
+	Number    *int   `json:"number"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	BillTo    string `json:"billTo"`
+	Level     string `json:"level"`
+	Currency  string `json:"currency"`
+	// Percent, like 8.25
+	TaxRate   float64       `json:"taxRate"`
+	Lines     []InvoiceLine `json:"lines"`
+	Subtotal  int64         `json:"subtotal"`
+	Tax       int64         `json:"tax"`
+	Total     int64         `json:"total"`
+	Notes     string        `json:"notes"`
+	DueDate   *string       `json:"dueDate"`
+	CreatedAt time.Time     `json:"createdAt"`
+	IssuedAt  *time.Time    `json:"issuedAt"`
+	PaidAt    *time.Time    `json:"paidAt"`
+	VoidedAt  *time.Time    `json:"voidedAt"`
+}
+
+type InvoiceLine struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitAmount  int64  `json:"unitAmount"`
+	Amount      int64  `json:"amount"`
+}
+
+func toInvoice(i db.Invoice) Invoice {
+	invoice := Invoice{
+		Id:        i.ID,
+		EventId:   i.EventID,
+		SponsorId: i.SponsorID,
+		Number:    i.Number,
+		Reference: i.Reference(),
+		Status:    i.Status,
+		BillTo:    i.BillTo,
+		Level:     i.LevelName,
+		Currency:  i.Currency,
+		TaxRate:   float64(i.TaxRate) / 100,
+		Lines:     []InvoiceLine{},
+		Subtotal:  i.Subtotal,
+		Tax:       i.Tax,
+		Total:     i.Total,
+		Notes:     i.Notes,
+		CreatedAt: i.CreatedAt,
+		IssuedAt:  i.IssuedAt,
+		PaidAt:    i.PaidAt,
+		VoidedAt:  i.VoidedAt,
+	}
+	if i.DueDate != nil {
+		due := i.DueDate.Format(dateLayout)
+		invoice.DueDate = &due
+	}
+	for _, l := range i.Lines {
+		invoice.Lines = append(invoice.Lines, InvoiceLine{
+			Kind:        l.Kind,
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitAmount:  l.UnitAmount,
+			Amount:      l.Amount(),
+		})
+	}
+	return invoice
+}
+
+// Something billed on top of the level, like an extra booth
+type InvoiceAddOnRequest struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	// In the currency's minor unit
+	UnitAmount int64 `json:"unitAmount"`
+}
+
+// Everything is optional, the level's cost is always billed
+type InvoiceRequest struct {
+	AddOns []InvoiceAddOnRequest `json:"addOns"`
+	// Percent, like 8.25
+	TaxRate float64 `json:"taxRate"`
+	// Like 2021-06-30, the payment terms from when it's issued when empty
+	DueDate string `json:"dueDate"`
+	Notes   string `json:"notes"`
+}
+
+func (i InvoiceRequest) Validate(v *validation.Validator) {
+	v.Check(len(i.AddOns) <= maxInvoiceAddOns, "addOns", validation.RuleMax, "must have at most %d add-ons", maxInvoiceAddOns)
+	for n, a := range i.AddOns {
+		field := fmt.Sprintf("addOns[%d].", n)
+		if v.Required(field+"description", a.Description) {
+			v.MaxLength(field+"description", a.Description, maxNameLength)
+		}
+		if v.Min(field+"quantity", a.Quantity, 1) {
+			v.Max(field+"quantity", a.Quantity, maxAddOnQuantity)
+		}
+		v.Between(field+"unitAmount", a.UnitAmount, 0, db.MaxAmount)
+	}
+	v.Check(i.TaxRate >= 0 && i.TaxRate <= 100 && i.TaxRate*100 == math.Round(i.TaxRate*100), "taxRate", validation.RuleFormat,
+		"must be a percent between 0 and 100, with at most 2 decimals")
+	if i.DueDate != "" {
+		_, err := time.Parse(dateLayout, i.DueDate)
+		v.Check(err == nil, "dueDate", validation.RuleFormat, "must be a date like 2021-06-30")
+	}
+	v.MaxLength("notes", i.Notes, maxNoteLength)
+}
+
+type InvoiceStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func (i InvoiceStatusRequest) Validate(v *validation.Validator) {
+	if v.Required("status", i.Status) {
+		v.OneOf("status", i.Status, db.InvoiceStatuses...)
+	}
+}
+
+// Checks the caller may do action, and gets the invoice and its event from the path
+func eventInvoice(w http.ResponseWriter, r *http.Request, action auth.Action) (*db.Event, *db.Invoice, bool) {
+	if !authorize(w, r, action, 0) {
+		return nil, nil, false
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, nil, false
+	}
+	invoiceId, ok := pathInt(w, r, "invoice_id")
+	if !ok {
+		return nil, nil, false
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return nil, nil, false
+	}
+	invoice, err := db.GetInvoice(r.Context(), invoiceId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && invoice.EventID != event.ID) {
+		sendError(w, r, apierror.New(apierror.InvoiceNotFound, "event %d has no invoice %d", event.ID, invoiceId))
+		return nil, nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, nil, false
+	}
+	return event, invoice, true
+}
+
+// Whether the client asked for a PDF rather than JSON
+func wantsPDF(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), pdfContentType)
+}
+
+// Sends the invoice as a PDF, named after its reference
+func sendInvoicePDF(w http.ResponseWriter, r *http.Request, event *db.Event, invoice *db.Invoice) {
+	// Rendered into a buffer first, so a failure can still be sent as a problem
+	var buf bytes.Buffer
+	if err := render.InvoicePDF(&buf, InvoiceIssuer, event.Name, invoice); err != nil {
+		sendError(w, r, err)
+		return
+	}
+	name := invoice.Reference()
+	if name == "" {
+		name = fmt.Sprintf("draft-%d", invoice.ID)
+	}
+	w.Header().Set("Content-Type", pdfContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, name))
+	w.Write(buf.Bytes())
+}
+
+// List an event's invoices, optionally only a sponsor's or only those with a status
+func GetInvoices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ReadReports, 0) {
+		return
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
+	sponsorId, ok := queryInt(w, r, "sponsorId")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !validStatus(status, db.InvoiceStatuses) {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "status must be one of %s", strings.Join(db.InvoiceStatuses, ", ")))
+		return
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetInvoices(r.Context(), event.ID, sponsorId, status)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	invoices := []Invoice{}
+	for _, i := range results {
+		invoices = append(invoices, toInvoice(i))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invoices": invoices,
+		},
+	})
+}
+
+func validStatus(status string, statuses []string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Draft an invoice for a sponsor's level, with any add-ons
+func CreateInvoice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := sponsorFor(w, r, auth.ManageInvoices)
+	if !ok {
+		return
+	}
+	var body InvoiceRequest
+	if !decodeOptionalBody(w, r, &body) {
+		return
+	}
+
+	var addOns []db.InvoiceLine
+	for _, a := range body.AddOns {
+		addOns = append(addOns, db.InvoiceLine{
+			Description: a.Description,
+			Quantity:    a.Quantity,
+			UnitAmount:  a.UnitAmount,
+		})
+	}
+	var dueDate *time.Time
+	if body.DueDate != "" {
+		due, _ := time.Parse(dateLayout, body.DueDate)
+		dueDate = &due
+	}
+	taxRate := int(math.Round(body.TaxRate * 100))
+
+	result, err := db.CreateInvoice(r.Context(), s.ID, InvoiceCurrency, taxRate, dueDate, body.Notes, addOns)
+	if errors.Is(err, db.ErrNoLevel) {
+		sendError(w, r, apierror.New(apierror.SponsorHasNoLevel, "%s needs a level to be invoiced", s.Name))
+		return
+	} else if errors.Is(err, db.ErrSponsorCancelled) {
+		sendError(w, r, apierror.New(apierror.SponsorCancelled, "%s is cancelled, it can't be invoiced", s.Name))
+		return
+	} else if errors.Is(err, db.ErrLevelNotPriced) {
+		sendError(w, r, apierror.New(apierror.LevelNotPriced, "the %s level's cost isn't an amount in %s like 14500 or 250K, fix it to invoice %s", s.LevelName, InvoiceCurrency, s.Name))
+		return
+	} else if errors.Is(err, db.ErrInvoiceTooLarge) {
+		sendError(w, r, apierror.New(apierror.InvoiceTooLarge, "the invoice's total is too big, bill it as more than one invoice"))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditInvoiceCreated, s.ID, map[string]interface{}{
+		"invoiceId": result.ID,
+		"total":     result.Total,
+		"currency":  result.Currency,
+	})
+
+	setETag(w, result.Version)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invoice": toInvoice(*result),
+		},
+	})
+}
+
+// Get an invoice as JSON, or as a PDF when the client accepts application/pdf
+func GetInvoice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, invoice, ok := eventInvoice(w, r, auth.ReadReports)
+	if !ok {
+		return
+	}
+	if notModified(w, r, invoice.Version) {
+		return
+	}
+	if wantsPDF(r) {
+		sendInvoicePDF(w, r, event, invoice)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invoice": toInvoice(*invoice),
+		},
+	})
+}
+
+// Get an invoice as a PDF, for links that can't set Accept
+func GetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, invoice, ok := eventInvoice(w, r, auth.ReadReports)
+	if !ok {
+		return
+	}
+	if notModified(w, r, invoice.Version) {
+		return
+	}
+	sendInvoicePDF(w, r, event, invoice)
+}
+
+// Issue, pay or void an invoice. The sponsor's status follows it.
+func ChangeInvoiceStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventInvoice(w, r, auth.ManageInvoices)
+	if !ok {
+		return
+	}
+	var body InvoiceStatusRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	result, sponsor, change, err := db.ChangeInvoiceStatus(r.Context(), current.ID, body.Status, actor(r), InvoicePaymentTerms, version)
+	if errors.Is(err, db.ErrInvoiceTransition) {
+		next := strings.Join(db.NextInvoiceStatuses(current.Status), ", ")
+		if next == "" {
+			next = "nothing"
+		}
+		sendError(w, r, apierror.New(apierror.InvalidInvoiceTransition, "the invoice is %s, it can't be %s, it can go to %s", current.Status, body.Status, next))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditInvoiceStatusChanged, result.SponsorID, map[string]interface{}{
+		"invoiceId": result.ID,
+		"reference": result.Reference(),
+		"from":      current.Status,
+		"to":        result.Status,
+	})
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"invoice": toInvoice(*result),
+		},
+	})
+
+	if change != nil {
+		publishStatusChange(r.Context(), event, sponsor, change.From, change.Reason)
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"net/http"
+)
+
+// Get one level of an event
+func GetLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := levelFor(w, r, auth.ReadEvents)
+	if !ok {
+		return
+	}
+	if notModified(w, r, l.Version) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"level": toLevel(*l),
+		},
+	})
+}
+
+// Change one level of an event. Raising its max sponsors offers the new
+// spots to its waitlist.
+func PatchLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := levelFor(w, r, auth.ManageEvents)
+	if !ok {
+		return
+	}
+
+	var patch PatchLevelRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	name, cost := current.Name, current.Cost
+	maxSponsors, maxBadges := current.MaxNumberOfSponsors, current.MaxNumberOfFreeBadges
+	if patch.Name != nil {
+		name = *patch.Name
+	}
+	if patch.Cost != nil {
+		cost = *patch.Cost
+	}
+	if patch.MaxSponsors != nil {
+		maxSponsors = *patch.MaxSponsors
+	}
+	if patch.MaxFreeBadgesPerSponsor != nil {
+		maxBadges = *patch.MaxFreeBadgesPerSponsor
+	}
+
+	result, err := db.UpdateLevel(r.Context(), current.ID, name, cost, maxSponsors, maxBadges, event.ID, version)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.LevelExists, "a level with this name already exists for this event"))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"level": toLevel(*result),
+		},
+	})
+
+	offerWaitlist(r.Context(), result.ID)
+}
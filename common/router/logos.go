@@ -0,0 +1,333 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/blob"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/render"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Where uploaded logos are kept, main sets this up from the config
+var Assets blob.Store
+
+// Biggest logo file we take, in bytes, main sets this from the config
+var MaxLogoSize int64 = 5 << 20
+
+// MaxBodySize is the biggest request body any endpoint takes, a logo upload
+func MaxBodySize() int64 {
+	return MaxLogoSize + multipartOverhead
+}
+
+// Path logos and their thumbnails are served on, anyone can get them
+const AssetsPath = "/sponsor-service/v1/assets/"
+
+const (
+	// Smallest and biggest a logo can be on either side, in pixels
+	minLogoSide = 64
+	maxLogoSide = 5000
+	// Thumbnails fit in a square this big
+	thumbnailSize = 256
+	// Room for the multipart headers around the file
+	multipartOverhead = 64 << 10
+)
+
+// Image types we take as logos, and the extension they're stored with
+var logoTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// Logo JSON struct
+type Logo struct {
+	Url          string     `json:"url"`
+	ThumbnailUrl string     `json:"thumbnailUrl"`
+	ContentType  string     `json:"contentType"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Size         int64      `json:"size"`
+	UploadedAt   *time.Time `json:"uploadedAt"`
+}
+
+// nil when the sponsor has no logo
+func toLogo(l db.Logo) *Logo {
+	if l.Key == "" {
+		return nil
+	}
+	return &Logo{
+		Url:          assetURL(l.Key),
+		ThumbnailUrl: assetURL(l.ThumbnailKey),
+		ContentType:  l.ContentType,
+		Width:        l.Width,
+		Height:       l.Height,
+		Size:         l.Size,
+		UploadedAt:   l.UploadedAt,
+	}
+}
+
+func assetURL(key string) string {
+	return strings.TrimRight(PublicURL, "/") + AssetsPath + key
+}
+
+// Random, so nobody can guess the URL of a logo before it's shown
+func newAssetKey(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + "-" + hex.EncodeToString(b), nil
+}
+
+// Reads the file field of a multipart body, up to one byte past
+// MaxLogoSize so a file that's too big can be told apart
+func readLogoFile(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxLogoSize+multipartOverhead)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "the body must be multipart/form-data, with the logo in a file field"))
+		return nil, false
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			sendError(w, r, apierror.New(apierror.MalformedRequest, "could not read the multipart body | %s", err))
+			return nil, false
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		file, err := ioutil.ReadAll(io.LimitReader(part, MaxLogoSize+1))
+		if err != nil {
+			sendError(w, r, apierror.New(apierror.MalformedRequest, "could not read the file | %s", err))
+			return nil, false
+		}
+		return file, true
+	}
+	sendError(w, r, apierror.Validation(validation.Errors{{
+		Field:   "file",
+		Rule:    validation.RuleRequired,
+		Message: "is required",
+	}}))
+	return nil, false
+}
+
+// Checks an uploaded file is a PNG or JPEG logo we can use, sending a 422
+// when it isn't. It's sniffed rather than trusting the part's Content-Type.
+func checkLogo(w http.ResponseWriter, r *http.Request, file []byte) (image.Image, string, bool) {
+	var v validation.Validator
+	contentType := http.DetectContentType(file)
+	_, known := logoTypes[contentType]
+	switch {
+	case !v.Check(int64(len(file)) <= MaxLogoSize, "file", validation.RuleMax, "must be at most %d bytes", MaxLogoSize):
+	case !v.Check(known, "file", validation.RuleFormat, "must be a PNG or JPEG image"):
+	default:
+		// Only the header, so a huge image is turned down before it's decoded
+		config, _, err := image.DecodeConfig(bytes.NewReader(file))
+		if !v.Check(err == nil, "file", validation.RuleFormat, "is not an image we can read") {
+			break
+		}
+		if !v.Check(config.Width >= minLogoSide && config.Height >= minLogoSide, "file", validation.RuleMin,
+			"must be at least %dx%d pixels, it's %dx%d", minLogoSide, minLogoSide, config.Width, config.Height) {
+			break
+		}
+		if !v.Check(config.Width <= maxLogoSide && config.Height <= maxLogoSide, "file", validation.RuleMax,
+			"must be at most %dx%d pixels, it's %dx%d", maxLogoSide, maxLogoSide, config.Width, config.Height) {
+			break
+		}
+		img, _, err := image.Decode(bytes.NewReader(file))
+		if v.Check(err == nil, "file", validation.RuleFormat, "is not an image we can read") {
+			return img, contentType, true
+		}
+	}
+	sendError(w, r, apierror.Validation(v.Err().(validation.Errors)))
+	return nil, "", false
+}
+
+// Takes a sponsor's old logo out of the blob store once nothing points at it
+func deleteLogoBlobs(ctx context.Context, l db.Logo) {
+	for _, key := range []string{l.Key, l.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := Assets.Delete(ctx, key); err != nil {
+			logging.FromContext(ctx).Error("could not delete an old logo", logging.Fields{"key": key, "error": err})
+		}
+	}
+}
+
+// Upload a sponsor's logo, as the file field of a multipart/form-data
+// body. It replaces the logo the sponsor had, and a thumbnail is made
+// from it.
+func UploadSponsorLogo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+	if Assets == nil {
+		sendError(w, r, apierror.New(apierror.NotImplemented, "logo uploads are turned off"))
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+	file, ok := readLogoFile(w, r)
+	if !ok {
+		return
+	}
+	img, contentType, ok := checkLogo(w, r, file)
+	if !ok {
+		return
+	}
+
+	var thumbnail bytes.Buffer
+	if err := png.Encode(&thumbnail, render.Thumbnail(img, thumbnailSize)); err != nil {
+		sendError(w, r, err)
+		return
+	}
+	key, err := newAssetKey("logo")
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	now := time.Now()
+	logo := db.Logo{
+		Key:          key + logoTypes[contentType],
+		ThumbnailKey: key + "-thumb.png",
+		ContentType:  contentType,
+		Width:        img.Bounds().Dx(),
+		Height:       img.Bounds().Dy(),
+		Size:         int64(len(file)),
+		UploadedAt:   &now,
+	}
+	if err := Assets.Put(r.Context(), logo.Key, bytes.NewReader(file)); err != nil {
+		sendError(w, r, err)
+		return
+	}
+	if err := Assets.Put(r.Context(), logo.ThumbnailKey, &thumbnail); err != nil {
+		deleteLogoBlobs(r.Context(), db.Logo{Key: logo.Key})
+		sendError(w, r, err)
+		return
+	}
+
+	result, previous, err := db.SetSponsorLogo(r.Context(), current.ID, logo, version)
+	if err != nil {
+		deleteLogoBlobs(r.Context(), logo)
+		if errors.Is(err, db.ErrVersionMismatch) {
+			err = changedSince()
+		}
+		sendError(w, r, err)
+		return
+	}
+	deleteLogoBlobs(r.Context(), previous.Logo)
+	audit(r, AuditLogoUploaded, result.ID, map[string]interface{}{
+		"key":         logo.Key,
+		"contentType": logo.ContentType,
+		"size":        logo.Size,
+	})
+	sendSponsorWithLevel(w, r, event, result)
+}
+
+// Take a sponsor's logo away
+func DeleteSponsorLogo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+	if current.Logo.Key == "" {
+		sendError(w, r, apierror.New(apierror.LogoNotFound, "sponsor %d has no logo", current.ID))
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	result, previous, err := db.SetSponsorLogo(r.Context(), current.ID, db.Logo{}, version)
+	if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	if Assets != nil {
+		deleteLogoBlobs(r.Context(), previous.Logo)
+	}
+	audit(r, AuditLogoRemoved, result.ID, map[string]interface{}{
+		"key": previous.Logo.Key,
+	})
+	sendSponsorWithLevel(w, r, event, result)
+}
+
+// Sends a sponsor with its level and ETag, after changing it
+func sendSponsorWithLevel(w http.ResponseWriter, r *http.Request, event *db.Event, s *db.Sponsor) {
+	sponsor := toSponsor(*s, event, Level{})
+	if s.LevelID != nil {
+		l, err := db.GetLevel(r.Context(), *s.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		sponsor.Level = toLevel(*l)
+	}
+	setETag(w, s.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor": sponsor,
+		},
+	})
+}
+
+// Serve a logo or thumbnail. Keys are random and never reused, so they can
+// be cached for good.
+func GetAsset(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if Assets == nil || !blob.ValidKey(key) {
+		sendError(w, r, apierror.New(apierror.AssetNotFound, "there is no asset %q", key))
+		return
+	}
+	file, err := Assets.Get(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		sendError(w, r, apierror.New(apierror.AssetNotFound, "there is no asset %q", key))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, file); err != nil {
+		logging.FromContext(r.Context()).Warn("could not send an asset", logging.Fields{"key": key, "error": err})
+	}
+}
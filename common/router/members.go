@@ -0,0 +1,190 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// Looks up a member of a sponsor, sending a 404 when there's no such
+// member or it's a member of another sponsor
+func getMemberOfSponsor(w http.ResponseWriter, r *http.Request, memberId int, sponsorId int) (*db.Member, bool) {
+	m, err := db.GetMember(r.Context(), memberId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && m.SponsorID != sponsorId) {
+		sendError(w, r, apierror.New(apierror.MemberNotFound, "sponsor %d has no member %d", sponsorId, memberId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return m, true
+}
+
+// Checks the caller may manage the sponsor's members, and gets the sponsor
+// and its event from the path
+func memberSponsor(w http.ResponseWriter, r *http.Request) (*db.Event, *db.Sponsor, bool) {
+	sponsorId, ok := pathInt(w, r, "sponsor_id")
+	if !ok || !authorize(w, r, auth.ManageMembers, sponsorId) {
+		return nil, nil, false
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, nil, false
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return nil, nil, false
+	}
+	s, ok := getSponsorOfEvent(w, r, sponsorId, event.ID)
+	if !ok {
+		return nil, nil, false
+	}
+	return event, s, true
+}
+
+// List the members of a sponsor team
+func GetMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := memberSponsor(w, r)
+	if !ok {
+		return
+	}
+
+	saved, err := db.GetMembersOfSponsor(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	members := []Member{}
+	for _, m := range saved {
+		members = append(members, toMember(m))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"members": members,
+		},
+	})
+}
+
+// Get one member of a sponsor team
+func GetMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := memberSponsor(w, r)
+	if !ok {
+		return
+	}
+	memberId, ok := pathInt(w, r, "member_id")
+	if !ok {
+		return
+	}
+	m, ok := getMemberOfSponsor(w, r, memberId, s.ID)
+	if !ok {
+		return
+	}
+	if notModified(w, r, m.Version) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"member": toMember(*m),
+		},
+	})
+}
+
+// Change a member's name or email, e.g. to fix a typo before badges are printed
+func PatchMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, s, ok := memberSponsor(w, r)
+	if !ok {
+		return
+	}
+	memberId, ok := pathInt(w, r, "member_id")
+	if !ok {
+		return
+	}
+
+	var patch PatchMemberRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+
+	current, ok := getMemberOfSponsor(w, r, memberId, s.ID)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+	name, email := current.Name, current.Email
+	if patch.Name != nil {
+		name = *patch.Name
+	}
+	if patch.Email != nil {
+		email = *patch.Email
+	}
+
+	// Nothing to change, so nothing to tell anyone
+	if name == current.Name && email == current.Email {
+		setETag(w, current.Version)
+		json.NewEncoder(w).Encode(HttpResponseJSON{
+			Success: true,
+			Data: map[string]interface{}{
+				"member": toMember(*current),
+			},
+		})
+		return
+	}
+
+	result, previous, err := db.UpdateMember(r.Context(), memberId, name, email, version)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.MemberExists, "a member with this email already exists for this sponsor"))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	savedMember := toMember(*result)
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"member": savedMember,
+		},
+	})
+
+	levelName := ""
+	if s.LevelID != nil {
+		if l, err := db.GetLevel(r.Context(), *s.LevelID); err == nil {
+			levelName = l.Name
+		}
+	}
+	publish(r.Context(), MemberUpdatedQueue, map[string]interface{}{
+		"id":           savedMember.Id,
+		"eventId":      event.ID,
+		"sponsorId":    savedMember.SponsorId,
+		"name":         savedMember.Name,
+		"email":        savedMember.Email,
+		"organization": s.Name,
+		"eventName":    event.Name,
+		"sponsorLevel": levelName,
+		// What it was before, so badges made with it can be found and fixed
+		"previous": map[string]interface{}{
+			"name":  previous.Name,
+			"email": previous.Email,
+		},
+	}, result.UpdatedAt)
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"net/http"
+	"time"
+)
+
+// Queue to publish payments from sponsors on, main sets this from the config
+var PaymentReceivedQueue = "sponsor.payment.received"
+
+// Longest payment reference we keep, bank references are well under this
+const maxReferenceLength = 100
+
+// Payment JSON struct, an entry in a sponsor's ledger
+type Payment struct {
+	Id         int       `json:"id"`
+	SponsorId  int       `json:"sponsorId"`
+	InvoiceId  *int      `json:"invoiceId"`
+	Kind       string    `json:"kind"`
+	Amount     int64     `json:"amount"`
+	Currency   string    `json:"currency"`
+	Method     string    `json:"method"`
+	Reference  string    `json:"reference"`
+	Note       string    `json:"note"`
+	RecordedBy string    `json:"recordedBy"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func toPayment(p db.Payment) Payment {
+	return Payment{
+		Id:         p.ID,
+		SponsorId:  p.SponsorID,
+		InvoiceId:  p.InvoiceID,
+		Kind:       p.Kind,
+		Amount:     p.Amount,
+		Currency:   p.Currency,
+		Method:     p.Method,
+		Reference:  p.Reference,
+		Note:       p.Note,
+		RecordedBy: p.RecordedBy,
+		ReceivedAt: p.ReceivedAt,
+		CreatedAt:  p.CreatedAt,
+	}
+}
+
+// Balance JSON struct, amounts are in the currency's minor unit
+type Balance struct {
+	Currency    string `json:"currency"`
+	Invoiced    int64  `json:"invoiced"`
+	Paid        int64  `json:"paid"`
+	Credited    int64  `json:"credited"`
+	Outstanding int64  `json:"outstanding"`
+}
+
+func toBalance(b db.Balance) Balance {
+	return Balance{
+		Currency:    InvoiceCurrency,
+		Invoiced:    b.Invoiced,
+		Paid:        b.Paid,
+		Credited:    b.Credited,
+		Outstanding: b.Outstanding(),
+	}
+}
+
+type PaymentRequest struct {
+	// payment when empty
+	Kind string `json:"kind"`
+	// In the currency's minor unit
+	Amount    int64  `json:"amount"`
+	Method    string `json:"method"`
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+	InvoiceId *int   `json:"invoiceId"`
+	// Now when it's not sent
+	ReceivedAt *time.Time `json:"receivedAt"`
+}
+
+func (p PaymentRequest) Validate(v *validation.Validator) {
+	if p.Kind != "" {
+		v.OneOf("kind", p.Kind, db.PaymentKinds...)
+	}
+	v.Check(p.Amount > 0, "amount", validation.RuleMin, "must be more than 0")
+	// Credit notes don't move any money
+	if p.Kind != db.PaymentCreditNote && v.Required("method", p.Method) {
+		v.OneOf("method", p.Method, db.PaymentMethods...)
+	}
+	v.MaxLength("reference", p.Reference, maxReferenceLength)
+	v.MaxLength("note", p.Note, maxNoteLength)
+	if p.InvoiceId != nil {
+		v.Min("invoiceId", *p.InvoiceId, 1)
+	}
+	if p.ReceivedAt != nil {
+		v.Check(!p.ReceivedAt.After(time.Now()), "receivedAt", validation.RuleMax, "can't be in the future")
+	}
+}
+
+// Tells everyone who cares that a sponsor paid us
+func publishPaymentReceived(ctx context.Context, event *db.Event, s *db.Sponsor, p *db.Payment, invoice *db.Invoice, balance db.Balance) {
+	reference := ""
+	if invoice != nil {
+		reference = invoice.Reference()
+	}
+	publish(ctx, PaymentReceivedQueue, map[string]interface{}{
+		"id":               p.ID,
+		"eventId":          event.ID,
+		"eventName":        event.Name,
+		"sponsorId":        s.ID,
+		"organization":     s.Name,
+		"invoiceId":        p.InvoiceID,
+		"invoiceReference": reference,
+		"amount":           p.Amount,
+		"currency":         p.Currency,
+		"method":           p.Method,
+		"reference":        p.Reference,
+		"receivedAt":       p.ReceivedAt,
+		"outstanding":      balance.Outstanding(),
+	}, p.CreatedAt)
+}
+
+// Get a sponsor's ledger, oldest first, and what it still owes
+func GetLedger(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := sponsorFor(w, r, auth.ReadReports)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetPayments(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	balance, err := db.GetBalance(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	payments := []Payment{}
+	for _, p := range results {
+		payments = append(payments, toPayment(p))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"payments": payments,
+			"balance":  toBalance(balance),
+		},
+	})
+}
+
+// Record a payment, refund or credit note for a sponsor. A payment that
+// settles an invoice marks it paid, and sends sponsor.payment.received.
+func RecordPayment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, s, ok := sponsorFor(w, r, auth.ManageInvoices)
+	if !ok {
+		return
+	}
+	var body PaymentRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	payment := db.Payment{
+		SponsorID:  s.ID,
+		InvoiceID:  body.InvoiceId,
+		Kind:       body.Kind,
+		Amount:     body.Amount,
+		Currency:   InvoiceCurrency,
+		Method:     body.Method,
+		Reference:  body.Reference,
+		Note:       body.Note,
+		RecordedBy: actor(r),
+		ReceivedAt: time.Now(),
+	}
+	if payment.Kind == "" {
+		payment.Kind = db.PaymentReceived
+	}
+	if body.ReceivedAt != nil {
+		payment.ReceivedAt = *body.ReceivedAt
+	}
+
+	result, invoice, sponsor, change, err := db.RecordPayment(r.Context(), payment)
+	if errors.Is(err, db.ErrInvoiceNotPayable) {
+		sendError(w, r, apierror.New(apierror.InvoiceNotPayable, "invoice %d isn't an issued or paid invoice of %s", *body.InvoiceId, s.Name))
+		return
+	} else if errors.Is(err, db.ErrRefundTooLarge) {
+		sendError(w, r, apierror.New(apierror.RefundTooLarge, "%s hasn't paid enough to refund %d", s.Name, body.Amount))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	balance, err := db.GetBalance(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditPaymentRecorded, s.ID, map[string]interface{}{
+		"paymentId": result.ID,
+		"kind":      result.Kind,
+		"amount":    result.Amount,
+		"invoiceId": result.InvoiceID,
+	})
+
+	data := map[string]interface{}{
+		"payment": toPayment(*result),
+		"balance": toBalance(balance),
+	}
+	if invoice != nil {
+		data["invoice"] = toInvoice(*invoice)
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data:    data,
+	})
+
+	if result.Kind == db.PaymentReceived {
+		publishPaymentReceived(r.Context(), event, sponsor, result, invoice, balance)
+	}
+	if change != nil {
+		publishStatusChange(r.Context(), event, sponsor, change.From, change.Reason)
+	}
+}
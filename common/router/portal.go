@@ -0,0 +1,230 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+//////////////////////////////////////////////////////////////
+//
+// Sponsor portal, for whoever holds a sponsor's invite link.
+// Everything here is about the invite's sponsor and nothing else.
+//
+//////////////////////////////////////////////////////////////
+
+// Badge allowance JSON struct
+type Allowance struct {
+	FreeBadges int `json:"freeBadges"`
+	Used       int `json:"used"`
+	Remaining  int `json:"remaining"`
+}
+
+// Gets the sponsor the invite is for, with its level when it has one
+func portalSponsor(w http.ResponseWriter, r *http.Request) (*db.Sponsor, *db.Level, bool) {
+	p := auth.FromContext(r.Context())
+	if p == nil || p.Role != auth.RoleSponsorContact {
+		sendError(w, r, apierror.New(apierror.Forbidden, "the portal can only be used with an invite link"))
+		return nil, nil, false
+	}
+	if !authorize(w, r, auth.ManageMembers, p.SponsorID) {
+		return nil, nil, false
+	}
+
+	s, err := db.GetSponsor(r.Context(), p.SponsorID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendError(w, r, apierror.New(apierror.SponsorNotFound, "sponsor %d does not exist", p.SponsorID))
+		return nil, nil, false
+	} else if err != nil {
+		sendError(w, r, err)
+		return nil, nil, false
+	}
+	if s.LevelID == nil {
+		return s, nil, true
+	}
+	l, err := db.GetLevel(r.Context(), *s.LevelID)
+	if err != nil {
+		sendError(w, r, err)
+		return nil, nil, false
+	}
+	return s, l, true
+}
+
+// A sponsor without a level, or whose status doesn't hold a spot on it
+// (a prospect, or cancelled), has no free badges
+func freeBadges(s *db.Sponsor, l *db.Level) int {
+	if l == nil || !db.HoldsSpot(s.Status) {
+		return 0
+	}
+	return l.MaxNumberOfFreeBadges
+}
+
+func toAllowance(s *db.Sponsor, l *db.Level, used int) Allowance {
+	a := Allowance{FreeBadges: freeBadges(s, l), Used: used}
+	if a.Used < a.FreeBadges {
+		a.Remaining = a.FreeBadges - a.Used
+	}
+	return a
+}
+
+func toMember(m db.Member) Member {
+	return Member{
+		Id:        m.ID,
+		Name:      m.Name,
+		Email:     m.Email,
+		SponsorId: m.SponsorID,
+	}
+}
+
+// To list the sponsor's team and how many badges they have left
+func GetPortalMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s, l, ok := portalSponsor(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetMembersOfSponsor(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	members := []Member{}
+	for _, m := range results {
+		members = append(members, toMember(m))
+	}
+	audit(r, AuditMembersListed, s.ID, map[string]interface{}{
+		"count": len(members),
+	})
+	sponsor := Sponsor{Id: s.ID, Name: s.Name, EventID: s.EventID, Status: s.Status, Logo: toLogo(s.Logo)}
+	if l != nil {
+		sponsor.Level = Level{
+			Id:                      l.ID,
+			EventID:                 l.EventID,
+			Name:                    l.Name,
+			Cost:                    l.Cost,
+			MaxSponsors:             l.MaxNumberOfSponsors,
+			MaxFreeBadgesPerSponsor: l.MaxNumberOfFreeBadges,
+		}
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor":   sponsor,
+			"members":   members,
+			"allowance": toAllowance(s, l, len(members)),
+		},
+	})
+}
+
+// To add someone to the sponsor's team, while they have badges left
+func CreatePortalMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s, l, ok := portalSponsor(w, r)
+	if !ok {
+		return
+	}
+
+	var body MemberRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	result, err := db.CreateMemberWithinAllowance(r.Context(), body.Name, body.Email, s.ID, s.EventID, freeBadges(s, l))
+	if errors.Is(err, db.ErrAllowanceUsed) {
+		audit(r, AuditActionRejected, s.ID, map[string]interface{}{
+			"attempted": AuditMemberAdded,
+			"email":     body.Email,
+			"reason":    err.Error(),
+		})
+		sendError(w, r, apierror.New(apierror.BadgeLimitReached, "%s has used all %d of its free badges", s.Name, freeBadges(s, l)))
+		return
+	}
+	if errors.Is(err, db.ErrDuplicate) {
+		audit(r, AuditActionRejected, s.ID, map[string]interface{}{
+			"attempted": AuditMemberAdded,
+			"email":     body.Email,
+			"reason":    "duplicate email",
+		})
+		sendError(w, r, apierror.New(apierror.MemberExists, "a member with this email already exists for this sponsor"))
+		return
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	member := toMember(*result)
+	audit(r, AuditMemberAdded, s.ID, map[string]interface{}{
+		"memberId": member.Id,
+		"name":     member.Name,
+		"email":    member.Email,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"member": member,
+		},
+	})
+
+	eventName := ""
+	if event, err := db.GetEvent(r.Context(), s.EventID, -1); err == nil {
+		eventName = event.Name
+	}
+	levelName := ""
+	if l != nil {
+		levelName = l.Name
+	}
+	publishMemberCreated(r.Context(), member, s.EventID, eventName, s.Name, levelName, result.CreatedAt)
+}
+
+// To take someone off the sponsor's team, which frees up their badge
+func RemovePortalMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s, _, ok := portalSponsor(w, r)
+	if !ok {
+		return
+	}
+	memberId, ok := pathInt(w, r, "member_id")
+	if !ok {
+		return
+	}
+
+	// Members of other sponsors look the same as ones that don't exist
+	m, err := db.GetMember(r.Context(), memberId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && m.SponsorID != s.ID) {
+		audit(r, AuditActionRejected, s.ID, map[string]interface{}{
+			"attempted": AuditMemberRemoved,
+			"memberId":  memberId,
+			"reason":    "member not found",
+		})
+		sendError(w, r, apierror.New(apierror.MemberNotFound, "%s has no member %d", s.Name, memberId))
+		return
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	if err := db.DeleteMember(r.Context(), m.ID); err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditMemberRemoved, s.ID, map[string]interface{}{
+		"memberId": m.ID,
+		"name":     m.Name,
+		"email":    m.Email,
+	})
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"member": toMember(*m),
+		},
+	})
+}
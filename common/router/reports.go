@@ -0,0 +1,63 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"net/http"
+)
+
+// A sponsor's line in the event report
+type SponsorReport struct {
+	Id      int     `json:"id"`
+	Name    string  `json:"name"`
+	Level   string  `json:"level"`
+	Status  string  `json:"status"`
+	Balance Balance `json:"balance"`
+}
+
+// Get an event's sponsors, with what each was billed, has paid and still
+// owes, and the totals for the event
+func GetEventReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ReadReports, 0) {
+		return
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return
+	}
+
+	balances, total, err := db.GetEventBalances(r.Context(), event.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	sponsors := []SponsorReport{}
+	byStatus := map[string]int{}
+	for _, s := range event.Sponsors {
+		sponsors = append(sponsors, SponsorReport{
+			Id:      s.ID,
+			Name:    s.Name,
+			Level:   s.LevelName,
+			Status:  s.Status,
+			Balance: toBalance(balances[s.ID]),
+		})
+		byStatus[s.Status]++
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"eventId":  event.ID,
+			"name":     event.Name,
+			"sponsors": sponsors,
+			"byStatus": byStatus,
+			"totals":   toBalance(total),
+		},
+	})
+}
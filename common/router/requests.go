@@ -0,0 +1,344 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////
+//
+// Request bodies, only the fields clients may send.
+// Anything else in a body is rejected.
+//
+//////////////////////////////////////////////////////////////
+
+const (
+	maxNameLength  = 100
+	maxEmailLength = 254
+	// Costs are free text, like 14500 or $250K
+	maxCostLength = 50
+	maxNoteLength = 500
+)
+
+// Bodies that can check themselves after they're decoded
+type validatable interface {
+	Validate(v *validation.Validator)
+}
+
+type EventRequest struct {
+	Name string `json:"name"`
+}
+
+func (e EventRequest) Validate(v *validation.Validator) {
+	if v.Required("name", e.Name) {
+		v.MaxLength("name", e.Name, maxNameLength)
+	}
+}
+
+// Only what's sent is changed
+type PatchEventRequest struct {
+	Name   *string        `json:"name"`
+	Levels []LevelRequest `json:"levels"`
+}
+
+func (e PatchEventRequest) Validate(v *validation.Validator) {
+	if e.Name != nil && v.Required("name", *e.Name) {
+		v.MaxLength("name", *e.Name, maxNameLength)
+	}
+	for i, l := range e.Levels {
+		l.validate(v, fmt.Sprintf("levels[%d].", i))
+	}
+}
+
+// Id is only used when referring to, or updating, a level that's already there.
+// Version, when it isn't 0, is the version the level is expected to be at.
+type LevelRequest struct {
+	Id                      int    `json:"id"`
+	Version                 int    `json:"version"`
+	Name                    string `json:"name"`
+	Cost                    string `json:"cost"`
+	MaxSponsors             int    `json:"maxSponsors"`
+	MaxFreeBadgesPerSponsor int    `json:"maxFreeBadgesPerSponsor"`
+}
+
+func (l LevelRequest) Validate(v *validation.Validator) {
+	l.validate(v, "")
+}
+
+// prefix is where the level is in the body, so nested levels get paths like levels[0].name
+func (l LevelRequest) validate(v *validation.Validator, prefix string) {
+	v.Min(prefix+"id", l.Id, 0)
+	v.Min(prefix+"version", l.Version, 0)
+	if v.Required(prefix+"name", l.Name) {
+		v.MaxLength(prefix+"name", l.Name, maxNameLength)
+	}
+	if v.Required(prefix+"cost", l.Cost) {
+		v.MaxLength(prefix+"cost", l.Cost, maxCostLength)
+	}
+	v.Min(prefix+"maxSponsors", l.MaxSponsors, 0)
+	v.Min(prefix+"maxFreeBadgesPerSponsor", l.MaxFreeBadgesPerSponsor, 0)
+}
+
+// Only what's sent is changed
+type PatchLevelRequest struct {
+	Name                    *string `json:"name"`
+	Cost                    *string `json:"cost"`
+	MaxSponsors             *int    `json:"maxSponsors"`
+	MaxFreeBadgesPerSponsor *int    `json:"maxFreeBadgesPerSponsor"`
+}
+
+func (l PatchLevelRequest) Validate(v *validation.Validator) {
+	if l.Name != nil && v.Required("name", *l.Name) {
+		v.MaxLength("name", *l.Name, maxNameLength)
+	}
+	if l.Cost != nil && v.Required("cost", *l.Cost) {
+		v.MaxLength("cost", *l.Cost, maxCostLength)
+	}
+	if l.MaxSponsors != nil {
+		v.Min("maxSponsors", *l.MaxSponsors, 0)
+	}
+	if l.MaxFreeBadgesPerSponsor != nil {
+		v.Min("maxFreeBadgesPerSponsor", *l.MaxFreeBadgesPerSponsor, 0)
+	}
+}
+
+// Level is either {"id": 1} for a level the event already has, or a new level
+type SponsorRequest struct {
+	Name  string        `json:"name"`
+	Level *LevelRequest `json:"level"`
+}
+
+func (s SponsorRequest) Validate(v *validation.Validator) {
+	if v.Required("name", s.Name) {
+		v.MaxLength("name", s.Name, maxNameLength)
+	}
+	if s.Level == nil {
+		return
+	}
+	if s.Level.Id != 0 {
+		v.Min("level.id", s.Level.Id, 1)
+		return
+	}
+	s.Level.validate(v, "level.")
+}
+
+type MemberRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (m MemberRequest) Validate(v *validation.Validator) {
+	if v.Required("name", m.Name) {
+		v.MaxLength("name", m.Name, maxNameLength)
+	}
+	if v.Required("email", m.Email) && v.MaxLength("email", m.Email, maxEmailLength) {
+		v.Email("email", m.Email)
+	}
+}
+
+// Only what's sent is changed. A levelId of 0 takes the sponsor off its level.
+type PatchSponsorRequest struct {
+	Name    *string `json:"name"`
+	LevelID *int    `json:"levelId"`
+}
+
+func (s PatchSponsorRequest) Validate(v *validation.Validator) {
+	if s.Name != nil && v.Required("name", *s.Name) {
+		v.MaxLength("name", *s.Name, maxNameLength)
+	}
+	if s.LevelID != nil {
+		v.Min("levelId", *s.LevelID, 0)
+	}
+}
+
+// Sponsor of the level's event that wants a spot on it
+type WaitlistRequest struct {
+	SponsorID *int `json:"sponsorId"`
+}
+
+func (w WaitlistRequest) Validate(v *validation.Validator) {
+	if v.Check(w.SponsorID != nil, "sponsorId", validation.RuleRequired, "is required") {
+		v.Min("sponsorId", *w.SponsorID, 1)
+	}
+}
+
+// Only what's sent is changed
+type PatchMemberRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+func (m PatchMemberRequest) Validate(v *validation.Validator) {
+	if m.Name != nil && v.Required("name", *m.Name) {
+		v.MaxLength("name", *m.Name, maxNameLength)
+	}
+	if m.Email != nil && v.Required("email", *m.Email) && v.MaxLength("email", *m.Email, maxEmailLength) {
+		v.Email("email", *m.Email)
+	}
+}
+
+// Decodes a JSON request body into body and validates it. Malformed JSON gets
+// a 400, unknown fields, wrong types and broken rules get a 422 listing every
+// field. Returns false when it has sent an error.
+func decodeBody(w http.ResponseWriter, r *http.Request, body validatable) bool {
+	return decode(w, r, body, false)
+}
+
+// Like decodeBody, but an empty body is fine
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, body validatable) bool {
+	return decode(w, r, body, true)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, body validatable, optional bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	var raw json.RawMessage
+	err := decoder.Decode(&raw)
+	if err == io.EOF && optional {
+		return validate(w, r, body, nil)
+	}
+
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "request body is required"))
+		return false
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "request body is not valid JSON | %s", err))
+		return false
+	default:
+		sendError(w, r, apierror.Wrap(apierror.MalformedRequest, err, "could not read the request body"))
+		return false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "request body must be a single JSON value"))
+		return false
+	}
+
+	// Unknown fields and wrong types are found up front, so they can all
+	// be reported along with the rules the rest of the body breaks
+	var decodeErrs validation.Errors
+	checkFields(raw, reflect.TypeOf(body), "", &decodeErrs)
+	// Fields with the wrong type are left empty, checkFields has reported them
+	json.Unmarshal(raw, body)
+	return validate(w, r, body, decodeErrs)
+}
+
+// Validates a decoded body and sends a 422 with decodeErrs and every rule it
+// breaks. Fields with the wrong type, and anything inside them, were left
+// empty so they aren't validated again.
+func validate(w http.ResponseWriter, r *http.Request, body validatable, decodeErrs validation.Errors) bool {
+	var v validation.Validator
+	body.Validate(&v)
+	errs := decodeErrs
+	if err := v.Err(); err != nil {
+		for _, e := range err.(validation.Errors) {
+			if !wrongType(decodeErrs, e.Field) {
+				errs = append(errs, e)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sendError(w, r, apierror.Validation(errs))
+		return false
+	}
+	return true
+}
+
+func wrongType(decodeErrs validation.Errors, field string) bool {
+	for _, e := range decodeErrs {
+		if e.Rule == validation.RuleType && (field == e.Field || strings.HasPrefix(field, e.Field+".") || strings.HasPrefix(field, e.Field+"[")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walks raw against the type it will be decoded into, adding an error for
+// every field the type doesn't have and every value of the wrong type.
+// path is the JSON path so far, like levels[1].
+func checkFields(raw json.RawMessage, t reflect.Type, path string, errs *validation.Errors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if string(raw) == "null" {
+		return
+	}
+	typeErr := validation.FieldError{Field: path, Rule: validation.RuleType, Message: "must be a " + jsonType(t.Kind().String())}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(raw, &object) != nil {
+			*errs = append(*errs, typeErr)
+			return
+		}
+		// Known fields in the order the struct has them, then the unknown ones
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			for key, value := range object {
+				// Keys match like encoding/json matches them
+				if key == name || (strings.EqualFold(key, name) && object[name] == nil) {
+					checkFields(value, t.Field(i).Type, joinPath(path, name), errs)
+					delete(object, key)
+					break
+				}
+			}
+		}
+		unknown := make([]string, 0, len(object))
+		for key := range object {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			*errs = append(*errs, validation.FieldError{
+				Field:   joinPath(path, key),
+				Rule:    validation.RuleUnknown,
+				Message: "is not a field this endpoint takes",
+			})
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			*errs = append(*errs, typeErr)
+			return
+		}
+		for i, item := range items {
+			checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	default:
+		if json.Unmarshal(raw, reflect.New(t).Interface()) != nil {
+			*errs = append(*errs, typeErr)
+		}
+	}
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// Names Go kinds the way a JSON client thinks of them
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "array"
+	case kind == "struct", kind == "map", kind == "ptr":
+		return "object"
+	}
+	return kind
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		optional bool
+		code     int
+		// rule:field of every error, in order
+		errors []string
+	}{
+		{"valid", `{"name":"Conf","levels":[{"name":"Gold","cost":"100"}]}`, false, http.StatusOK, nil},
+		{"fields match like encoding/json", `{"Name":"Conf"}`, false, http.StatusOK, nil},
+		{"trailing whitespace", "{\"name\":\"Conf\"}\n", false, http.StatusOK, nil},
+		{"empty optional", ``, true, http.StatusOK, nil},
+		{"empty", ``, false, http.StatusBadRequest, nil},
+		{"not JSON", `{"name":`, false, http.StatusBadRequest, nil},
+		{"second value", `{"name":"Conf"}{"name":"Other"}`, false, http.StatusBadRequest, nil},
+		{"trailing garbage", `{"name":"Conf"} x`, false, http.StatusBadRequest, nil},
+		{"not an object", `[]`, false, http.StatusUnprocessableEntity, []string{"type:"}},
+		{
+			"everything at once",
+			`{"name":"","zebra":1,"extra":true,"levels":[{"name":7,"cost":"","bonus":1},"gold"]}`,
+			false, http.StatusUnprocessableEntity,
+			[]string{
+				"type:levels[0].name", "unknown:levels[0].bonus", "type:levels[1]",
+				"unknown:extra", "unknown:zebra",
+				"required:name", "required:levels[0].cost",
+			},
+		},
+		{
+			"nested object",
+			`{"name":5,"level":{"id":1,"color":"gold"}}`,
+			false, http.StatusUnprocessableEntity,
+			[]string{"type:name", "unknown:level.color"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.body))
+			var ok bool
+			if strings.Contains(tt.body, `"level":`) {
+				ok = decode(rec, req, &SponsorRequest{}, tt.optional)
+			} else {
+				ok = decode(rec, req, &PatchEventRequest{}, tt.optional)
+			}
+
+			if ok != (tt.code == http.StatusOK) || (!ok && rec.Code != tt.code) {
+				t.Fatalf("got %v with %d, want %d: %s", ok, rec.Code, tt.code, rec.Body.String())
+			}
+			if tt.errors == nil {
+				return
+			}
+			var problem struct {
+				Errors validation.Errors `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range problem.Errors {
+				got = append(got, e.Rule+":"+e.Field)
+			}
+			if !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("got errors %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
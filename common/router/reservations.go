@@ -0,0 +1,255 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// How long a reservation holds its spot when the request doesn't say,
+// main sets this from the config
+var ReservationHold = 7 * 24 * time.Hour
+
+// Longest a reservation can hold a spot
+const maxReservationHold = 90 * 24 * time.Hour
+
+// Reservation JSON struct
+type Reservation struct {
+	Id        int       `json:"id"`
+	LevelId   int       `json:"levelId"`
+	Prospect  string    `json:"prospect"`
+	Note      string    `json:"note"`
+	HeldBy    string    `json:"heldBy"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Set once it's converted
+	SponsorId *int `json:"sponsorId"`
+}
+
+func toReservation(r db.Reservation) Reservation {
+	return Reservation{
+		Id:        r.ID,
+		LevelId:   r.LevelID,
+		Prospect:  r.Prospect,
+		Note:      r.Note,
+		HeldBy:    r.HeldBy,
+		Status:    r.Status,
+		ExpiresAt: r.ExpiresAt,
+		CreatedAt: r.CreatedAt,
+		SponsorId: r.SponsorID,
+	}
+}
+
+type ReservationRequest struct {
+	Prospect string `json:"prospect"`
+	Note     string `json:"note"`
+	// Like 168h, ReservationHold when empty
+	HoldFor string `json:"holdFor"`
+}
+
+func (r ReservationRequest) Validate(v *validation.Validator) {
+	if v.Required("prospect", r.Prospect) {
+		v.MaxLength("prospect", r.Prospect, maxNameLength)
+	}
+	v.MaxLength("note", r.Note, maxNoteLength)
+	if r.HoldFor != "" {
+		d, err := time.ParseDuration(r.HoldFor)
+		v.Check(err == nil && d > 0 && d <= maxReservationHold, "holdFor", validation.RuleFormat,
+			"must be a duration like 168h, up to %.0fh", maxReservationHold.Hours())
+	}
+}
+
+// The sponsor's name when it isn't the prospect's
+type ConvertReservationRequest struct {
+	Name *string `json:"name"`
+}
+
+func (c ConvertReservationRequest) Validate(v *validation.Validator) {
+	if c.Name != nil && v.Required("name", *c.Name) {
+		v.MaxLength("name", *c.Name, maxNameLength)
+	}
+}
+
+// Gets the reservation in the path, sending a 404 when the level has no such held reservation
+func getReservationOfLevel(w http.ResponseWriter, r *http.Request, levelId int) (*db.Reservation, bool) {
+	reservationId, ok := pathInt(w, r, "reservation_id")
+	if !ok {
+		return nil, false
+	}
+	res, err := db.GetReservation(r.Context(), reservationId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && res.LevelID != levelId) {
+		sendError(w, r, apierror.New(apierror.ReservationNotFound, "level %d has no reservation %d", levelId, reservationId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return res, true
+}
+
+// SweepReservations releases reservations that ran out every interval, and
+// offers their spots to the levels' waitlists, until ctx is done
+func SweepReservations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			levelIds, err := db.ExpireReservations(ctx, now)
+			if err != nil {
+				logging.Error("could not expire reservations", logging.Fields{"error": err})
+				continue
+			}
+			for _, id := range levelIds {
+				offerWaitlist(ctx, id)
+			}
+		}
+	}
+}
+
+// List the reservations holding a level's spots, soonest to expire first
+func GetReservations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetReservations(r.Context(), l.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	reservations := []Reservation{}
+	for _, res := range results {
+		reservations = append(reservations, toReservation(res))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"reservations": reservations,
+		},
+	})
+}
+
+// Hold one of a level's spots for a prospect that hasn't signed yet
+func CreateReservation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	var body ReservationRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	hold := ReservationHold
+	if body.HoldFor != "" {
+		hold, _ = time.ParseDuration(body.HoldFor)
+	}
+
+	result, err := db.CreateReservation(r.Context(), l.ID, body.Prospect, body.Note, actor(r), time.Now().Add(hold))
+	if errors.Is(err, db.ErrLevelFull) {
+		sendError(w, r, apierror.New(apierror.LevelFull, "level %d has no spots left to reserve", l.ID))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"reservation": toReservation(*result),
+		},
+	})
+}
+
+// Give a reserved spot back before the reservation expires
+func ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	res, ok := getReservationOfLevel(w, r, l.ID)
+	if !ok {
+		return
+	}
+
+	result, err := db.ReleaseReservation(r.Context(), res.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"reservation": toReservation(*result),
+		},
+	})
+
+	offerWaitlist(r.Context(), l.ID)
+}
+
+// Turn a reservation into a sponsor on its level, once the contract is signed
+func ConvertReservation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	res, ok := getReservationOfLevel(w, r, l.ID)
+	if !ok {
+		return
+	}
+	var body ConvertReservationRequest
+	if !decodeOptionalBody(w, r, &body) {
+		return
+	}
+	name := res.Prospect
+	if body.Name != nil {
+		name = *body.Name
+	}
+
+	result, sponsor, err := db.ConvertReservation(r.Context(), res.ID, name, event.ID)
+	if errors.Is(err, db.ErrReservationExpired) {
+		sendError(w, r, apierror.New(apierror.ReservationExpired, "reservation %d expired at %s", res.ID, res.ExpiresAt.Format(time.RFC3339)))
+		return
+	} else if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.SponsorExists, "a sponsor with this name already exists for this event"))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditReservationConverted, sponsor.ID, map[string]interface{}{
+		"reservationId": result.ID,
+		"prospect":      result.Prospect,
+		"heldBy":        result.HeldBy,
+	})
+
+	setETag(w, sponsor.Version)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"reservation": toReservation(*result),
+			"sponsor":     toSponsor(*sponsor, event, toLevel(*l)),
+		},
+	})
+}
@@ -1,17 +1,31 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/gorilla/mux"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
 	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
 	"github.com/r3dcrosse/sponsor-service/common/messaging"
+	"github.com/r3dcrosse/sponsor-service/common/metrics"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 var MessagingClient messaging.IRabbitMQClient
 
+// Queue to publish new sponsor members on, main sets this from the config
+var MemberCreatedQueue = "sponsor.member.created"
+
+// Queue to publish changes to members on, so badges can be fixed
+var MemberUpdatedQueue = "sponsor.member.updated"
+
 //////////////////////////////////////////////////////////////
 //
 // Our Microservice Models
@@ -25,6 +39,9 @@ type Sponsor struct {
 	Level   Level    `json:"level"`
 	Members []Member `json:"members"`
 	Id      int      `json:"id"`
+	Status  string   `json:"status"`
+	// nil when the sponsor hasn't uploaded one
+	Logo *Logo `json:"logo"`
 }
 
 // Level struct
@@ -35,6 +52,7 @@ type Level struct {
 	MaxSponsors             int    `json:"maxSponsors"`
 	MaxFreeBadgesPerSponsor int    `json:"maxFreeBadgesPerSponsor"`
 	Id                      int    `json:"id"`
+	Version                 int    `json:"version"`
 }
 
 // Team Member struct (team members part of a sponsor)
@@ -64,42 +82,96 @@ type HttpResponseJSON struct {
 	Data    map[string]interface{} `json:"data"`
 }
 
-// HttpError JSON struct
-type HttpErrorJSON struct {
-	Success bool                   `json:"success"`
-	Error   map[string]interface{} `json:"error"`
+// Checks the caller may do action, and sends a 403 with the reason when they can't.
+// sponsorId is the sponsor the request is about, 0 when it isn't about one.
+func authorize(w http.ResponseWriter, r *http.Request, action auth.Action, sponsorId int) bool {
+	if err := auth.Can(auth.FromContext(r.Context()), action, sponsorId); err != nil {
+		sendError(w, r, apierror.New(apierror.Forbidden, "%s", err))
+		return false
+	}
+	return true
 }
 
-func sendHttpErrorResponse(w http.ResponseWriter, statusCode int, err error) {
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(HttpErrorJSON{
-		Success: false,
-		Error: map[string]interface{}{
-			"error": map[string]interface{}{
-				"message": err.Error(),
-			},
-		},
-	})
+// Sends err as a problem+json response. Anything that isn't an
+// *apierror.Error is sent as an internal error, without its message.
+func sendError(w http.ResponseWriter, r *http.Request, err error) {
+	apierror.Write(w, r, err)
+}
+
+// Gets a numeric path param, sending a 400 when it isn't one
+func pathInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "%s must be a number", name))
+		return 0, false
+	}
+	return value, true
+}
+
+// Gets a numeric query param, 0 when it isn't there, sending a 400 when it isn't a number
+func queryInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		sendError(w, r, apierror.New(apierror.InvalidParameter, "%s must be a number", name))
+		return 0, false
+	}
+	return value, true
+}
+
+// Looks up a sponsor of an event, sending a 404 when there's no such
+// sponsor or it's a sponsor of another event
+func getSponsorOfEvent(w http.ResponseWriter, r *http.Request, sponsorId int, eventId int) (*db.Sponsor, bool) {
+	s, err := db.GetSponsor(r.Context(), sponsorId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && s.EventID != eventId) {
+		sendError(w, r, apierror.New(apierror.SponsorNotFound, "event %d has no sponsor %d", eventId, sponsorId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return s, true
+}
+
+// Looks up an event by our ID, sending a 404 when there's no such event
+func getEvent(w http.ResponseWriter, r *http.Request, id int) (*db.Event, bool) {
+	event, err := db.GetEvent(r.Context(), id, -1)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendError(w, r, apierror.New(apierror.EventNotFound, "event %d does not exist", id))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return event, true
 }
 
 // To create a member of a sponsor team
 func CreateMember(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	params := mux.Vars(r) // Gets params
-	eventId, err := strconv.Atoi(params["event_id"])
+	sponsorId, ok := pathInt(w, r, "sponsor_id")
+	if !ok || !authorize(w, r, auth.ManageMembers, sponsorId) {
+		return
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
+	}
 
 	// Check if the event even exists
-	event, err := db.GetEvent(eventId, -1)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
 		return
 	}
 
 	// Check if the sponsor team exists
-	sponsorId, err := strconv.Atoi(params["sponsor_id"])
-	s, err := db.GetSponsor(sponsorId)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	s, ok := getSponsorOfEvent(w, r, sponsorId, event.ID)
+	if !ok {
 		return
 	}
 	sponsor := Sponsor{
@@ -107,28 +179,32 @@ func CreateMember(w http.ResponseWriter, r *http.Request) {
 		Id:   s.ID,
 	}
 
-	// Get the sponsorship level from the DB
-	l, err := db.GetLevel(s.LevelID)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
-		return
-	}
-	level := Level{
-		Id:   l.ID,
-		Name: l.Name,
+	// Get the sponsorship level from the DB, if the sponsor has one
+	level := Level{}
+	if s.LevelID != nil {
+		l, err := db.GetLevel(r.Context(), *s.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		level.Id = l.ID
+		level.Name = l.Name
 	}
 
-	member := Member{
-		SponsorId: sponsorId,
-	}
-	err = json.NewDecoder(r.Body).Decode(&member)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	var member MemberRequest
+	if !decodeBody(w, r, &member) {
 		return
 	}
 
 	// Now create the member in the DB
-	result := db.CreateMember(member.Name, member.Email, member.SponsorId)
+	result, err := db.CreateMember(r.Context(), member.Name, member.Email, sponsorId, event.ID)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.MemberExists, "a member with this email already exists for this sponsor"))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
 	savedMember := Member{
 		Id:        result.ID,
 		Name:      result.Name,
@@ -136,6 +212,7 @@ func CreateMember(w http.ResponseWriter, r *http.Request) {
 		SponsorId: result.SponsorID,
 	}
 
+	setETag(w, result.Version)
 	json.NewEncoder(w).Encode(HttpResponseJSON{
 		Success: true,
 		Data: map[string]interface{}{
@@ -144,46 +221,79 @@ func CreateMember(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Send a rabbitMQ message that a member was created
-	go func(m Member, evId int, l Level, s Sponsor) {
-		memberNotification := map[string]interface{}{
-			"id":           m.Id,
-			"eventId":      evId,
-			"sponsorId":    m.SponsorId,
-			"name":         m.Name,
-			"email":        m.Email,
-			"organization": s.Name,
-			"eventName":    event.Name,
-			"sponsorLevel": l.Name,
-		}
-		data, _ := json.Marshal(memberNotification)
-		err := MessagingClient.SendOnQueue(data, "sponsor.member.created")
+	publishMemberCreated(r.Context(), savedMember, eventId, event.Name, sponsor.Name, level.Name, result.CreatedAt)
+}
+
+func publishMemberCreated(ctx context.Context, m Member, eventId int, eventName string, sponsorName string, levelName string, savedAt time.Time) {
+	publish(ctx, MemberCreatedQueue, map[string]interface{}{
+		"id":           m.Id,
+		"eventId":      eventId,
+		"sponsorId":    m.SponsorId,
+		"name":         m.Name,
+		"email":        m.Email,
+		"organization": sponsorName,
+		"eventName":    eventName,
+		"sponsorLevel": levelName,
+	}, savedAt)
+}
+
+// Sends a message in the background. The request's correlation ID and span
+// go with the message. notification's "id" is logged if it fails.
+func publish(ctx context.Context, queue string, notification map[string]interface{}, savedAt time.Time) {
+	metrics.PublishStarted(queue)
+	ctx = detach(ctx)
+	go func() {
+		data, _ := json.Marshal(notification)
+		err := MessagingClient.SendOnQueue(ctx, data, queue)
+		metrics.PublishFinished(queue, savedAt)
 		if err != nil {
-			fmt.Printf("Something went wrong when sending the message to sponsor.member.created | %s", err.Error())
+			logging.FromContext(ctx).Error("could not send message", logging.Fields{
+				"queue": queue,
+				"id":    notification["id"],
+				"error": err,
+			})
 		}
-	}(savedMember, eventId, level, sponsor)
+	}()
+}
+
+// detach keeps the correlation ID and span of a request's context, but not
+// its cancellation, so a message can still be sent once the request is done
+func detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	if id := logging.CorrelationID(ctx); id != "" {
+		detached = logging.WithCorrelationID(detached, id)
+	}
+	return detached
 }
 
 // To create a level
 func CreateLevel(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	params := mux.Vars(r) // Gets params
-	eventId, err := strconv.Atoi(params["event_id"])
-	event, err := db.GetEvent(eventId, -1)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	if !authorize(w, r, auth.ManageEvents, 0) {
 		return
 	}
-
-	level := Level{
-		EventID: event.ID,
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&level)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
 		return
 	}
 
-	result := db.CreateLevel(level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, event.ID)
+	var level LevelRequest
+	if !decodeBody(w, r, &level) {
+		return
+	}
+
+	result, err := db.CreateLevel(r.Context(), level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, event.ID)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.LevelExists, "a level with this name already exists for this event"))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
 	savedLevel := Level{
 		Id:                      result.ID,
 		Name:                    result.Name,
@@ -193,10 +303,11 @@ func CreateLevel(w http.ResponseWriter, r *http.Request) {
 		EventID:                 event.ID,
 	}
 
+	setETag(w, result.Version)
 	json.NewEncoder(w).Encode(HttpResponseJSON{
 		Success: true,
 		Data: map[string]interface{}{
-			"event": savedLevel,
+			"level": savedLevel,
 		},
 	})
 }
@@ -204,37 +315,38 @@ func CreateLevel(w http.ResponseWriter, r *http.Request) {
 // To create a sponsor
 func CreateSponsor(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	params := mux.Vars(r) // Gets params
-	eventId, err := strconv.Atoi(params["event_id"])
-	event, err := db.GetEvent(eventId, -1)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	if !authorize(w, r, auth.ManageSponsors, 0) {
 		return
 	}
-
-	sponsor := Sponsor{
-		Event: event.Name,
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&sponsor)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
 		return
 	}
 
-	// Check for any Levels included in the request body
-	level := Level{
-		Id:                      sponsor.Level.Id,
-		EventID:                 eventId,
-		Name:                    sponsor.Level.Name,
-		Cost:                    sponsor.Level.Cost,
-		MaxSponsors:             sponsor.Level.MaxSponsors,
-		MaxFreeBadgesPerSponsor: sponsor.Level.MaxFreeBadgesPerSponsor,
+	var sponsor SponsorRequest
+	if !decodeBody(w, r, &sponsor) {
+		return
 	}
-	if sponsor.Level.Id != 0 {
-		savedLevel, err := db.GetLevel(sponsor.Level.Id)
+
+	// Check for any Levels included in the request body,
+	// without one the sponsor doesn't get a level yet
+	level := Level{}
+	if sponsor.Level != nil && sponsor.Level.Id != 0 {
+		savedLevel, err := db.GetLevel(r.Context(), sponsor.Level.Id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(w, r, apierror.New(apierror.LevelNotFound, "level %d does not exist", sponsor.Level.Id))
+			return
+		} else if err != nil {
+			sendError(w, r, err)
+			return
+		}
 		// Check if the event IDs match...
-		if err != nil || savedLevel.EventID != eventId {
-			sendHttpErrorResponse(w, http.StatusBadRequest, err)
+		if savedLevel.EventID != eventId {
+			sendError(w, r, apierror.New(apierror.LevelNotInEvent, "level %d belongs to another event", savedLevel.ID))
 			return
 		}
 
@@ -244,8 +356,15 @@ func CreateSponsor(w http.ResponseWriter, r *http.Request) {
 		level.Cost = savedLevel.Cost
 		level.MaxSponsors = savedLevel.MaxNumberOfSponsors
 		level.MaxFreeBadgesPerSponsor = savedLevel.MaxNumberOfFreeBadges
-	} else {
-		savedLevel := db.CreateLevel(level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, eventId)
+	} else if sponsor.Level != nil {
+		savedLevel, err := db.CreateLevel(r.Context(), sponsor.Level.Name, sponsor.Level.Cost, sponsor.Level.MaxSponsors, sponsor.Level.MaxFreeBadgesPerSponsor, eventId)
+		if errors.Is(err, db.ErrDuplicate) {
+			sendError(w, r, apierror.New(apierror.LevelExists, "a level with this name already exists for this event"))
+			return
+		} else if err != nil {
+			sendError(w, r, err)
+			return
+		}
 		level.Id = savedLevel.ID
 		level.EventID = savedLevel.EventID
 		level.Name = savedLevel.Name
@@ -255,13 +374,22 @@ func CreateSponsor(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if level.Id == 0 {
-		result := db.CreateSponsor(sponsor.Name, event.ID)
+		result, err := db.CreateSponsor(r.Context(), sponsor.Name, event.ID)
+		if errors.Is(err, db.ErrDuplicate) {
+			sendError(w, r, apierror.New(apierror.SponsorExists, "a sponsor with this name already exists for this event"))
+			return
+		} else if err != nil {
+			sendError(w, r, err)
+			return
+		}
 		savedSponsor := Sponsor{
 			Id:      result.ID,
 			Name:    result.Name,
 			Event:   event.Name,
 			EventID: event.ID,
+			Status:  result.Status,
 		}
+		setETag(w, result.Version)
 		json.NewEncoder(w).Encode(HttpResponseJSON{
 			Success: true,
 			Data: map[string]interface{}{
@@ -269,14 +397,26 @@ func CreateSponsor(w http.ResponseWriter, r *http.Request) {
 			},
 		})
 	} else {
-		result := db.CreateSponsorWithLevel(sponsor.Name, level.Id, eventId)
+		result, err := db.CreateSponsorWithLevel(r.Context(), sponsor.Name, level.Id, eventId)
+		if errors.Is(err, db.ErrDuplicate) {
+			sendError(w, r, apierror.New(apierror.SponsorExists, "a sponsor with this name already exists for this event"))
+			return
+		} else if errors.Is(err, db.ErrLevelFull) {
+			sendError(w, r, apierror.New(apierror.LevelFull, "the %s level already has all the sponsors it allows", level.Name))
+			return
+		} else if err != nil {
+			sendError(w, r, err)
+			return
+		}
 		savedSponsor := Sponsor{
 			Id:      result.ID,
 			Name:    result.Name,
 			Event:   event.Name,
 			EventID: event.ID,
 			Level:   level,
+			Status:  result.Status,
 		}
+		setETag(w, result.Version)
 		json.NewEncoder(w).Encode(HttpResponseJSON{
 			Success: true,
 			Data: map[string]interface{}{
@@ -289,16 +429,19 @@ func CreateSponsor(w http.ResponseWriter, r *http.Request) {
 // Get an event
 func GetEvent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	params := mux.Vars(r) // Gets params
-	id, err := strconv.Atoi(params["id"])
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	if !authorize(w, r, auth.ReadEvents, 0) {
+		return
+	}
+	id, ok := pathInt(w, r, "id")
+	if !ok {
 		return
 	}
 
-	result, err := db.GetEvent(id, -1)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	result, ok := getEvent(w, r, id)
+	if !ok {
+		return
+	}
+	if notModified(w, r, result.Version) {
 		return
 	}
 
@@ -314,12 +457,15 @@ func GetEvent(w http.ResponseWriter, r *http.Request) {
 }
 
 // Get all events
-func GetAllEvents(w http.ResponseWriter, _ *http.Request) {
+func GetAllEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ReadEvents, 0) {
+		return
+	}
 
-	results := db.GetAllEvents()
+	results := db.GetAllEvents(r.Context())
 
-	var events []Event
+	events := []Event{}
 	for _, result := range *results {
 		var levels []Level
 		for _, level := range result.Levels {
@@ -342,6 +488,8 @@ func GetAllEvents(w http.ResponseWriter, _ *http.Request) {
 				Level: Level{
 					Name: sponsor.Level.Name,
 				},
+				Status: sponsor.Status,
+				Logo:   toLogo(sponsor.Logo),
 			})
 		}
 
@@ -364,10 +512,11 @@ func GetAllEvents(w http.ResponseWriter, _ *http.Request) {
 // To create an event
 func CreateEvent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var event Event
-	err := json.NewDecoder(r.Body).Decode(&event)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	if !authorize(w, r, auth.ManageEvents, 0) {
+		return
+	}
+	var event EventRequest
+	if !decodeBody(w, r, &event) {
 		return
 	}
 
@@ -377,11 +526,12 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 	// because events created through the REST API have no
 	// corresponding ID from the event service, because they don't
 	// exist in the event service
-	result := db.CreateEvent(event.Name, -1)
+	result := db.CreateEvent(r.Context(), event.Name, -1)
 	savedEvent := Event{
 		Id:   result.ID,
 		Name: result.Name,
 	}
+	setETag(w, result.Version)
 
 	json.NewEncoder(w).Encode(HttpResponseJSON{
 		Success: true,
@@ -393,103 +543,108 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 
 func PatchEvent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if !authorize(w, r, auth.ManageEvents, 0) {
+		return
+	}
 
-	params := mux.Vars(r) // Gets params
-	id, err := strconv.Atoi(params["id"])
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	id, ok := pathInt(w, r, "id")
+	if !ok {
 		return
 	}
 
-	var event Event
-	err = json.NewDecoder(r.Body).Decode(&event)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	var event PatchEventRequest
+	if !decodeBody(w, r, &event) {
 		return
 	}
 
-	result, err := db.UpdateEvent(id, event.Name)
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusNotFound, err)
+	current, ok := getEvent(w, r, id)
+	if !ok {
 		return
 	}
-	savedEvent := Event{
-		Id:   id,
-		Name: result.Name,
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
 	}
-
-	// Check if the savedEvent has any levels
-	if result.Levels != nil {
-		for _, l := range result.Levels {
-			savedEvent.Levels = append(savedEvent.Levels, Level{
-				Id:                      l.ID,
-				EventID:                 l.EventID,
-				Name:                    l.Name,
-				MaxSponsors:             l.MaxNumberOfSponsors,
-				MaxFreeBadgesPerSponsor: l.MaxNumberOfFreeBadges,
-				Cost:                    l.Cost,
-			})
+	name := current.Name
+	if event.Name != nil {
+		name = *event.Name
+	}
+	var changes []db.LevelChange
+	var updatedLevels []int
+	for _, l := range event.Levels {
+		changes = append(changes, db.LevelChange{
+			ID:                    l.Id,
+			Version:               l.Version,
+			Name:                  l.Name,
+			Cost:                  l.Cost,
+			MaxNumberOfSponsors:   l.MaxSponsors,
+			MaxNumberOfFreeBadges: l.MaxFreeBadgesPerSponsor,
+		})
+		if l.Id != 0 {
+			updatedLevels = append(updatedLevels, l.Id)
 		}
 	}
 
-	// Check if event has levels to update
-	if event.Levels != nil {
-		for _, l := range event.Levels {
-			var savedLevel *db.Level
-			if l.Id == 0 {
-				savedLevel = db.CreateLevel(l.Name, l.Cost, l.MaxSponsors, l.MaxFreeBadgesPerSponsor, id)
-			} else {
-				savedLevel, err = db.UpdateLevel(l.Id, l.Name, l.Cost, l.MaxSponsors, l.MaxFreeBadgesPerSponsor, id)
-				if err != nil {
-					sendHttpErrorResponse(w, http.StatusNotFound, err)
-					return
-				}
-			}
-			savedEvent.Levels = append(savedEvent.Levels, Level{
-				Id:                      savedLevel.ID,
-				Name:                    savedLevel.Name,
-				Cost:                    savedLevel.Cost,
-				MaxFreeBadgesPerSponsor: savedLevel.MaxNumberOfFreeBadges,
-				MaxSponsors:             savedLevel.MaxNumberOfSponsors,
-				EventID:                 savedLevel.EventID,
-			})
+	// The levels are saved with the event, so a bad one leaves everything as it was
+	result, err := db.UpdateEventWithLevels(r.Context(), id, name, changes, version)
+	var levelErr *db.LevelChangeError
+	if errors.As(err, &levelErr) {
+		l := event.Levels[levelErr.Index]
+		if errors.Is(err, db.ErrDuplicate) {
+			sendError(w, r, apierror.New(apierror.LevelExists, "a level with this name already exists for this event"))
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(w, r, apierror.New(apierror.LevelNotFound, "event %d has no level %d", id, l.Id))
+		} else if errors.Is(err, db.ErrVersionMismatch) {
+			sendError(w, r, apierror.New(apierror.PreconditionFailed, "level %d has changed since version %d", l.Id, l.Version))
+		} else {
+			sendError(w, r, err)
 		}
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		// Someone else got in between reading it and saving
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
 	}
 
+	savedEvent := Event{
+		Id:   id,
+		Name: result.Name,
+	}
+	for _, l := range result.Levels {
+		savedEvent.Levels = append(savedEvent.Levels, toLevel(l))
+	}
+
+	// Every level change bumped the version again
+	setETag(w, result.Version)
 	json.NewEncoder(w).Encode(HttpResponseJSON{
 		Success: true,
 		Data: map[string]interface{}{
 			"event": savedEvent,
 		},
 	})
+
+	// Raising a level's max sponsors may have made room for its waitlist
+	for _, levelId := range updatedLevels {
+		offerWaitlist(r.Context(), levelId)
+	}
 }
 
 func RemoveMember(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	params := mux.Vars(r) // Gets params
-	_, err := strconv.Atoi(params["event_id"])
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	if _, ok := pathInt(w, r, "event_id"); !ok {
 		return
 	}
-	_, err = strconv.Atoi(params["sponsor_id"])
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	sponsorId, ok := pathInt(w, r, "sponsor_id")
+	if !ok || !authorize(w, r, auth.ManageMembers, sponsorId) {
 		return
 	}
-
-	_, err = strconv.Atoi(params["member_id"])
-	if err != nil {
-		sendHttpErrorResponse(w, http.StatusBadRequest, err)
+	if _, ok := pathInt(w, r, "member_id"); !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusNotImplemented)
-	json.NewEncoder(w).Encode(HttpResponseJSON{
-		Success: true,
-		Data: map[string]interface{}{
-			"message": "so sorry, this isn't implemented yet...",
-		},
-	})
+	sendError(w, r, apierror.New(apierror.NotImplemented, "so sorry, this isn't implemented yet..."))
 }
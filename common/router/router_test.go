@@ -0,0 +1,54 @@
+package router
+
+import (
+	"context"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/messaging"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+	"time"
+)
+
+// Hands over the context each message is sent with
+type contextClient struct {
+	messaging.InMemoryClient
+	sent chan context.Context
+}
+
+func (c *contextClient) SendOnQueue(ctx context.Context, _ []byte, _ string) error {
+	c.sent <- ctx
+	return nil
+}
+
+func TestPublishOutlivesTheRequest(t *testing.T) {
+	client := &contextClient{sent: make(chan context.Context, 1)}
+	previous := MessagingClient
+	MessagingClient = client
+	t.Cleanup(func() { MessagingClient = previous })
+
+	span := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := logging.WithCorrelationID(trace.ContextWithSpanContext(context.Background(), span), "abc")
+	ctx, cancel := context.WithCancel(ctx)
+	publish(ctx, "test", map[string]interface{}{"id": 1}, time.Now())
+	// As when the handler returns before the message goes out
+	cancel()
+
+	var sent context.Context
+	select {
+	case sent = <-client.sent:
+	case <-time.After(time.Second):
+		t.Fatal("the message was never sent")
+	}
+	if sent.Err() != nil {
+		t.Errorf("got %v, want the message's context not cancelled with the request", sent.Err())
+	}
+	if id := logging.CorrelationID(sent); id != "abc" {
+		t.Errorf("got correlation ID %q, want abc", id)
+	}
+	if got := trace.SpanContextFromContext(sent); !got.Equal(span) {
+		t.Errorf("got span %v, want the request's %v", got, span)
+	}
+}
@@ -0,0 +1,251 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func toLevel(l db.Level) Level {
+	return Level{
+		Id:                      l.ID,
+		EventID:                 l.EventID,
+		Name:                    l.Name,
+		Cost:                    l.Cost,
+		MaxSponsors:             l.MaxNumberOfSponsors,
+		MaxFreeBadgesPerSponsor: l.MaxNumberOfFreeBadges,
+		Version:                 l.Version,
+	}
+}
+
+func toSponsor(s db.Sponsor, event *db.Event, level Level) Sponsor {
+	return Sponsor{
+		Id:      s.ID,
+		Name:    s.Name,
+		Event:   event.Name,
+		EventID: event.ID,
+		Level:   level,
+		Status:  s.Status,
+		Logo:    toLogo(s.Logo),
+	}
+}
+
+// Looks up a level of an event, sending a 404 when there's no such level.
+// A level of another event gets a 422, like it does when creating a sponsor.
+func getLevelOfEvent(w http.ResponseWriter, r *http.Request, levelId int, eventId int) (*db.Level, bool) {
+	l, err := db.GetLevel(r.Context(), levelId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sendError(w, r, apierror.New(apierror.LevelNotFound, "level %d does not exist", levelId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	if l.EventID != eventId {
+		sendError(w, r, apierror.New(apierror.LevelNotInEvent, "level %d belongs to another event", levelId))
+		return nil, false
+	}
+	return l, true
+}
+
+// Checks the caller may manage sponsors, and gets the level and its event from the path
+func eventLevel(w http.ResponseWriter, r *http.Request) (*db.Event, *db.Level, bool) {
+	return levelFor(w, r, auth.ManageSponsors)
+}
+
+// Checks the caller may do action, and gets the level and its event from the path
+func levelFor(w http.ResponseWriter, r *http.Request, action auth.Action) (*db.Event, *db.Level, bool) {
+	if !authorize(w, r, action, 0) {
+		return nil, nil, false
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, nil, false
+	}
+	levelId, ok := pathInt(w, r, "level_id")
+	if !ok {
+		return nil, nil, false
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return nil, nil, false
+	}
+	l, ok := getLevelOfEvent(w, r, levelId, event.ID)
+	if !ok {
+		return nil, nil, false
+	}
+	return event, l, true
+}
+
+// Checks the caller may manage sponsors, and gets the sponsor and its event from the path
+func eventSponsor(w http.ResponseWriter, r *http.Request) (*db.Event, *db.Sponsor, bool) {
+	return sponsorFor(w, r, auth.ManageSponsors)
+}
+
+// Checks the caller may do action, and gets the sponsor and its event from the path
+func sponsorFor(w http.ResponseWriter, r *http.Request, action auth.Action) (*db.Event, *db.Sponsor, bool) {
+	if !authorize(w, r, action, 0) {
+		return nil, nil, false
+	}
+	eventId, ok := pathInt(w, r, "event_id")
+	if !ok {
+		return nil, nil, false
+	}
+	sponsorId, ok := pathInt(w, r, "sponsor_id")
+	if !ok {
+		return nil, nil, false
+	}
+	event, ok := getEvent(w, r, eventId)
+	if !ok {
+		return nil, nil, false
+	}
+	s, ok := getSponsorOfEvent(w, r, sponsorId, event.ID)
+	if !ok {
+		return nil, nil, false
+	}
+	return event, s, true
+}
+
+// Get one sponsor of an event, with its level
+func GetSponsor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, s, ok := sponsorFor(w, r, auth.ReadEvents)
+	if !ok {
+		return
+	}
+	if notModified(w, r, s.Version) {
+		return
+	}
+
+	sponsor := toSponsor(*s, event, Level{})
+	if s.LevelID != nil {
+		l, err := db.GetLevel(r.Context(), *s.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		sponsor.Level = toLevel(*l)
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor": sponsor,
+		},
+	})
+}
+
+// Rename a sponsor, or move it to another level. Moving it off a full
+// level offers the spot to the level's waitlist.
+func PatchSponsor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+
+	var patch PatchSponsorRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	name := current.Name
+	if patch.Name != nil {
+		name = *patch.Name
+	}
+	levelId := current.LevelID
+	if patch.LevelID != nil && *patch.LevelID == 0 {
+		levelId = nil
+	} else if patch.LevelID != nil {
+		l, ok := getLevelOfEvent(w, r, *patch.LevelID, event.ID)
+		if !ok {
+			return
+		}
+		levelId = &l.ID
+	}
+
+	result, previous, err := db.ChangeSponsor(r.Context(), current.ID, name, levelId, actor(r), version)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.SponsorExists, "a sponsor with this name already exists for this event"))
+		return
+	} else if errors.Is(err, db.ErrLevelFull) {
+		sendError(w, r, apierror.New(apierror.LevelFull, "level %d already has all the sponsors it allows, join its waitlist instead", *levelId))
+		return
+	} else if errors.Is(err, db.ErrNoLevel) {
+		sendError(w, r, apierror.New(apierror.SponsorHasNoLevel, "%s is %s, it needs its level, cancel it to free the spot", current.Name, current.Status))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	sponsor := toSponsor(*result, event, Level{})
+	if result.LevelID != nil {
+		l, err := db.GetLevel(r.Context(), *result.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		sponsor.Level = toLevel(*l)
+	}
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor": sponsor,
+		},
+	})
+
+	if result.Status != previous.Status {
+		publishStatusChange(r.Context(), event, result, previous.Status, db.LevelChangeReason(result.LevelID))
+	}
+	if previous.LevelID != nil && (result.LevelID == nil || *result.LevelID != *previous.LevelID) {
+		offerWaitlist(r.Context(), *previous.LevelID)
+	}
+}
+
+// Delete a sponsor, its spot on its level goes to the level's waitlist
+func DeleteSponsor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	result, err := db.DeleteSponsor(r.Context(), current.ID, version)
+	if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor": toSponsor(*result, event, Level{}),
+		},
+	})
+
+	if result.LevelID != nil {
+		offerWaitlist(r.Context(), *result.LevelID)
+	}
+}
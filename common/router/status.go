@@ -0,0 +1,169 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/validation"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Queue to publish sponsor status changes on, main sets this from the config
+var StatusChangedQueue = "sponsor.status.changed"
+
+// Status change JSON struct
+type StatusChange struct {
+	Id        int       `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason"`
+	ChangedBy string    `json:"changedBy"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+func toStatusChange(c db.SponsorStatusChange) StatusChange {
+	return StatusChange{
+		Id:        c.ID,
+		From:      c.From,
+		To:        c.To,
+		Reason:    c.Reason,
+		ChangedBy: c.ChangedBy,
+		ChangedAt: c.CreatedAt,
+	}
+}
+
+// Status to move the sponsor to, cancelling needs a reason
+type SponsorStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (s SponsorStatusRequest) Validate(v *validation.Validator) {
+	if v.Required("status", s.Status) {
+		v.OneOf("status", s.Status, db.SponsorStatuses...)
+	}
+	if s.Status == db.SponsorCancelled {
+		v.Required("reason", s.Reason)
+	}
+	v.MaxLength("reason", s.Reason, maxNoteLength)
+}
+
+// Tells everyone who cares that a sponsor's status changed
+func publishStatusChange(ctx context.Context, event *db.Event, s *db.Sponsor, from string, reason string) {
+	publish(ctx, StatusChangedQueue, map[string]interface{}{
+		"id":           s.ID,
+		"eventId":      event.ID,
+		"eventName":    event.Name,
+		"organization": s.Name,
+		"sponsorLevel": s.LevelName,
+		"from":         from,
+		"to":           s.Status,
+		"reason":       reason,
+		"changedAt":    s.StatusChangedAt,
+	}, *s.StatusChangedAt)
+}
+
+// Get a sponsor's status, where it can go next, and how it got there
+func GetSponsorStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, s, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetSponsorStatusChanges(r.Context(), s.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	history := []StatusChange{}
+	for _, c := range results {
+		history = append(history, toStatusChange(c))
+	}
+	next := db.NextStatuses(s.Status)
+	if next == nil {
+		next = []string{}
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"status":  s.Status,
+			"next":    next,
+			"history": history,
+		},
+	})
+}
+
+// Move a sponsor along its lifecycle, or cancel it
+func ChangeSponsorStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, current, ok := eventSponsor(w, r)
+	if !ok {
+		return
+	}
+	var body SponsorStatusRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	version, ok := ifMatch(w, r, current.Version)
+	if !ok {
+		return
+	}
+
+	result, change, err := db.ChangeSponsorStatus(r.Context(), current.ID, body.Status, body.Reason, actor(r), version)
+	if errors.Is(err, db.ErrInvalidTransition) {
+		next := strings.Join(db.NextStatuses(current.Status), ", ")
+		if next == "" {
+			next = "nothing"
+		}
+		sendError(w, r, apierror.New(apierror.InvalidStatusTransition, "a %s sponsor can't be %s, it can go to %s", current.Status, body.Status, next))
+		return
+	} else if errors.Is(err, db.ErrNoLevel) {
+		sendError(w, r, apierror.New(apierror.SponsorHasNoLevel, "%s needs a level to be %s", current.Name, body.Status))
+		return
+	} else if errors.Is(err, db.ErrLevelFull) {
+		sendError(w, r, apierror.New(apierror.LevelFull, "level %d already has all the sponsors it allows, join its waitlist instead", *current.LevelID))
+		return
+	} else if errors.Is(err, db.ErrVersionMismatch) {
+		sendError(w, r, changedSince())
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	audit(r, AuditStatusChanged, result.ID, map[string]interface{}{
+		"from":   change.From,
+		"to":     change.To,
+		"reason": change.Reason,
+	})
+
+	sponsor := toSponsor(*result, event, Level{})
+	if result.LevelID != nil {
+		l, err := db.GetLevel(r.Context(), *result.LevelID)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		sponsor.Level = toLevel(*l)
+	}
+
+	setETag(w, result.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"sponsor": sponsor,
+			"change":  toStatusChange(*change),
+		},
+	})
+
+	publishStatusChange(r.Context(), event, result, change.From, change.Reason)
+	// A cancelled sponsor gives its spot back
+	if result.LevelID != nil && db.HoldsSpot(change.From) && !db.HoldsSpot(change.To) {
+		offerWaitlist(r.Context(), *result.LevelID)
+	}
+}
@@ -0,0 +1,306 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////
+//
+// Waitlists for full levels. When a spot opens up, the sponsor
+// first in line is offered it and has WaitlistOfferHold to take it.
+//
+//////////////////////////////////////////////////////////////
+
+// Queue to publish waitlist offers on, main sets this from the config
+var WaitlistOfferedQueue = "sponsor.waitlist.offered"
+
+// How long an offered spot is held, main sets this from the config
+var WaitlistOfferHold = 72 * time.Hour
+
+// Waitlist entry JSON struct
+type WaitlistEntry struct {
+	Id        int    `json:"id"`
+	LevelId   int    `json:"levelId"`
+	SponsorId int    `json:"sponsorId"`
+	Status    string `json:"status"`
+	// 1 is next in line, only set while the entry is waiting or has an offer
+	Position       int        `json:"position,omitempty"`
+	JoinedAt       time.Time  `json:"joinedAt"`
+	OfferedAt      *time.Time `json:"offeredAt"`
+	OfferExpiresAt *time.Time `json:"offerExpiresAt"`
+}
+
+func toWaitlistEntry(e db.WaitlistEntry, position int) WaitlistEntry {
+	return WaitlistEntry{
+		Id:             e.ID,
+		LevelId:        e.LevelID,
+		SponsorId:      e.SponsorID,
+		Status:         e.Status,
+		Position:       position,
+		JoinedAt:       e.CreatedAt,
+		OfferedAt:      e.OfferedAt,
+		OfferExpiresAt: e.OfferExpiresAt,
+	}
+}
+
+// Where an entry is in line, 0 when it isn't anymore
+func waitlistPosition(ctx context.Context, e db.WaitlistEntry) (int, error) {
+	entries, err := db.GetWaitlist(ctx, e.LevelID)
+	if err != nil {
+		return 0, err
+	}
+	for i, other := range entries {
+		if other.ID == e.ID {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func acceptURL(eventId int, e db.WaitlistEntry) string {
+	return fmt.Sprintf("%s/sponsor-service/v1/event/%d/level/%d/waitlist/%d/accept",
+		strings.TrimRight(PublicURL, "/"), eventId, e.LevelID, e.ID)
+}
+
+// Gets the entry in the path, sending a 404 when it isn't on the level's waitlist
+func getWaitlistEntryOfLevel(w http.ResponseWriter, r *http.Request, levelId int) (*db.WaitlistEntry, bool) {
+	entryId, ok := pathInt(w, r, "entry_id")
+	if !ok {
+		return nil, false
+	}
+	e, err := db.GetWaitlistEntry(r.Context(), entryId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && e.LevelID != levelId) {
+		sendError(w, r, apierror.New(apierror.WaitlistEntryNotFound, "level %d has no waitlist entry %d", levelId, entryId))
+		return nil, false
+	}
+	if err != nil {
+		sendError(w, r, err)
+		return nil, false
+	}
+	return e, true
+}
+
+// offerWaitlist offers a level's free spots to its waitlist, and sends a
+// message for every offer. Failures are logged, whatever freed the spot
+// has already happened.
+func offerWaitlist(ctx context.Context, levelId int) {
+	logger := logging.FromContext(ctx)
+	offered, err := db.OfferOpenSpots(ctx, levelId, WaitlistOfferHold)
+	if err != nil {
+		logger.Error("could not offer spots to the waitlist", logging.Fields{"levelId": levelId, "error": err})
+		return
+	}
+	if len(offered) == 0 {
+		return
+	}
+
+	level, err := db.GetLevel(ctx, levelId)
+	if err != nil {
+		logger.Error("could not get the level for waitlist offers", logging.Fields{"levelId": levelId, "error": err})
+		return
+	}
+	eventName := ""
+	if event, err := db.GetEvent(ctx, level.EventID, 0); err == nil {
+		eventName = event.Name
+	}
+	for _, e := range offered {
+		organization := ""
+		if s, err := db.GetSponsor(ctx, e.SponsorID); err == nil {
+			organization = s.Name
+		}
+		logger.Info("offered a spot to the waitlist", logging.Fields{"levelId": levelId, "waitlistEntryId": e.ID, "sponsorId": e.SponsorID})
+		publish(ctx, WaitlistOfferedQueue, map[string]interface{}{
+			"id":           e.ID,
+			"eventId":      level.EventID,
+			"eventName":    eventName,
+			"levelId":      level.ID,
+			"sponsorLevel": level.Name,
+			"sponsorId":    e.SponsorID,
+			"organization": organization,
+			"offeredAt":    e.OfferedAt,
+			"expiresAt":    e.OfferExpiresAt,
+			"acceptUrl":    acceptURL(level.EventID, e),
+		}, *e.OfferedAt)
+	}
+}
+
+// SweepWaitlists ends offers nobody took in time every interval, and
+// offers their spots to whoever is next, until ctx is done
+func SweepWaitlists(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			levelIds, err := db.ExpireWaitlistOffers(ctx, now)
+			if err != nil {
+				logging.Error("could not expire waitlist offers", logging.Fields{"error": err})
+				continue
+			}
+			for _, id := range levelIds {
+				offerWaitlist(ctx, id)
+			}
+		}
+	}
+}
+
+// List a level's waitlist, first in line first
+func GetWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := db.GetWaitlist(r.Context(), l.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	entries := []WaitlistEntry{}
+	for i, e := range results {
+		entries = append(entries, toWaitlistEntry(e, i+1))
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"waitlist": entries,
+		},
+	})
+}
+
+// Put a sponsor of the event at the end of a level's waitlist. When the
+// level has a free spot the sponsor is offered it straight away.
+func JoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	var body WaitlistRequest
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	s, ok := getSponsorOfEvent(w, r, *body.SponsorID, event.ID)
+	if !ok {
+		return
+	}
+
+	result, err := db.JoinWaitlist(r.Context(), l.ID, s.ID)
+	if errors.Is(err, db.ErrDuplicate) {
+		sendError(w, r, apierror.New(apierror.AlreadyWaitlisted, "%s is already on the %s waitlist", s.Name, l.Name))
+		return
+	} else if errors.Is(err, db.ErrAlreadyOnLevel) {
+		sendError(w, r, apierror.New(apierror.AlreadyOnLevel, "%s already has the %s level", s.Name, l.Name))
+		return
+	} else if errors.Is(err, db.ErrSponsorCancelled) {
+		sendError(w, r, apierror.New(apierror.SponsorCancelled, "%s is cancelled, it can't wait for a spot", s.Name))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	offerWaitlist(r.Context(), l.ID)
+	if latest, err := db.GetWaitlistEntry(r.Context(), result.ID); err == nil {
+		result = latest
+	}
+	position, err := waitlistPosition(r.Context(), *result)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"entry": toWaitlistEntry(*result, position),
+		},
+	})
+}
+
+// Take a sponsor off a waitlist. If it had an offer, the spot goes to the next in line.
+func LeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	e, ok := getWaitlistEntryOfLevel(w, r, l.ID)
+	if !ok {
+		return
+	}
+
+	result, err := db.WithdrawFromWaitlist(r.Context(), e.ID)
+	if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"entry": toWaitlistEntry(*result, 0),
+		},
+	})
+
+	if e.Status == db.WaitlistOffered {
+		offerWaitlist(r.Context(), l.ID)
+	}
+}
+
+// Take the spot a waitlist entry was offered, which moves the sponsor onto the level
+func AcceptWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	event, l, ok := eventLevel(w, r)
+	if !ok {
+		return
+	}
+	e, ok := getWaitlistEntryOfLevel(w, r, l.ID)
+	if !ok {
+		return
+	}
+
+	entry, sponsor, previous, err := db.AcceptWaitlistOffer(r.Context(), e.ID, actor(r))
+	if errors.Is(err, db.ErrNoOffer) {
+		sendError(w, r, apierror.New(apierror.NoWaitlistOffer, "waitlist entry %d has no offer to accept, it may have expired", e.ID))
+		return
+	} else if errors.Is(err, db.ErrAlreadyOnLevel) {
+		sendError(w, r, apierror.New(apierror.AlreadyOnLevel, "sponsor %d already has the %s level", e.SponsorID, l.Name))
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	setETag(w, sponsor.Version)
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data: map[string]interface{}{
+			"entry":   toWaitlistEntry(*entry, 0),
+			"sponsor": toSponsor(*sponsor, event, toLevel(*l)),
+		},
+	})
+
+	if sponsor.Status != previous.Status {
+		publishStatusChange(r.Context(), event, sponsor, previous.Status, "took a waitlist offer")
+	}
+	// It may have left a spot on another level
+	if previous.LevelID != nil {
+		offerWaitlist(r.Context(), *previous.LevelID)
+	}
+}
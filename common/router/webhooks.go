@@ -0,0 +1,274 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checks payment provider callbacks are signed with our secret, main sets
+// this when a webhook secret is configured
+var PaymentWebhooks *auth.Webhooks
+
+// Provider events we act on
+const (
+	webhookPaymentSucceeded = "payment_intent.succeeded"
+	webhookRefundCreated    = "refund.created"
+)
+
+// Longest provider event ID we'll store, Stripe's are about 30 characters
+const maxWebhookEventId = 255
+
+// What we read of a provider's callback, Stripe's event shape
+type providerEvent struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object providerObject `json:"object"`
+	} `json:"data"`
+}
+
+// A payment intent or a refund, which share most of their fields
+type providerObject struct {
+	Id             string `json:"id"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+	// The payment a refund gives money back from
+	PaymentIntent string `json:"payment_intent"`
+	// Set when the payment is made, invoiceId or invoiceReference says
+	// which of our invoices it pays
+	Metadata map[string]string `json:"metadata"`
+}
+
+// WebhookEvent JSON struct, what became of a callback
+type WebhookEvent struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail"`
+	PaymentId *int   `json:"paymentId"`
+	// The provider sent it before, this is what happened the first time
+	Duplicate  bool      `json:"duplicate"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+func toWebhookEvent(e db.WebhookEvent, duplicate bool) WebhookEvent {
+	return WebhookEvent{
+		Id:         e.ID,
+		Type:       e.Type,
+		Status:     e.Status,
+		Detail:     e.Detail,
+		PaymentId:  e.PaymentID,
+		Duplicate:  duplicate,
+		ReceivedAt: e.CreatedAt,
+	}
+}
+
+func sendWebhookEvent(w http.ResponseWriter, e db.WebhookEvent, duplicate bool) {
+	json.NewEncoder(w).Encode(HttpResponseJSON{
+		Success: true,
+		Data:    map[string]interface{}{"webhookEvent": toWebhookEvent(e, duplicate)},
+	})
+}
+
+// The invoice a payment intent's metadata points at
+func webhookInvoice(r *http.Request, metadata map[string]string) (*db.Invoice, error) {
+	if raw := metadata["invoiceId"]; raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return db.GetInvoice(r.Context(), id)
+	}
+	if reference := metadata["invoiceReference"]; reference != "" {
+		return db.GetInvoiceByReference(r.Context(), reference)
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// Turns a callback into the ledger entry it stands for. An event that
+// isn't for us comes back as nil, with record's status and detail saying
+// why.
+func webhookPayment(r *http.Request, e providerEvent, record *db.WebhookEvent) (*db.Payment, error) {
+	object := e.Data.Object
+	payment := db.Payment{
+		Currency:   strings.ToUpper(object.Currency),
+		Method:     "card",
+		Reference:  object.Id,
+		RecordedBy: actor(r),
+		ReceivedAt: time.Unix(e.Created, 0),
+	}
+	if e.Created == 0 {
+		payment.ReceivedAt = time.Now()
+	}
+
+	switch e.Type {
+	case webhookPaymentSucceeded:
+		invoice, err := webhookInvoice(r, object.Metadata)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			record.Status, record.Detail = db.WebhookUnmatched, "its metadata doesn't name one of our invoices"
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		payment.Kind = db.PaymentReceived
+		payment.SponsorID = invoice.SponsorID
+		payment.InvoiceID = &invoice.ID
+		payment.Amount = object.AmountReceived
+		if payment.Amount == 0 {
+			payment.Amount = object.Amount
+		}
+
+	case webhookRefundCreated:
+		paid, err := db.GetPaymentByReference(r.Context(), object.PaymentIntent)
+		if object.PaymentIntent == "" || errors.Is(err, gorm.ErrRecordNotFound) {
+			record.Status, record.Detail = db.WebhookUnmatched, fmt.Sprintf("no payment was recorded for %q", object.PaymentIntent)
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		payment.Kind = db.PaymentRefund
+		payment.SponsorID = paid.SponsorID
+		payment.InvoiceID = paid.InvoiceID
+		payment.Amount = object.Amount
+
+	default:
+		record.Status = db.WebhookIgnored
+		return nil, nil
+	}
+
+	if payment.Amount <= 0 {
+		record.Status, record.Detail = db.WebhookRejected, "it has no amount"
+		return nil, nil
+	}
+	if payment.Currency != InvoiceCurrency {
+		record.Status, record.Detail = db.WebhookRejected, fmt.Sprintf("it's in %s, invoices are in %s", payment.Currency, InvoiceCurrency)
+		return nil, nil
+	}
+	return &payment, nil
+}
+
+// Handle a callback from the payment provider. A succeeded payment intent
+// is recorded against the invoice its metadata names, and a refund against
+// the invoice of the payment it refunds. Every callback gets a 200 once
+// it's been handled, even when it's not for us, so the provider stops
+// sending it; each event is only ever handled once.
+func ReceivePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if PaymentWebhooks == nil {
+		sendError(w, r, apierror.New(apierror.NotImplemented, "payment webhooks are turned off, set INVOICE_WEBHOOK_SECRET to turn them on"))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "could not read the body"))
+		return
+	}
+	var e providerEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "the body is not a provider event: %s", err))
+		return
+	}
+	if e.Id == "" || e.Type == "" || len(e.Id) > maxWebhookEventId {
+		sendError(w, r, apierror.New(apierror.MalformedRequest, "the event needs an id of at most %d characters and a type", maxWebhookEventId))
+		return
+	}
+
+	record := db.WebhookEvent{ID: e.Id, Type: e.Type}
+	if err := db.ClaimWebhookEvent(r.Context(), &record); errors.Is(err, db.ErrDuplicate) {
+		first, err := db.GetWebhookEvent(r.Context(), e.Id)
+		if err != nil {
+			sendError(w, r, err)
+			return
+		}
+		if first.Status == db.WebhookReceived {
+			sendError(w, r, apierror.New(apierror.RequestInProgress, "event %s is still being handled", e.Id))
+			return
+		}
+		sendWebhookEvent(w, *first, true)
+		return
+	} else if err != nil {
+		sendError(w, r, err)
+		return
+	}
+	// Anything that goes wrong on our side lets the provider's retry start over
+	fail := func(err error) {
+		if err := db.ReleaseWebhookEvent(r.Context(), e.Id); err != nil {
+			logging.FromContext(r.Context()).Error("could not release webhook event", logging.Fields{"eventId": e.Id, "error": err})
+		}
+		sendError(w, r, err)
+	}
+
+	payment, err := webhookPayment(r, e, &record)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if payment == nil {
+		if err := db.FinishWebhookEvent(r.Context(), &record); err != nil {
+			fail(err)
+			return
+		}
+		sendWebhookEvent(w, record, false)
+		return
+	}
+
+	result, invoice, sponsor, change, err := db.RecordPayment(r.Context(), *payment)
+	if errors.Is(err, db.ErrInvoiceNotPayable) {
+		record.Status, record.Detail = db.WebhookRejected, "the invoice isn't issued"
+	} else if errors.Is(err, db.ErrRefundTooLarge) {
+		record.Status, record.Detail = db.WebhookRejected, "the refund is more than the sponsor paid"
+	} else if err != nil {
+		fail(err)
+		return
+	} else {
+		record.Status, record.PaymentID = db.WebhookProcessed, &result.ID
+	}
+	if err := db.FinishWebhookEvent(r.Context(), &record); err != nil {
+		fail(err)
+		return
+	}
+	if record.Status != db.WebhookProcessed {
+		logging.FromContext(r.Context()).Warn("payment webhook rejected", logging.Fields{"eventId": e.Id, "reason": record.Detail})
+		sendWebhookEvent(w, record, false)
+		return
+	}
+
+	audit(r, AuditPaymentRecorded, sponsor.ID, map[string]interface{}{
+		"paymentId":      result.ID,
+		"kind":           result.Kind,
+		"amount":         result.Amount,
+		"invoiceId":      result.InvoiceID,
+		"webhookEventId": e.Id,
+	})
+	sendWebhookEvent(w, record, false)
+
+	event, err := db.GetEvent(r.Context(), sponsor.EventID, -1)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not get the event to announce a payment", logging.Fields{"eventId": sponsor.EventID, "error": err})
+		return
+	}
+	if result.Kind == db.PaymentReceived {
+		balance, err := db.GetBalance(r.Context(), sponsor.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("could not get the balance to announce a payment", logging.Fields{"sponsorId": sponsor.ID, "error": err})
+		} else {
+			publishPaymentReceived(r.Context(), event, sponsor, result, invoice, balance)
+		}
+	}
+	if change != nil {
+		publishStatusChange(r.Context(), event, sponsor, change.From, change.Reason)
+	}
+}
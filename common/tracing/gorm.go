@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// GormPlugin adds a span for every query gorm runs, add it with db.Database.Use.
+// Spans hang off the context the query was made with (Database.WithContext).
+type GormPlugin struct{}
+
+const spanKey = "tracing:span"
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tracing:before_create", startSpan("create")); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:create").Register("tracing:after_create", endSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tracing:before_query", startSpan("query")); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:query").Register("tracing:after_query", endSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tracing:before_update", startSpan("update")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("tracing:after_update", endSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startSpan("delete")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tracing:before_row", startSpan("row")); err != nil {
+		return err
+	}
+	if err := callbacks.Row().After("gorm:row").Register("tracing:after_row", endSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", startSpan("raw")); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", endSpan)
+}
+
+func startSpan(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := Tracer().Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationKey.String(operation),
+			),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	// The SQL is only known once gorm has built it
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.String("db.sql.table", db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
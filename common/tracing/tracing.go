@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/r3dcrosse/sponsor-service/common/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name the service shows up as in the tracing backend
+const ServiceName = "sponsor-service"
+
+// Name our own spans are reported under
+const instrumentationName = "github.com/r3dcrosse/sponsor-service"
+
+// Tracer starts spans for the service's own code (gorm queries, AMQP).
+// Until Init runs it hands out spans that do nothing.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Init sets up the global tracer provider and the W3C trace context
+// propagator. The returned func flushes any spans still buffered and
+// should be called before the service exits.
+func Init(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	// Propagate even when we don't export, so traces from other services
+	// aren't cut in two when they pass through us
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		e, err := stdout.NewExporter(stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("could not create the stdout trace exporter | %w", err)
+		}
+		exporter = e
+	case "otlp":
+		options := []otlpgrpc.Option{otlpgrpc.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			options = append(options, otlpgrpc.WithInsecure())
+		}
+		e, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(options...))
+		if err != nil {
+			return nil, fmt.Errorf("could not create the OTLP trace exporter | %w", err)
+		}
+		exporter = e
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
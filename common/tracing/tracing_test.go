@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"github.com/r3dcrosse/sponsor-service/common/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+)
+
+// Records every span ended from here on
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestInit(t *testing.T) {
+	shutdown, err := Init(context.Background(), config.TracingConfig{Exporter: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutting down got %v", err)
+	}
+	// Propagated even when nothing's exported
+	if fields := otel.GetTextMapPropagator().Fields(); !strings.Contains(strings.Join(fields, ","), "traceparent") {
+		t.Errorf("propagator fields got %v, want W3C trace context", fields)
+	}
+
+	if _, err := Init(context.Background(), config.TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Error("an unknown exporter got no error")
+	}
+}
+
+type widget struct {
+	ID   int
+	Name string
+}
+
+func TestGormPluginSpansHangOffTheQuery(t *testing.T) {
+	exporter := recordSpans()
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := conn.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+		defer sqlDB.Close()
+	}
+	if err := conn.AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := Tracer().Start(context.Background(), "request")
+	conn.WithContext(ctx).Create(&widget{Name: "Doge"})
+	conn.WithContext(ctx).First(&widget{}, 99)
+	parent.End()
+
+	spans := map[string]*sdktrace.SpanSnapshot{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	for name, statement := range map[string]string{"gorm.create": "INSERT INTO", "gorm.query": "SELECT"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("got no %s span, got %v", name, spans)
+			continue
+		}
+		if s.Parent.SpanID() != parent.SpanContext().SpanID() || s.SpanKind != trace.SpanKindClient {
+			t.Errorf("%s got parent %s and kind %s, want the request as its parent", name, s.Parent.SpanID(), s.SpanKind)
+		}
+		if got := attributeValue(s.Attributes, semconv.DBStatementKey); !strings.HasPrefix(got, statement) {
+			t.Errorf("%s got statement %q, want %s...", name, got, statement)
+		}
+		if got := attributeValue(s.Attributes, "db.sql.table"); got != "widgets" {
+			t.Errorf("%s got table %q, want widgets", name, got)
+		}
+	}
+	// Not finding a row isn't a failed query
+	if s := spans["gorm.query"]; s != nil && s.StatusCode == codes.Error {
+		t.Errorf("a query with no rows got status %s, want it left unset", s.StatusCode)
+	}
+}
@@ -0,0 +1,113 @@
+// Package validation checks request bodies and collects every problem
+// with them, so a client can fix them all at once.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules a field can break
+const (
+	RuleRequired  = "required"
+	RuleMaxLength = "maxLength"
+	RuleMin       = "min"
+	RuleMax       = "max"
+	RuleEmail     = "email"
+	RuleFormat    = "format"
+	RuleOneOf     = "oneOf"
+	RuleUnknown   = "unknown"
+	RuleType      = "type"
+	// Field is known, but can't be sent along with the others
+	RuleNotAllowed = "notAllowed"
+)
+
+// FieldError is one rule one field broke. Field is the JSON path,
+// like name or levels[1].cost.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors is every rule a request body broke
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, f := range e {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Validator collects field errors, a zero Validator is ready to use
+type Validator struct {
+	errors Errors
+}
+
+// Err returns the collected Errors, nil when there are none
+func (v *Validator) Err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+// Add records a field error
+func (v *Validator) Add(field string, rule string, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check adds the field error when ok is false, and returns ok
+func (v *Validator) Check(ok bool, field string, rule string, format string, args ...interface{}) bool {
+	if !ok {
+		v.Add(field, rule, format, args...)
+	}
+	return ok
+}
+
+// Required checks value isn't empty or only whitespace
+func (v *Validator) Required(field string, value string) bool {
+	return v.Check(strings.TrimSpace(value) != "", field, RuleRequired, "is required")
+}
+
+// MaxLength checks value has at most max characters
+func (v *Validator) MaxLength(field string, value string, max int) bool {
+	return v.Check(utf8.RuneCountInString(value) <= max, field, RuleMaxLength, "must be at most %d characters", max)
+}
+
+func (v *Validator) Min(field string, value int, min int) bool {
+	return v.Check(value >= min, field, RuleMin, "must be at least %d", min)
+}
+
+func (v *Validator) Max(field string, value int, max int) bool {
+	return v.Check(value <= max, field, RuleMax, "must be at most %d", max)
+}
+
+// Between checks an int64, like an amount, is from min to max. It's one
+// error, for whichever end value is past.
+func (v *Validator) Between(field string, value int64, min int64, max int64) bool {
+	if value < min {
+		return v.Check(false, field, RuleMin, "must be at least %d", min)
+	}
+	return v.Check(value <= max, field, RuleMax, "must be at most %d", max)
+}
+
+// Email checks value is a bare address, like first.last@doge.com
+func (v *Validator) Email(field string, value string) bool {
+	address, err := mail.ParseAddress(value)
+	ok := err == nil && address.Address == value && strings.Contains(value[strings.LastIndex(value, "@"):], ".")
+	return v.Check(ok, field, RuleEmail, "must be an email address")
+}
+
+func (v *Validator) OneOf(field string, value string, options ...string) bool {
+	for _, o := range options {
+		if value == o {
+			return true
+		}
+	}
+	return v.Check(false, field, RuleOneOf, "must be one of %s", strings.Join(options, ", "))
+}
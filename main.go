@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/r3dcrosse/sponsor-service/common/apierror"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/blob"
 	"github.com/r3dcrosse/sponsor-service/common/circuitbreaker"
+	"github.com/r3dcrosse/sponsor-service/common/config"
 	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/health"
+	"github.com/r3dcrosse/sponsor-service/common/idempotency"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
 	"github.com/r3dcrosse/sponsor-service/common/messaging"
+	"github.com/r3dcrosse/sponsor-service/common/metrics"
+	"github.com/r3dcrosse/sponsor-service/common/openapi"
 	"github.com/r3dcrosse/sponsor-service/common/router"
+	"github.com/r3dcrosse/sponsor-service/common/tracing"
 	"github.com/streadway/amqp"
-	"log"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 	"net/http"
+	"os"
+	"time"
 )
 
 type LevelMessage struct {
@@ -27,7 +40,7 @@ type EventMessage struct {
 }
 
 // Callback functions for everytime we get a message from rabbit mq
-func onEventCreatedMessage(delivery amqp.Delivery) {
+func onEventCreatedMessage(ctx context.Context, delivery amqp.Delivery) error {
 	msg := string(delivery.Body)
 
 	// Format of the message will come in this shape:
@@ -49,13 +62,13 @@ func onEventCreatedMessage(delivery amqp.Delivery) {
 
 	dat := EventMessage{}
 	if err := json.Unmarshal([]byte(msg), &dat); err != nil {
-		fmt.Printf("Could not parse new event json from rabbitmq message | %s", err)
-		return
+		return fmt.Errorf("could not parse new event json from rabbitmq message | %w", err)
 	}
 
 	// Save the event in the DB
-	savedEvent := db.CreateEvent(dat.Name, dat.Id)
+	savedEvent := db.CreateEvent(ctx, dat.Name, dat.Id)
 
+	failedLevels := 0
 	for _, l := range dat.SponsorLevels {
 		level := router.Level{
 			Name:                    l.Name,
@@ -63,11 +76,24 @@ func onEventCreatedMessage(delivery amqp.Delivery) {
 			MaxFreeBadgesPerSponsor: l.MaxFreeBadges,
 		}
 
-		db.CreateLevel(level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, savedEvent.ID)
+		_, err := db.CreateLevel(ctx, level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, savedEvent.ID)
+		if err != nil {
+			logging.FromContext(ctx).Error("could not save level for the new event", logging.Fields{
+				"eventId": dat.Id,
+				"level":   level.Name,
+				"error":   err,
+			})
+			failedLevels++
+		}
 	}
+
+	if failedLevels > 0 {
+		return fmt.Errorf("%d level(s) of event %d could not be saved", failedLevels, dat.Id)
+	}
+	return nil
 }
 
-func onEventModifiedMessage(delivery amqp.Delivery) {
+func onEventModifiedMessage(ctx context.Context, delivery amqp.Delivery) error {
 	msg := string(delivery.Body)
 
 	// Format of the message will come in this shape:
@@ -89,17 +115,15 @@ func onEventModifiedMessage(delivery amqp.Delivery) {
 
 	dat := EventMessage{}
 	if err := json.Unmarshal([]byte(msg), &dat); err != nil {
-		fmt.Printf("Could not parse new event json from rabbitmq message | %s", err.Error())
-		return
+		return fmt.Errorf("could not parse modified event json from rabbitmq message | %w", err)
 	}
 
 	// fetch the item in the DB
 	// We send in -1 as our service ID since the event already
 	// has an ID from the events service
-	result, err := db.GetEvent(-1, dat.Id)
+	result, err := db.GetEvent(ctx, -1, dat.Id)
 	if err != nil {
-		fmt.Printf("Could not find the event to update based on the event ID | %s", err.Error())
-		return
+		return fmt.Errorf("could not find the event to update based on the event ID | %w", err)
 	}
 
 	var levels []router.Level
@@ -124,6 +148,12 @@ func onEventModifiedMessage(delivery amqp.Delivery) {
 		}
 	}
 
+	// Levels are matched by name, the event service doesn't know our IDs
+	existing := map[string]db.Level{}
+	for _, l := range result.Levels {
+		existing[l.Name] = l
+	}
+
 	if dat.SponsorLevels != nil {
 		for _, l := range dat.SponsorLevels {
 			level := router.Level{
@@ -132,76 +162,275 @@ func onEventModifiedMessage(delivery amqp.Delivery) {
 				MaxFreeBadgesPerSponsor: l.MaxFreeBadges,
 			}
 
-			result := db.CreateLevel(level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, result.ID)
-			level.Id = result.ID
-			level.EventID = result.EventID
-			level.MaxSponsors = result.MaxNumberOfSponsors
+			var saved *db.Level
+			if current, ok := existing[level.Name]; ok {
+				// The message has no max sponsors, so ours is kept
+				saved, err = db.UpdateLevel(ctx, current.ID, level.Name, level.Cost, current.MaxNumberOfSponsors, level.MaxFreeBadgesPerSponsor, result.ID, 0)
+			} else {
+				saved, err = db.CreateLevel(ctx, level.Name, level.Cost, level.MaxSponsors, level.MaxFreeBadgesPerSponsor, result.ID)
+			}
+			if err != nil {
+				logging.FromContext(ctx).Error("could not save level for the modified event", logging.Fields{
+					"eventId": dat.Id,
+					"level":   level.Name,
+					"error":   err,
+				})
+				continue
+			}
+			level.Id = saved.ID
+			level.EventID = saved.EventID
+			level.MaxSponsors = saved.MaxNumberOfSponsors
 
 			levels = append(levels, level)
 		}
 	}
 
-	db.UpdateEvent(result.ID, event.Name)
+	_, err = db.UpdateEvent(ctx, result.ID, event.Name, 0)
+	return err
 }
 
 func failOnError(err error, msg string) {
 	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+		logging.Fatal(msg, logging.Fields{"error": err})
 	}
 }
 
 var MessagingClient messaging.IRabbitMQClient
 
+// How often responses stored for Idempotency-Keys are checked for expiry
+const idempotencySweepInterval = 10 * time.Minute
+
+// How often waitlist offers and reservations are checked for expiry
+const waitlistSweepInterval = time.Minute
+
+// Things /readyz checks before we get any traffic
+func readinessChecks(client messaging.IRabbitMQClient, queues config.Queues) health.Checks {
+	return health.Checks{
+		"database":       health.DatabaseCheck(),
+		"messaging":      health.MessagingCheck(client),
+		"consumers":      health.ConsumersCheck(client, []string{queues.EventCreated, queues.EventModified}),
+		"circuitBreaker": health.CircuitBreakerCheck(),
+	}
+}
+
+// Sets up every route, with the auth each one needs. The database
+// has to be set up first, for the API keys.
+func newRouter(cfg *config.Config, checks health.Checks) *mux.Router {
+	r := mux.NewRouter()
+	// Invite tokens come out of the URL before it's traced
+	r.Use(auth.InviteTokenToHeader, otelmux.Middleware(tracing.ServiceName), logging.Middleware, metrics.Middleware)
+	r.NotFoundHandler = apierror.NotFoundHandler()
+	r.MethodNotAllowedHandler = apierror.MethodNotAllowedHandler()
+
+	// Health checks for the orchestrator
+	r.HandleFunc("/healthz", health.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", health.Readiness(checks)).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.Handle("/openapi.json", openapi.Handler()).Methods("GET")
+
+	// Sponsor invite links, they only work on the portal routes
+	inviteSecret := cfg.Auth.InviteSecret
+	if inviteSecret == "" {
+		var err error
+		inviteSecret, err = auth.NewInviteSecret()
+		failOnError(err, "Could not make an invite secret")
+		logging.Warn("no invite secret is set, invite links will stop working when the service restarts")
+	}
+	router.Invites = auth.NewInvites(inviteSecret)
+	router.PublicURL = cfg.HTTP.PublicURL
+
+	// Payment provider callbacks are signed rather than sent with an API key,
+	// so they're also kept away from the API's auth
+	webhooks := r.PathPrefix("/sponsor-service/v1/webhooks").Subrouter()
+	if cfg.Invoices.WebhookSecret != "" {
+		router.PaymentWebhooks = auth.NewWebhooks(cfg.Invoices.WebhookSecret, cfg.Invoices.WebhookTolerance.Duration)
+		webhooks.Use(auth.Middleware(router.PaymentWebhooks))
+	}
+	webhooks.HandleFunc("/payments", router.ReceivePaymentWebhook).Methods("POST")
+
+	// Sponsor logos, public so they can be shown anywhere. Their keys are
+	// random, so only the URLs we hand out reach them.
+	assets, err := blob.NewFileStore(cfg.Assets.Dir)
+	failOnError(err, "Could not set up the assets directory")
+	router.Assets = assets
+	router.MaxLogoSize = int64(cfg.Assets.MaxLogoSize)
+	r.HandleFunc(router.AssetsPath+"{key}", router.GetAsset).Methods("GET")
+
+	// Registered before the rest of the API so its auth doesn't catch them
+	portal := r.PathPrefix("/sponsor-service/v1/portal").Subrouter()
+	portal.Use(auth.Middleware(router.Invites), idempotency.Middleware(cfg.HTTP.IdempotencyTTL.Duration, router.MaxBodySize()))
+	portal.HandleFunc("/members", router.GetPortalMembers).Methods("GET")
+	portal.HandleFunc("/members", router.CreatePortalMember).Methods("POST")
+	portal.HandleFunc("/members/{member_id}", router.RemovePortalMember).Methods("DELETE")
+
+	// Everything else under /sponsor-service/v1 needs an API key or JWT
+	api := r.PathPrefix("/sponsor-service/v1").Subrouter()
+	if cfg.Auth.Enabled {
+		authenticators := []auth.Authenticator{auth.APIKeys{}}
+		if cfg.Auth.JWKSFile != "" {
+			jwtAuth, err := auth.NewJWT(cfg.Auth.JWKSFile, cfg.Auth.Issuer, cfg.Auth.Audience)
+			failOnError(err, "Could not load the JWT keys")
+			authenticators = append(authenticators, jwtAuth)
+		}
+		api.Use(auth.Middleware(authenticators...))
+
+		err := auth.EnsureBootstrapKey(context.Background(), cfg.Auth.BootstrapKey)
+		failOnError(err, "Could not create the bootstrap API key")
+	} else {
+		// Handlers see no principal, so every role check and the admin
+		// endpoints let everyone through
+		logging.Warn("auth is turned off, anyone who can reach the service can use the whole API, admin endpoints included")
+	}
+	// After auth, keys belong to whoever sent them
+	api.Use(idempotency.Middleware(cfg.HTTP.IdempotencyTTL.Duration, router.MaxBodySize()))
+
+	// Route handles and endpoints
+	api.HandleFunc("/events", router.GetAllEvents).Methods("GET")
+	api.HandleFunc("/event/{id}", router.GetEvent).Methods("GET")
+	api.HandleFunc("/event", router.CreateEvent).Methods("POST")
+	api.HandleFunc("/event/{id}", router.PatchEvent).Methods("PATCH")
+	api.HandleFunc("/event/{event_id}/level", router.CreateLevel).Methods("POST")
+	api.HandleFunc("/event/{event_id}/level/{level_id}", router.GetLevel).Methods("GET")
+	api.HandleFunc("/event/{event_id}/level/{level_id}", router.PatchLevel).Methods("PATCH")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/waitlist", router.GetWaitlist).Methods("GET")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/waitlist", router.JoinWaitlist).Methods("POST")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/waitlist/{entry_id}", router.LeaveWaitlist).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/waitlist/{entry_id}/accept", router.AcceptWaitlistOffer).Methods("POST")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/benefit", router.GetBenefits).Methods("GET")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/benefit", router.CreateBenefit).Methods("POST")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/benefit/{benefit_id}", router.DeleteBenefit).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/reservation", router.GetReservations).Methods("GET")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/reservation", router.CreateReservation).Methods("POST")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/reservation/{reservation_id}", router.ReleaseReservation).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/level/{level_id}/reservation/{reservation_id}/convert", router.ConvertReservation).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor", router.CreateSponsor).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}", router.GetSponsor).Methods("GET")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}", router.PatchSponsor).Methods("PATCH")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}", router.DeleteSponsor).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/logo", router.UploadSponsorLogo).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/logo", router.DeleteSponsorLogo).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/status", router.GetSponsorStatus).Methods("GET")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/status", router.ChangeSponsorStatus).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/invoice", router.CreateInvoice).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/deliverable", router.GetDeliverables).Methods("GET")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/deliverable/{deliverable_id}", router.PatchDeliverable).Methods("PATCH")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/payment", router.RecordPayment).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/ledger", router.GetLedger).Methods("GET")
+	api.HandleFunc("/event/{event_id}/report", router.GetEventReport).Methods("GET")
+	api.HandleFunc("/event/{event_id}/deliverable", router.GetEventDeliverables).Methods("GET")
+	api.HandleFunc("/event/{event_id}/invoice", router.GetInvoices).Methods("GET")
+	api.HandleFunc("/event/{event_id}/invoice/{invoice_id}", router.GetInvoice).Methods("GET")
+	api.HandleFunc("/event/{event_id}/invoice/{invoice_id}/pdf", router.GetInvoicePDF).Methods("GET")
+	api.HandleFunc("/event/{event_id}/invoice/{invoice_id}/status", router.ChangeInvoiceStatus).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member", router.GetMembers).Methods("GET")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member", router.CreateMember).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}", router.GetMember).Methods("GET")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}", router.PatchMember).Methods("PATCH")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}", router.RemoveMember).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}/badge", router.IssueBadge).Methods("POST")
+	api.HandleFunc("/event/{event_id}/badge", router.GetBadges).Methods("GET")
+	api.HandleFunc("/event/{event_id}/badge/sheet", router.GetBadgeSheet).Methods("GET")
+	api.HandleFunc("/event/{event_id}/badge/{badge_id}/png", router.GetBadgeQRCode).Methods("GET")
+	api.HandleFunc("/event/{event_id}/badge/{badge_id}/status", router.ChangeBadgeStatus).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/invites", router.CreateInvite).Methods("POST")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/invites/{invite_id}", router.RevokeInvite).Methods("DELETE")
+	api.HandleFunc("/event/{event_id}/sponsor/{sponsor_id}/audit", router.GetSponsorAudit).Methods("GET")
+
+	// Admin only
+	admin := api.PathPrefix("/admin").Subrouter()
+	if cfg.Auth.Enabled {
+		admin.Use(auth.RequireAdmin)
+	}
+	admin.HandleFunc("/api-keys", router.GetAllAPIKeys).Methods("GET")
+	admin.HandleFunc("/api-keys", router.CreateAPIKey).Methods("POST")
+	admin.HandleFunc("/api-keys/{id}", router.RevokeAPIKey).Methods("DELETE")
+
+	return r
+}
+
 func main() {
-	circuitbreaker.InitCircuitBreaker()
+	// Get the config from the config file, environment and any cmd line args passed to this service
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	failOnError(err, "Could not load config")
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	failOnError(err, "Could not set the log level")
+	logging.SetLevel(level)
+	logging.Info("starting", logging.Fields{"config": cfg.Redacted()})
+
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	failOnError(err, "Could not set up tracing")
 
-	// Get any cmd line args passed to this service
-	rabbitMQip := flag.String("rabbit", "localhost:5672", "IP Address and port where rabbitMQ is running")
-	postgresIp := flag.String("pg_ip", "localhost", "IP Address where postgres is running")
-	postgresPort := flag.String("pg_port", "5432", "Port where postgres is running")
-	postgresUser := flag.String("pg_user", "user", "User to use to login to postgres")
-	postgresPass := flag.String("pg_password", "hey", "Password to use to login to postgres")
-	postgresDbName := flag.String("pg_dbname", "postgres", "The db name to connect to")
-	postgresSSL := flag.String("pg_ssl", "disable", "Run with ssl mode?")
-	flag.Parse()
+	circuitbreaker.InitCircuitBreaker()
 
 	// Initialize DB
 	db.InitDB(db.Creds{
-		Host:     *postgresIp,
-		Port:     *postgresPort,
-		User:     *postgresUser,
-		Password: *postgresPass,
-		Dbname:   *postgresDbName,
-		Sslmode:  *postgresSSL,
+		Driver:          cfg.Database.Driver,
+		Host:            cfg.Database.Host,
+		Port:            cfg.Database.Port,
+		User:            cfg.Database.User,
+		Password:        cfg.Database.Password,
+		Dbname:          cfg.Database.Name,
+		Sslmode:         cfg.Database.SSLMode,
+		SqlitePath:      cfg.Database.SqlitePath,
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime.Duration,
 	})
+	err = db.Database.Use(metrics.GormPlugin{})
+	failOnError(err, "Could not add metrics to gorm")
+	err = db.Database.Use(tracing.GormPlugin{})
+	failOnError(err, "Could not add tracing to gorm")
+	go idempotency.Sweep(context.Background(), idempotencySweepInterval)
 
 	// Initialize RabbitMQ
-	MessagingClient = &messaging.RabbitMQClient{}
-	MessagingClient.ConnectToRabbitMQ(*rabbitMQip)
-
-	err := MessagingClient.SubscribeToQueue("event.create", "sponsor-service", onEventCreatedMessage)
-	failOnError(err, "Could not subscribe to channel event.create")
-
-	err = MessagingClient.SubscribeToQueue("event.modify", "sponsor-service", onEventModifiedMessage)
-	failOnError(err, "Could not subscribe to channel event.modify")
+	if cfg.Messaging.Broker == "memory" {
+		MessagingClient = &messaging.InMemoryClient{}
+	} else {
+		tlsConfig, err := cfg.Messaging.TLSClientConfig()
+		failOnError(err, "Could not set up TLS for RabbitMQ")
+		MessagingClient = &messaging.RabbitMQClient{TLSConfig: tlsConfig}
+	}
+	queues := cfg.Messaging.Queues
 
 	// Inject MessagingClient in router
 	// So we can use rabbitMQ there if we get a request to create a new sponsor member
 	router.MessagingClient = MessagingClient
+	router.MemberCreatedQueue = queues.MemberCreated
+	router.MemberUpdatedQueue = queues.MemberUpdated
+	router.WaitlistOfferedQueue = queues.WaitlistOffered
+	router.StatusChangedQueue = queues.StatusChanged
+	router.PaymentReceivedQueue = queues.PaymentReceived
+	router.WaitlistOfferHold = cfg.Sponsors.WaitlistOfferHold.Duration
+	go router.SweepWaitlists(context.Background(), waitlistSweepInterval)
+	router.ReservationHold = cfg.Sponsors.ReservationHold.Duration
+	go router.SweepReservations(context.Background(), waitlistSweepInterval)
+	router.InvoiceCurrency = cfg.Invoices.Currency
+	router.InvoicePaymentTerms = cfg.Invoices.PaymentTerms.Duration
+	router.InvoiceIssuer = cfg.Invoices.Issuer
 
 	// Initialize the router
-	r := mux.NewRouter()
+	r := newRouter(cfg, readinessChecks(MessagingClient, queues))
 
-	// Route handles and endpoints
-	r.HandleFunc("/sponsor-service/v1/events", router.GetAllEvents).Methods("GET")
-	r.HandleFunc("/sponsor-service/v1/event/{id}", router.GetEvent).Methods("GET")
-	r.HandleFunc("/sponsor-service/v1/event", router.CreateEvent).Methods("POST")
-	r.HandleFunc("/sponsor-service/v1/event/{id}", router.PatchEvent).Methods("PATCH")
-	r.HandleFunc("/sponsor-service/v1/event/{event_id}/level", router.CreateLevel).Methods("POST")
-	r.HandleFunc("/sponsor-service/v1/event/{event_id}/sponsor", router.CreateSponsor).Methods("POST")
-	r.HandleFunc("/sponsor-service/v1/event/{event_id}/sponsor/{sponsor_id}/member", router.CreateMember).Methods("POST")
-	r.HandleFunc("/sponsor-service/v1/event/{event_id}/sponsor/{sponsor_id}/member/{member_id}", router.RemoveMember).Methods("DELETE")
-
-	// Start server
-	log.Fatal(http.ListenAndServe(":8000", r))
+	// Start server before connecting to RabbitMQ, so /readyz can
+	// tell the orchestrator we're still waiting on it
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- http.ListenAndServe(fmt.Sprintf(":%d", cfg.HTTP.Port), r)
+	}()
+
+	MessagingClient.ConnectToRabbitMQ(cfg.Messaging.AMQPURL())
+
+	err = MessagingClient.SubscribeToQueue(queues.EventCreated, queues.ConsumerName, onEventCreatedMessage)
+	failOnError(err, "Could not subscribe to channel "+queues.EventCreated)
+
+	err = MessagingClient.SubscribeToQueue(queues.EventModified, queues.ConsumerName, onEventModifiedMessage)
+	failOnError(err, "Could not subscribe to channel "+queues.EventModified)
+
+	err = <-serverErr
+	// Send off any spans still waiting in the batch before we exit
+	shutdownTracing(context.Background())
+	failOnError(err, "HTTP server stopped")
 }
@@ -0,0 +1,1045 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/r3dcrosse/sponsor-service/common/auth"
+	"github.com/r3dcrosse/sponsor-service/common/circuitbreaker"
+	"github.com/r3dcrosse/sponsor-service/common/config"
+	"github.com/r3dcrosse/sponsor-service/common/db"
+	"github.com/r3dcrosse/sponsor-service/common/logging"
+	"github.com/r3dcrosse/sponsor-service/common/messaging"
+	"github.com/r3dcrosse/sponsor-service/common/router"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Contract tests: real requests go through the real routes, and every
+// response has to match what /openapi.json says it looks like
+
+var (
+	testRouter   *mux.Router
+	bootstrapKey = strings.Repeat("k", 40)
+	key          = "X-API-Key: " + bootstrapKey
+	// Signs the fixtures in testdata/webhooks, like the provider would
+	webhookSecret = "whsec_" + strings.Repeat("w", 32)
+	// The document as the service serves it
+	spec map[string]interface{}
+)
+
+func TestMain(m *testing.M) {
+	logging.SetOutput(ioutil.Discard)
+
+	cfg := config.Defaults()
+	cfg.Auth.BootstrapKey = bootstrapKey
+	cfg.Auth.InviteSecret = strings.Repeat("s", 32)
+	cfg.Invoices.WebhookSecret = webhookSecret
+	assets, err := ioutil.TempDir("", "sponsor-assets")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not make the assets directory:", err)
+		os.Exit(1)
+	}
+	cfg.Assets.Dir = assets
+	db.InitDB(db.Creds{Driver: db.DriverSqlite, SqlitePath: db.SqliteInMemory})
+	circuitbreaker.InitCircuitBreaker()
+	MessagingClient = &messaging.InMemoryClient{}
+	MessagingClient.ConnectToRabbitMQ("")
+	queues := cfg.Messaging.Queues
+	for queue, handler := range map[string]func(context.Context, amqp.Delivery) error{
+		queues.EventCreated:  onEventCreatedMessage,
+		queues.EventModified: onEventModifiedMessage,
+	} {
+		if err := MessagingClient.SubscribeToQueue(queue, queues.ConsumerName, handler); err != nil {
+			fmt.Fprintln(os.Stderr, "could not subscribe to", queue, err)
+			os.Exit(1)
+		}
+	}
+	router.MessagingClient = MessagingClient
+	testRouter = newRouter(cfg, readinessChecks(MessagingClient, queues))
+
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		fmt.Fprintln(os.Stderr, "/openapi.json is not JSON:", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(assets)
+	os.Exit(code)
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	routes := map[string]bool{}
+	testRouter.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// Subrouter prefixes have no methods
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routes[method+" "+path] = true
+			if operation(method, path) == nil {
+				t.Errorf("%s %s is not in /openapi.json", method, path)
+			}
+		}
+		return nil
+	})
+
+	for path, item := range spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			if method == "parameters" {
+				continue
+			}
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("/openapi.json has %s %s, but there is no such route", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// The event service sends the whole event again when it changes, levels
+// it already sent have to be updated rather than added
+func TestEventModifiedMessageUpdatesLevels(t *testing.T) {
+	freshDB(t)
+	ctx := context.Background()
+	send := func(handler func(context.Context, amqp.Delivery) error, body string) {
+		t.Helper()
+		if err := handler(ctx, amqp.Delivery{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(onEventCreatedMessage, `{"id":1337,"name":"Conf","sponsors":[{"name":"Platinum","cost":14500,"freeBadges":10}]}`)
+	created, err := db.GetEvent(ctx, -1, 1337)
+	if err != nil || len(created.Levels) != 1 {
+		t.Fatalf("got %v, %v, want the event with Platinum", created, err)
+	}
+	// Set here, the event service doesn't send it
+	if _, err := db.UpdateLevel(ctx, created.Levels[0].ID, "Platinum", "14500", 3, 10, created.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := `{"id":1337,"name":"Conf 2022","sponsors":[{"name":"Platinum","cost":16000,"freeBadges":12},{"name":"Gold","cost":9000,"freeBadges":5}]}`
+	send(onEventModifiedMessage, modified)
+	send(onEventModifiedMessage, modified)
+
+	event, err := db.GetEvent(ctx, -1, 1337)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]db.Level{}
+	for _, l := range event.Levels {
+		got[l.Name] = l
+	}
+	platinum, gold := got["Platinum"], got["Gold"]
+	if event.Name != "Conf 2022" || len(event.Levels) != 2 {
+		t.Fatalf("got %q with %d levels, want Conf 2022 with Platinum and Gold", event.Name, len(event.Levels))
+	}
+	if platinum.ID != created.Levels[0].ID || platinum.Cost != "16000" || platinum.MaxNumberOfFreeBadges != 12 || platinum.MaxNumberOfSponsors != 3 {
+		t.Errorf("got Platinum %+v, want the same level costing 16000 with 12 badges and still 3 sponsors", platinum)
+	}
+	if gold.Cost != "9000" || gold.MaxNumberOfFreeBadges != 5 {
+		t.Errorf("got Gold %+v, want it added costing 9000 with 5 badges", gold)
+	}
+}
+
+var (
+	recorded     *tracetest.InMemoryExporter
+	recordedOnce sync.Once
+)
+
+// Records the spans of requests from here on. The router's tracer only
+// follows the first global tracer provider set, so every test shares one.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	recordedOnce.Do(func() {
+		recorded = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorded)))
+	})
+	recorded.Reset()
+	return recorded
+}
+
+func TestRequestsAreTraced(t *testing.T) {
+	withEvent(t)
+	exporter := recordSpans(t)
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 200, key)
+
+	// Messages other tests published may still be going out
+	var span *sdktrace.SpanSnapshot
+	for _, s := range exporter.GetSpans() {
+		if s.SpanKind == trace.SpanKindServer {
+			span = s
+		}
+	}
+	if span == nil || span.Name != "/sponsor-service/v1/event/{id}" {
+		t.Fatalf("got %v, want a server span named for the route", span)
+	}
+	attrs := map[string]string{}
+	for _, a := range span.Attributes {
+		attrs[string(a.Key)] = a.Value.Emit()
+	}
+	if attrs["http.method"] != "GET" || attrs["http.status_code"] != "200" || attrs["http.route"] != span.Name {
+		t.Errorf("got attributes %v, want the method, route and status", attrs)
+	}
+}
+
+// Invite links carry their token in the query string, it must never end up
+// in a span
+func TestInviteTokensAreNotTraced(t *testing.T) {
+	withEvent(t)
+	exporter := recordSpans(t)
+
+	invite := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/invites", `{"expiresIn":"72h"}`, 201, key)
+	token, _ := data(invite)["token"].(string)
+	exporter.Reset()
+	call(t, "GET", "/sponsor-service/v1/portal/members?token="+token+"&page=1", "", 200)
+
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("got no spans for the request")
+	}
+	target := ""
+	for _, span := range spans {
+		for _, attr := range span.Attributes {
+			if strings.Contains(attr.Value.Emit(), token) {
+				t.Errorf("span %s has the invite token in %s", span.Name, attr.Key)
+			}
+			if attr.Key == "http.target" {
+				target = attr.Value.AsString()
+			}
+		}
+	}
+	if target != "/sponsor-service/v1/portal/members?page=1" {
+		t.Errorf("http.target got %q, want the path with only the other query parameters", target)
+	}
+}
+
+// Every subtest gets its own database, with the fixtures it needs
+func TestResponsesMatchTheDocument(t *testing.T) {
+	t.Run("events", testEvents)
+	t.Run("invites and the portal", testPortal)
+	t.Run("members", testMembers)
+	t.Run("idempotency", testIdempotency)
+	t.Run("API keys", testAPIKeys)
+	t.Run("errors", testErrors)
+	t.Run("waitlists", testWaitlists)
+	t.Run("reservations", testReservations)
+	t.Run("statuses", testStatuses)
+	t.Run("invoices", testInvoices)
+	t.Run("payments", testPayments)
+	t.Run("payment webhooks", testPaymentWebhooks)
+	t.Run("benefits", testBenefits)
+	t.Run("logos", testLogos)
+	t.Run("badges", testBadges)
+	t.Run("health", testHealth)
+}
+
+// Points the service at a new, empty database with just the bootstrap key
+func freshDB(t *testing.T) {
+	t.Helper()
+	db.InitDB(db.Creds{Driver: db.DriverSqlite, SqlitePath: db.SqliteInMemory})
+	conn := db.Database
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := auth.EnsureBootstrapKey(context.Background(), bootstrapKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// An event with Gold (room for two, two free badges) and Silver (costs 100),
+// Doge Corp on Gold with member 1, and Lolcat Org with no level
+func withEvent(t *testing.T) {
+	t.Helper()
+	freshDB(t)
+	call(t, "POST", "/sponsor-service/v1/event", `{"name":"Conf"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level", `{"name":"Gold","cost":"$250K","maxSponsors":2,"maxFreeBadgesPerSponsor":2}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Doge Corp","level":{"id":1}}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Lolcat Org"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member", `{"name":"First Last","email":"first.last@doge.com"}`, 200, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"name":"Conf 2021","levels":[{"name":"Silver","cost":"100"}]}`, 200, key)
+}
+
+// withEvent, and Corgi Ltd as sponsor 3, contracted on Silver
+func withContractedSponsor(t *testing.T) {
+	t.Helper()
+	withEvent(t)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Corgi Ltd","level":{"id":2}}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"contracted"}`, 200, key)
+}
+
+func testEvents(t *testing.T) {
+	withEvent(t)
+	call(t, "GET", "/sponsor-service/v1/events", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 200, key)
+
+	// Conditional requests, the event is well past its first version by now
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 304, key, `If-None-Match: *`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"name":"Conf 2022"}`, 412, key, `If-Match: "v1"`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"name":"Conf 2022"}`, 200, key, `If-Match: *`)
+
+	call(t, "GET", "/sponsor-service/v1/event/1/level/1", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/level/1", "", 304, key, `If-None-Match: *`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/level/1", `{"maxSponsors":3}`, 200, key, `If-Match: *`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/level/1", `{"cost":"$300K"}`, 412, key, `If-Match: "v1"`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/level/1", `{"name":"Silver"}`, 409, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/level/1", `{"maxSponsors":-1}`, 422, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/level/99", "", 404, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1", "", 304, key, `If-None-Match: *`)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/99", "", 404, key)
+
+	// Levels sent with their event are checked and saved with it
+	level, _ := data(call(t, "GET", "/sponsor-service/v1/event/1/level/1", "", 200, key))["level"].(map[string]interface{})
+	version := int(level["version"].(float64))
+	stale := fmt.Sprintf(`{"name":"Conf 2023","levels":[{"name":"Bronze","cost":"$10K"},{"id":1,"version":%d,"name":"Gold","cost":"$300K"}]}`, version-1)
+	call(t, "PATCH", "/sponsor-service/v1/event/1", stale, 412, key)
+	event, _ := data(call(t, "GET", "/sponsor-service/v1/event/1", "", 200, key))["event"].(map[string]interface{})
+	if event["name"] != "Conf 2022" {
+		t.Errorf("a stale level still renamed the event: %v", event)
+	}
+	call(t, "GET", "/sponsor-service/v1/event/1/level/3", "", 404, key)
+	current := fmt.Sprintf(`{"name":"Conf 2023","levels":[{"name":"Bronze","cost":"$10K"},{"id":1,"version":%d,"name":"Gold","cost":"$300K"}]}`, version)
+	event, _ = data(call(t, "PATCH", "/sponsor-service/v1/event/1", current, 200, key))["event"].(map[string]interface{})
+	levels, _ := event["levels"].([]interface{})
+	if event["name"] != "Conf 2023" || len(levels) != 3 {
+		t.Fatalf("expected the renamed event with 3 levels, got %v", event)
+	}
+	gold := levels[0].(map[string]interface{})
+	if gold["cost"] != "$300K" || int(gold["version"].(float64)) != version+1 {
+		t.Errorf("expected Gold at $300K and version %d, got %v", version+1, gold)
+	}
+}
+
+func testPortal(t *testing.T) {
+	withEvent(t)
+	invite := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/invites", `{"expiresIn":"72h"}`, 201, key)
+	token, _ := data(invite)["token"].(string)
+	call(t, "GET", "/sponsor-service/v1/portal/members?token="+token, "", 200)
+	call(t, "POST", "/sponsor-service/v1/portal/members", `{"name":"Second","email":"second@doge.com"}`, 201, "Authorization: Invite "+token)
+	call(t, "POST", "/sponsor-service/v1/portal/members?token="+token, `{"name":"Third","email":"third@doge.com"}`, 409)
+	call(t, "DELETE", "/sponsor-service/v1/portal/members/2?token="+token, "", 200)
+	call(t, "DELETE", "/sponsor-service/v1/portal/members/99?token="+token, "", 404)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/audit", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/1/invites/1", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/portal/members?token="+token, "", 401)
+}
+
+func testMembers(t *testing.T) {
+	withEvent(t)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/member", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/member/1", "", 200, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1/member/1", `{"email":"first.last@doge.example"}`, 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/member/1", "", 304, key, `If-None-Match: "v2"`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1/member/1", `{"name":"Stale"}`, 412, key, `If-Match: "v1"`)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member", `{"name":"Again","email":"first.last@doge.example"}`, 409, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/1/member/1", "", 501, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/member/99", "", 404, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1/member/1", `{"email":"nope"}`, 422, key)
+}
+
+// Retries with an Idempotency-Key get the first response back
+func testIdempotency(t *testing.T) {
+	withEvent(t)
+	retry := "Idempotency-Key: add-fourth"
+	first := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/member", `{"name":"Fourth","email":"fourth@lolcat.org"}`, 200, key, retry)
+	again := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/member", `{"name":"Fourth","email":"fourth@lolcat.org"}`, 200, key, retry)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("retry got %v, want %v", again, first)
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/member", `{"name":"Fifth","email":"fifth@lolcat.org"}`, 409, key, retry)
+}
+
+func testAPIKeys(t *testing.T) {
+	withEvent(t)
+	newKey := call(t, "POST", "/sponsor-service/v1/admin/api-keys", `{"name":"finance","role":"finance"}`, 200, key)
+	finance := "X-API-Key: " + data(newKey)["key"].(string)
+	newKey = call(t, "POST", "/sponsor-service/v1/admin/api-keys", `{"name":"doge-team","role":"sponsorAdmin","sponsorId":1}`, 200, key)
+	sponsorAdmin := "X-API-Key: " + data(newKey)["key"].(string)
+	call(t, "GET", "/sponsor-service/v1/admin/api-keys", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/admin/api-keys", "", 403, finance)
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 200, finance)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member", `{"name":"Sixth","email":"sixth@doge.com"}`, 403, finance)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/member", "", 200, sponsorAdmin)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/2/member", "", 403, sponsorAdmin)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/member", `{"name":"Sixth","email":"sixth@lolcat.org"}`, 403, sponsorAdmin)
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 403, sponsorAdmin)
+	call(t, "DELETE", "/sponsor-service/v1/admin/api-keys/2", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/admin/api-keys/3", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1", "", 401, finance)
+}
+
+func testErrors(t *testing.T) {
+	withEvent(t)
+	call(t, "GET", "/sponsor-service/v1/events", "", 401)
+	call(t, "GET", "/sponsor-service/v1/event/1337", "", 404, key)
+	call(t, "GET", "/sponsor-service/v1/event/abc", "", 400, key)
+	call(t, "POST", "/sponsor-service/v1/event", `{"name":"","extra":1}`, 422, key)
+	call(t, "POST", "/sponsor-service/v1/event", `{"name":`, 400, key)
+	call(t, "POST", "/sponsor-service/v1/event", `{"name":"Conf"} {"name":"Conf"}`, 400, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level", `{"name":"Gold","cost":"1"}`, 409, key)
+}
+
+func testWaitlists(t *testing.T) {
+	withEvent(t)
+	// Gold only has room for Doge Corp
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"levels":[{"id":1,"name":"Gold","cost":"$250K","maxSponsors":1}]}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Shiba Inc"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Pug Co"}`, 200, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/3", `{"levelId":1}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{"sponsorId":3}`, 201, key)
+	joined := call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{"sponsorId":4}`, 201, key)
+	if entry, _ := data(joined)["entry"].(map[string]interface{}); entry["position"] != 2.0 {
+		t.Errorf("Pug Co's entry got %v, want it second in line", entry)
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{"sponsorId":3}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{"sponsorId":1}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{}`, 422, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist/1/accept", "", 409, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1", `{"levelId":0}`, 412, key, `If-Match: "v9"`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1", `{"name":"Doge Co","levelId":0}`, 200, key)
+	// The spot Doge Co gave up goes to whoever joined first
+	waitlist := call(t, "GET", "/sponsor-service/v1/event/1/level/1/waitlist", "", 200, key)
+	entries, _ := data(waitlist)["waitlist"].([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("waitlist got %v, want Shiba Inc's and Pug Co's entries", entries)
+	}
+	first, second := entries[0].(map[string]interface{}), entries[1].(map[string]interface{})
+	if first["sponsorId"] != 3.0 || first["status"] != "offered" {
+		t.Errorf("first entry got %v, want Shiba Inc's with an offer", first)
+	}
+	if second["sponsorId"] != 4.0 || second["status"] != "waiting" {
+		t.Errorf("second entry got %v, want Pug Co's still waiting", second)
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist/2/accept", "", 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist/1/accept", "", 200, key)
+	sponsor := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3", "", 200, key)
+	if level, _ := data(sponsor)["sponsor"].(map[string]interface{})["level"].(map[string]interface{}); level["id"] != 1.0 {
+		t.Errorf("Shiba Inc's level got %v, want Gold once it accepted", level)
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/waitlist", `{"sponsorId":1}`, 201, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/waitlist/3", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/waitlist/1", "", 404, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/2", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/2", "", 404, key)
+}
+
+func testReservations(t *testing.T) {
+	withEvent(t)
+	// Gold is full once it only has room for Doge Corp
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"levels":[{"id":1,"name":"Gold","cost":"$250K","maxSponsors":1}]}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/reservation", `{"prospect":"Corgi Ltd"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation", `{"prospect":"Corgi Ltd","note":"Signing next week","holdFor":"168h"}`, 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation", `{"prospect":"","holdFor":"1y"}`, 422, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/level/2/reservation", "", 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation/1/convert", "", 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation/1/convert", "", 404, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation", `{"prospect":"Pug Co"}`, 201, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/reservation/2", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/reservation/2", "", 404, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation", `{"prospect":"Doge Corp"}`, 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/reservation/3/convert", "", 409, key)
+}
+
+func testStatuses(t *testing.T) {
+	withEvent(t)
+	// Shiba Inc takes the last spot on Gold
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor", `{"name":"Shiba Inc"}`, 200, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/3", `{"levelId":1}`, 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/status", "", 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"paid"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"contracted"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/status", `{"status":"contracted"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"cancelled"}`, 422, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"cancelled","reason":"Budget cut"}`, 412, key, `If-Match: "v1"`)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/reservation", `{"prospect":"Corgi Ltd"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"cancelled","reason":"Budget cut"}`, 200, key)
+	status := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/status", "", 200, key)
+	if history, _ := data(status)["history"].([]interface{}); len(history) != 3 {
+		t.Errorf("status history got %v, want reserved, contracted and cancelled", history)
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/status", `{"status":"reserved"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/waitlist", `{"sponsorId":3}`, 409, key)
+	// Cancelling gave its spot back
+	call(t, "POST", "/sponsor-service/v1/event/1/level/1/reservation", `{"prospect":"Corgi Ltd"}`, 201, key)
+}
+
+func testInvoices(t *testing.T) {
+	withContractedSponsor(t)
+	created := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice",
+		`{"addOns":[{"description":"Extra booth","quantity":2,"unitAmount":5000}],"taxRate":8.25,"dueDate":"2021-06-30"}`, 201, key)
+	invoice, _ := data(created)["invoice"].(map[string]interface{})
+	if invoice["subtotal"] != 20000.0 || invoice["tax"] != 1650.0 || invoice["total"] != 21650.0 {
+		t.Errorf("invoice got %v, want 20000 with 1650 tax for 21650", invoice)
+	}
+	// Silver's cost, then the add-on
+	wantLines := []struct {
+		kind       string
+		quantity   float64
+		unitAmount float64
+		amount     float64
+	}{
+		{"level", 1, 10000, 10000},
+		{"addOn", 2, 5000, 10000},
+	}
+	lines, _ := invoice["lines"].([]interface{})
+	if len(lines) != len(wantLines) {
+		t.Fatalf("invoice lines got %v, want Silver and the booth", lines)
+	}
+	for i, want := range wantLines {
+		line := lines[i].(map[string]interface{})
+		if line["kind"] != want.kind || line["quantity"] != want.quantity || line["unitAmount"] != want.unitAmount || line["amount"] != want.amount {
+			t.Errorf("invoice line %d got %v, want %+v", i, line, want)
+		}
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice", `{"taxRate":101}`, 422, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice", `{"addOns":[{"description":"Booth","quantity":10001,"unitAmount":1}]}`, 422, key)
+	tooLarge := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice",
+		`{"addOns":[{"description":"Booth","quantity":10000,"unitAmount":1000000000000000}]}`, 422, key)
+	if tooLarge["code"] != "INVOICE_TOO_LARGE" {
+		t.Errorf("an invoice past an int64 got %v, want INVOICE_TOO_LARGE rather than a wrapped total", tooLarge["code"])
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/2/invoice", "", 409, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/invoice?status=draft", "", 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/invoice?status=sent", "", 400, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/invoice/1/status", `{"status":"paid"}`, 409, key)
+	issued := call(t, "POST", "/sponsor-service/v1/event/1/invoice/1/status", `{"status":"issued"}`, 200, key)
+	if invoice, _ := data(issued)["invoice"].(map[string]interface{}); invoice["reference"] != "INV-1-0001" {
+		t.Errorf("invoice reference got %v, want INV-1-0001", invoice["reference"])
+	}
+	status := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/status", "", 200, key)
+	if data(status)["status"] != "invoiced" {
+		t.Errorf("sponsor status got %v, want invoiced once its invoice is issued", data(status)["status"])
+	}
+	rec, _ := callRecorded(t, "GET", "/sponsor-service/v1/event/1/invoice/1", "", 200, key, "Accept: application/pdf")
+	if pages := pdfPages(t, rec); pages != 1 {
+		t.Errorf("invoice PDF got %d pages, want 1", pages)
+	}
+	rec, _ = callRecorded(t, "GET", "/sponsor-service/v1/event/1/invoice/1/pdf", "", 200, key)
+	pdfPages(t, rec)
+	call(t, "GET", "/sponsor-service/v1/event/1/invoice/1", "", 304, key, `If-None-Match: "v2"`)
+	call(t, "POST", "/sponsor-service/v1/event/1/invoice/1/status", `{"status":"paid"}`, 412, key, `If-Match: "v1"`)
+	call(t, "POST", "/sponsor-service/v1/event/1/invoice/1/status", `{"status":"paid"}`, 200, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/invoice/99", "", 404, key)
+}
+
+func testPayments(t *testing.T) {
+	withContractedSponsor(t)
+	// Corgi Ltd's invoice is for 100 with no tax
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice", "", 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/invoice/1/status", `{"status":"issued"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"amount":4000,"method":"bankTransfer","reference":"TRX-1","invoiceId":1}`, 201, key)
+	paid := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"amount":6000,"method":"card","invoiceId":1}`, 201, key)
+	if invoice, _ := data(paid)["invoice"].(map[string]interface{}); invoice["status"] != "paid" {
+		t.Errorf("invoice got %v, want it paid once payments cover it", invoice["status"])
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"amount":100,"method":"cash","invoiceId":99}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"kind":"refund","amount":20000,"method":"bankTransfer"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"kind":"refund","amount":1000,"method":"bankTransfer"}`, 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"kind":"creditNote","amount":500}`, 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/payment", `{"amount":500}`, 422, key)
+	ledger := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/ledger", "", 200, key)
+	if balance, _ := data(ledger)["balance"].(map[string]interface{}); balance["outstanding"] != 500.0 {
+		t.Errorf("outstanding got %v, want 10000 billed less 9000 paid and 500 credited", balance["outstanding"])
+	}
+	report := call(t, "GET", "/sponsor-service/v1/event/1/report", "", 200, key)
+	totals, _ := data(report)["totals"].(map[string]interface{})
+	if totals["invoiced"] != 10000.0 || totals["paid"] != 9000.0 || totals["credited"] != 500.0 || totals["outstanding"] != 500.0 {
+		t.Errorf("report totals got %v, want Corgi Ltd's balance", totals)
+	}
+	if byStatus, _ := data(report)["byStatus"].(map[string]interface{}); byStatus["paid"] != 1.0 {
+		t.Errorf("report statuses got %v, want Corgi Ltd paid", byStatus)
+	}
+}
+
+func testPaymentWebhooks(t *testing.T) {
+	withContractedSponsor(t)
+	// The fixture pays INV-1-0003, 100 like each of Corgi Ltd's invoices
+	for i := 1; i <= 3; i++ {
+		call(t, "POST", "/sponsor-service/v1/event/1/sponsor/3/invoice", "", 201, key)
+		call(t, "POST", fmt.Sprintf("/sponsor-service/v1/event/1/invoice/%d/status", i), `{"status":"issued"}`, 200, key)
+	}
+	payment, signature := webhookFixture(t, "payment_intent.succeeded", webhookSecret, time.Now())
+	received := call(t, "POST", "/sponsor-service/v1/webhooks/payments", payment, 200, signature)
+	if e, _ := data(received)["webhookEvent"].(map[string]interface{}); e["status"] != "processed" || e["duplicate"] != false {
+		t.Errorf("webhook got %v, want it processed", e)
+	}
+	invoice := call(t, "GET", "/sponsor-service/v1/event/1/invoice/3", "", 200, key)
+	if i, _ := data(invoice)["invoice"].(map[string]interface{}); i["status"] != "paid" {
+		t.Errorf("invoice got %v, want it paid by the webhook", i["status"])
+	}
+	replayed := call(t, "POST", "/sponsor-service/v1/webhooks/payments", payment, 200, signature)
+	if e, _ := data(replayed)["webhookEvent"].(map[string]interface{}); e["duplicate"] != true {
+		t.Errorf("webhook sent again got %v, want it marked a duplicate", e)
+	}
+	refund, signature := webhookFixture(t, "refund.created", webhookSecret, time.Now())
+	call(t, "POST", "/sponsor-service/v1/webhooks/payments", refund, 200, signature)
+	other, signature := webhookFixture(t, "customer.created", webhookSecret, time.Now())
+	ignored := call(t, "POST", "/sponsor-service/v1/webhooks/payments", other, 200, signature)
+	if e, _ := data(ignored)["webhookEvent"].(map[string]interface{}); e["status"] != "ignored" {
+		t.Errorf("webhook got %v, want an event we don't act on ignored", e)
+	}
+	_, forged := webhookFixture(t, "payment_intent.succeeded", "whsec_not-ours", time.Now())
+	call(t, "POST", "/sponsor-service/v1/webhooks/payments", payment, 401, forged)
+	_, stale := webhookFixture(t, "payment_intent.succeeded", webhookSecret, time.Now().Add(-time.Hour))
+	call(t, "POST", "/sponsor-service/v1/webhooks/payments", payment, 401, stale)
+	call(t, "POST", "/sponsor-service/v1/webhooks/payments", payment, 401)
+	call(t, "POST", "/sponsor-service/v1/webhooks/payments", "{}", 400, "Stripe-Signature: "+auth.NewWebhooks(webhookSecret, time.Minute).Sign([]byte("{}"), time.Now()))
+	ledger := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/ledger", "", 200, key)
+	if balance, _ := data(ledger)["balance"].(map[string]interface{}); balance["outstanding"] != 22500.0 {
+		t.Errorf("outstanding got %v, want 30000 billed less 10000 paid, plus the 2500 refunded through the provider", balance["outstanding"])
+	}
+}
+
+func testBenefits(t *testing.T) {
+	withContractedSponsor(t)
+	benefit := call(t, "POST", "/sponsor-service/v1/event/1/level/2/benefit", `{"name":"Booth","kind":"booth","details":"3x3m","dueDate":"2021-06-01"}`, 201, key)
+	if data(benefit)["sponsors"] != 1.0 {
+		t.Errorf("benefit went to %v sponsors, want Corgi Ltd's", data(benefit)["sponsors"])
+	}
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/benefit", `{"name":"Booth","kind":"booth"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/benefit", `{"name":"Posts","kind":"social","quantity":3}`, 201, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/level/2/benefit", `{"name":"Swag","kind":"swag","quantity":0}`, 422, key)
+	listed := call(t, "GET", "/sponsor-service/v1/event/1/level/2/benefit", "", 200, key)
+	if benefits, _ := data(listed)["benefits"].([]interface{}); len(benefits) != 2 || benefits[1].(map[string]interface{})["quantity"] != 3.0 {
+		t.Errorf("Silver's benefits got %v, want the booth and 3 posts", benefits)
+	}
+	corgi := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/3/deliverable", "", 200, key)
+	if list, _ := data(corgi)["deliverables"].([]interface{}); len(list) != 2 {
+		t.Errorf("Corgi Ltd's deliverables got %v, want one for each of Silver's benefits", list)
+	}
+	overdue := call(t, "GET", "/sponsor-service/v1/event/1/deliverable?overdue=true", "", 200, key)
+	if deliverables, _ := data(overdue)["deliverables"].([]interface{}); len(deliverables) != 1 {
+		t.Errorf("overdue deliverables got %v, want Corgi Ltd's booth", deliverables)
+	}
+	call(t, "GET", "/sponsor-service/v1/event/1/deliverable?overdue=maybe", "", 400, key)
+	delivered := call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/3/deliverable/1", `{"status":"delivered","owner":"Sam"}`, 200, key)
+	if d, _ := data(delivered)["deliverable"].(map[string]interface{}); d["owner"] != "Sam" || d["deliveredAt"] == nil || d["overdue"] != false {
+		t.Errorf("deliverable got %v, want it delivered by Sam", d)
+	}
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/3/deliverable/1", `{"status":"waived"}`, 412, key, `If-Match: "v1"`)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/3/deliverable/1", `{"status":"lost"}`, 422, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1/deliverable/1", `{"status":"waived"}`, 404, key)
+	overdue = call(t, "GET", "/sponsor-service/v1/event/1/deliverable?overdue=true", "", 200, key)
+	if deliverables, _ := data(overdue)["deliverables"].([]interface{}); len(deliverables) != 0 {
+		t.Errorf("overdue deliverables got %v, want none once the booth is delivered", deliverables)
+	}
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1", `{"levelId":2}`, 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/benefit/2", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/level/2/benefit/2", "", 404, key)
+	deliverables := call(t, "GET", "/sponsor-service/v1/event/1/sponsor/1/deliverable", "", 200, key)
+	if list, _ := data(deliverables)["deliverables"].([]interface{}); len(list) != 1 || list[0].(map[string]interface{})["name"] != "Booth" {
+		t.Errorf("Doge Corp's deliverables got %v, want a booth from moving onto Silver", list)
+	}
+}
+
+func testLogos(t *testing.T) {
+	withEvent(t)
+	upload, contentType := logoUpload(t, "logo.png", pngImage(t, 600, 300))
+	uploaded := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", upload, 200, key, contentType)
+	logo, _ := data(uploaded)["sponsor"].(map[string]interface{})["logo"].(map[string]interface{})
+	if logo["width"] != 600.0 || logo["contentType"] != "image/png" {
+		t.Errorf("logo got %v, want the 600x300 PNG", logo)
+	}
+	url, _ := logo["url"].(string)
+	thumbnail, _ := logo["thumbnailUrl"].(string)
+	asset := strings.TrimPrefix(url, router.PublicURL)
+	call(t, "GET", asset, "", 200)
+	call(t, "GET", strings.TrimPrefix(thumbnail, router.PublicURL), "", 200)
+	events := call(t, "GET", "/sponsor-service/v1/events", "", 200, key)
+	for _, s := range data(events)["events"].([]interface{})[0].(map[string]interface{})["sponsors"].([]interface{}) {
+		if sponsor := s.(map[string]interface{}); sponsor["id"] == 1.0 && sponsor["logo"] == nil {
+			t.Errorf("Doge Corp got %v, want its logo in the list of events", sponsor)
+		}
+	}
+	upload, contentType = logoUpload(t, "logo.png", "not an image")
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", upload, 422, key, contentType)
+	upload, contentType = logoUpload(t, "logo.png", pngImage(t, 20, 20))
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", upload, 422, key, contentType)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", `{"file":"logo.png"}`, 400, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", "--x--\r\n", 422, key, "Content-Type: multipart/form-data; boundary=x")
+	upload, contentType = logoUpload(t, "logo.png", pngImage(t, 100, 100))
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/logo", upload, 200, key, contentType)
+	call(t, "GET", asset, "", 404)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/1/logo", "", 200, key)
+	call(t, "DELETE", "/sponsor-service/v1/event/1/sponsor/1/logo", "", 404, key)
+	call(t, "GET", "/sponsor-service/v1/assets/Logo.png", "", 404)
+}
+
+func testBadges(t *testing.T) {
+	withEvent(t)
+	// Silver has no free badges until it's given two
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1", `{"levelId":2}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member/1/badge", "", 409, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1", `{"levels":[{"id":2,"name":"Silver","cost":"100","maxFreeBadgesPerSponsor":2}]}`, 200, key)
+	issuedBadge := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member/1/badge", "", 201, key)
+	badge, _ := data(issuedBadge)["badge"].(map[string]interface{})
+	if badge["levelLabel"] != "Silver" || badge["sponsor"] != "Doge Corp" {
+		t.Errorf("badge got %v, want one for Doge Corp on Silver", badge)
+	}
+	code, _ := badge["code"].(string)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member/1/badge", "", 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/member/99/badge", "", 404, key)
+	call(t, "PATCH", "/sponsor-service/v1/event/1/sponsor/1/member/1", `{"name":"First Renamed"}`, 200, key)
+	found := call(t, "GET", "/sponsor-service/v1/event/1/badge?code="+strings.ToLower(code), "", 200, key)
+	if badges, _ := data(found)["badges"].([]interface{}); len(badges) != 1 || badges[0].(map[string]interface{})["name"] != "First Renamed" {
+		t.Errorf("badges with code %s got %v, want member 1's with their new name", code, badges)
+	}
+	call(t, "GET", "/sponsor-service/v1/event/1/badge?status=lost", "", 400, key)
+	rec, _ := callRecorded(t, "GET", "/sponsor-service/v1/event/1/badge/sheet?sponsorId=1", "", 200, key)
+	if pages := pdfPages(t, rec); pages != 1 {
+		t.Errorf("badge sheet got %d pages, want 1 for member 1's badge", pages)
+	}
+	rec, _ = callRecorded(t, "GET", "/sponsor-service/v1/event/1/badge/1/png?size=128", "", 200, key)
+	if img, err := png.Decode(rec.Body); err != nil || img.Bounds().Dx() != 128 {
+		t.Errorf("badge QR code got %v, want a 128px PNG", err)
+	}
+	call(t, "GET", "/sponsor-service/v1/event/1/badge/1/png?size=5000", "", 400, key)
+	call(t, "GET", "/sponsor-service/v1/event/1/badge/99/png", "", 404, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/badge/1/status", `{"status":"printed"}`, 412, key, `If-Match: "v1"`)
+	call(t, "POST", "/sponsor-service/v1/event/1/badge/1/status", `{"status":"printed"}`, 200, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/badge/1/status", `{"status":"issued"}`, 409, key)
+	call(t, "POST", "/sponsor-service/v1/event/1/badge/1/status", `{"status":"lost"}`, 422, key)
+	invite := call(t, "POST", "/sponsor-service/v1/event/1/sponsor/1/invites", "", 201, key)
+	token, _ := data(invite)["token"].(string)
+	call(t, "DELETE", "/sponsor-service/v1/portal/members/1?token="+token, "", 200)
+	revoked := call(t, "GET", "/sponsor-service/v1/event/1/badge?status=revoked", "", 200, key)
+	if badges, _ := data(revoked)["badges"].([]interface{}); len(badges) != 1 || badges[0].(map[string]interface{})["revokedAt"] == nil {
+		t.Errorf("revoked badges got %v, want member 1's once they're taken off the team", badges)
+	}
+	call(t, "GET", "/sponsor-service/v1/event/1/badge/1/png", "", 404, key)
+}
+
+func testHealth(t *testing.T) {
+	freshDB(t)
+	call(t, "GET", "/healthz", "", 200)
+	call(t, "GET", "/readyz", "", 200)
+	circuitbreaker.CB.Trip()
+	ready := call(t, "GET", "/readyz", "", 503)
+	if check, _ := ready["checks"].(map[string]interface{})["circuitBreaker"].(map[string]interface{}); check["status"] != "down" || check["error"] != "circuit breaker is tripped" {
+		t.Errorf("/readyz got %v, want the tripped circuit breaker named", ready)
+	}
+	circuitbreaker.CB.Reset()
+	call(t, "GET", "/readyz", "", 200)
+	call(t, "GET", "/metrics", "", 200)
+}
+
+// Makes a request, checks its status and that the response matches the
+// document, and returns the decoded body. headers are "Name: value".
+func call(t *testing.T, method string, path string, body string, status int, headers ...string) map[string]interface{} {
+	t.Helper()
+	_, out := callRecorded(t, method, path, body, status, headers...)
+	return out
+}
+
+// call, also returning the response, for the ones that aren't JSON
+func callRecorded(t *testing.T, method string, path string, body string, status int, headers ...string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for _, h := range headers {
+		parts := strings.SplitN(h, ": ", 2)
+		req.Header.Set(parts[0], parts[1])
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+
+	if rec.Code != status {
+		t.Errorf("%s %s: got %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+
+	var match mux.RouteMatch
+	if !testRouter.Match(req, &match) || match.Route == nil {
+		t.Errorf("%s %s: no route", method, path)
+		return rec, nil
+	}
+	template, _ := match.Route.GetPathTemplate()
+	op := operation(method, template)
+	if op == nil {
+		t.Errorf("%s %s is not in /openapi.json", method, template)
+		return rec, nil
+	}
+
+	responses := op["responses"].(map[string]interface{})
+	response, ok := responses[fmt.Sprint(rec.Code)].(map[string]interface{})
+	if !ok {
+		response, ok = responses["default"].(map[string]interface{})
+	}
+	if !ok {
+		t.Errorf("%s %s: /openapi.json has no %d response", method, template, rec.Code)
+		return rec, nil
+	}
+	response = resolve(response)
+	documented, _ := response["headers"].(map[string]interface{})
+	for name := range documented {
+		if rec.Header().Get(name) == "" {
+			t.Errorf("%s %s (%d): no %s header", method, path, rec.Code, name)
+		}
+	}
+	if response["content"] == nil {
+		if rec.Body.Len() > 0 {
+			t.Errorf("%s %s: /openapi.json says %d has no body, got %s", method, template, rec.Code, rec.Body.String())
+		}
+		return rec, nil
+	}
+
+	contentType := strings.TrimSpace(strings.Split(rec.Header().Get("Content-Type"), ";")[0])
+	content, ok := response["content"].(map[string]interface{})[contentType].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s %s: /openapi.json has no %s content for %d", method, template, contentType, rec.Code)
+		return rec, nil
+	}
+	if !strings.Contains(contentType, "json") {
+		return rec, nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Errorf("%s %s: response is not JSON: %v", method, path, err)
+		return rec, nil
+	}
+	for _, problem := range validate(content["schema"].(map[string]interface{}), decoded, "response") {
+		t.Errorf("%s %s (%d): %s", method, path, rec.Code, problem)
+	}
+	out, _ := decoded.(map[string]interface{})
+	return rec, out
+}
+
+// Reads testdata/webhooks/<name>.json and signs it with secret, returning
+// the body and its signature header
+func webhookFixture(t *testing.T, name string, secret string, at time.Time) (string, string) {
+	t.Helper()
+	body, err := ioutil.ReadFile("testdata/webhooks/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), auth.WebhookSignatureHeader + ": " + auth.NewWebhooks(secret, time.Minute).Sign(body, at)
+}
+
+// A multipart/form-data body with content as its file field, and the
+// Content-Type header to send it with
+func logoUpload(t *testing.T, filename string, content string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+	return body.String(), "Content-Type: " + form.FormDataContentType()
+}
+
+// A PNG of a red square, as a string
+func pngImage(t *testing.T, width int, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// A PDF page object, /Pages being the list of them
+var pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// Checks rec is a PDF and returns how many pages it has
+func pdfPages(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/pdf" {
+		t.Errorf("got Content-Type %q, want application/pdf", contentType)
+	}
+	if !bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-")) {
+		t.Errorf("got %.20q, want a PDF", rec.Body.String())
+	}
+	return len(pdfPage.FindAll(rec.Body.Bytes(), -1))
+}
+
+func data(body map[string]interface{}) map[string]interface{} {
+	d, _ := body["data"].(map[string]interface{})
+	return d
+}
+
+func operation(method string, path string) map[string]interface{} {
+	item, ok := spec["paths"].(map[string]interface{})[path].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	op, _ := item[strings.ToLower(method)].(map[string]interface{})
+	return op
+}
+
+// Follows a $ref, like #/components/schemas/Level
+func resolve(node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var target interface{} = spec
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = target.(map[string]interface{})[part]
+		}
+		node = target.(map[string]interface{})
+	}
+}
+
+// Checks value against the parts of JSON schema the document uses,
+// and returns what's wrong with it
+func validate(schema map[string]interface{}, value interface{}, at string) []string {
+	schema = resolve(schema)
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+
+	if options, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, o := range options {
+			if o == value {
+				found = true
+			}
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s is %v, which isn't one of %v", at, value, options)}
+		}
+	}
+
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s is missing", at, name))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, validate(property, object[name], at+"."+name)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s.%s is not in the document", at, name))
+				}
+			case map[string]interface{}:
+				problems = append(problems, validate(additional, object[name], at+"."+name)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+		for i, item := range items {
+			problems = append(problems, validate(schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is not a date-time: %q", at, s))
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return []string{at + " is not an integer"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + " is not a boolean"}
+		}
+	}
+	return problems
+}
+
+// The document has to be valid JSON with every $ref pointing somewhere
+func TestDocumentReferencesResolve(t *testing.T) {
+	var walk func(node interface{}, at string)
+	walk = func(node interface{}, at string) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := n["$ref"].(string); ok {
+				var target interface{} = spec
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, _ := target.(map[string]interface{})
+					target = m[part]
+				}
+				if target == nil {
+					t.Errorf("%s: %s doesn't point at anything", at, ref)
+				}
+			}
+			for k, v := range n {
+				walk(v, at+"/"+k)
+			}
+		case []interface{}:
+			for i, v := range n {
+				walk(v, fmt.Sprintf("%s/%d", at, i))
+			}
+		}
+	}
+	walk(spec, "#")
+
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("openapi is %v, want 3.0.3", spec["openapi"])
+	}
+	if _, ok := spec["paths"].(map[string]interface{}); !ok {
+		t.Fatal("/openapi.json has no paths")
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET /openapi.json: got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}